@@ -1,15 +1,323 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"goline/server"
+	"goline/solver"
+	"goline/trainer"
+	"goline/util"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 var Usage = func() {
 	fmt.Println("USAGE: goline [config errorfile path] ...")
+	fmt.Println("       goline profile train_file [test_file] [threads] [topn]")
+	fmt.Println("       goline stream input(-|fifo|tcp://host:port) model_file feat_num [threads] [checkpoint] [last_model]")
+	fmt.Println("       goline search grid|random|halving train_file test_file out_dir space [epoch] [trials] [threads] [parallel]")
+	fmt.Println("       goline cv ftrl|lockfree|fast|minibatch folds train_file work_dir alpha beta l1 l2 dropout [epoch] [threads] [final_model]")
+	fmt.Println("       goline bench train_file [test_file] [threads] [epoch] [trainers]")
+	fmt.Println("       goline ps listen_addr")
+	fmt.Println("       goline dist ps_addrs workers train_file model_file alpha beta l1 l2 dropout [test_file] [range|hash] [group_size]")
+	fmt.Println("       goline worker ps_addrs part workers train_file [threads] [epoch]")
+}
+
+//统计样本文件并以json输出
+func profile(args []string) {
+	if len(args) < 1 {
+		Usage()
+		return
+	}
+
+	var test_file string
+	threads := 0
+	topn := 100
+	if len(args) > 1 {
+		test_file = args[1]
+	}
+	if len(args) > 2 {
+		threads = util.String2Int(args[2])
+	}
+	if len(args) > 3 {
+		topn = util.String2Int(args[3])
+	}
+
+	p, err := trainer.ProfileFile(args[0], test_file, threads, topn, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(b))
+}
+
+//从标准输入、命名管道或tcp连接流式训练
+func stream(args []string) {
+	if len(args) < 3 {
+		Usage()
+		return
+	}
+
+	var reader io.Reader
+	switch {
+	case args[0] == "-":
+		reader = os.Stdin
+	case strings.HasPrefix(args[0], "tcp://"):
+		conn, err := net.Dial("tcp", strings.TrimPrefix(args[0], "tcp://"))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		reader = conn
+	default:
+		fs, err := os.Open(args[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer fs.Close()
+		reader = fs
+	}
+
+	conf := trainer.StreamConfig{FeatNum: util.String2Int(args[2])}
+	threads := 0
+	if len(args) > 3 {
+		threads = util.String2Int(args[3])
+	}
+	if len(args) > 4 {
+		conf.Checkpoint = int64(util.String2Int(args[4]))
+	}
+	if len(args) > 5 {
+		conf.LastModel = args[5]
+	}
+
+	var fft trainer.FastFtrlTrainer
+	fft.SetJobName("streamjob")
+	fft.Initialize(1, threads, false, 0, 10, 10)
+	err := fft.TrainStream(0.1, 1, 10, 10, 0.1, args[1], reader, conf)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//超参数搜索，输出排行榜json
+func search(args []string) {
+	if len(args) < 5 {
+		Usage()
+		return
+	}
+
+	space, err := trainer.ParseSearchSpace(args[4])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	conf := trainer.SearchConfig{Method: args[0]}
+	conf.CacheFeatureNum = true
+	conf.JobName = "searchjob"
+	if len(args) > 5 {
+		conf.Epoch = util.String2Int(args[5])
+	}
+	if len(args) > 6 {
+		conf.Trials = util.String2Int(args[6])
+	}
+	if len(args) > 7 {
+		conf.NumThreads = util.String2Int(args[7])
+	}
+	if len(args) > 8 {
+		conf.Parallel = util.String2Int(args[8])
+	}
+
+	report, err := trainer.HyperSearch(context.Background(), conf, space, args[1], args[2], args[3])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(b))
+}
+
+//k折交叉验证，给出final_model时再用全部数据训练最终模型
+func cv(args []string) {
+	if len(args) < 9 {
+		Usage()
+		return
+	}
+
+	conf := trainer.CVConfig{
+		Trainer: args[0],
+		Folds:   util.String2Int(args[1]),
+		Alpha:   util.String2Float64(args[4]),
+		Beta:    util.String2Float64(args[5]),
+		L1:      util.String2Float64(args[6]),
+		L2:      util.String2Float64(args[7]),
+		Dropout: util.String2Float64(args[8])}
+	conf.CacheFeatureNum = true
+	conf.JobName = "cvjob"
+	if len(args) > 9 {
+		conf.Epoch = util.String2Int(args[9])
+	}
+	if len(args) > 10 {
+		conf.NumThreads = util.String2Int(args[10])
+	}
+
+	var model_file string
+	if len(args) > 11 {
+		model_file = args[11]
+		conf.Final = true
+	}
+
+	report, err := trainer.CrossValidate(context.Background(), conf, args[2], args[3], model_file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(b))
+}
+
+//比较各训练器的训练吞吐，trainers以逗号分隔，如lockfree,fast,minibatch
+func bench(args []string) {
+	if len(args) < 1 {
+		Usage()
+		return
+	}
+
+	conf := trainer.BenchConfig{Alpha: 0.1, Beta: 1, L1: 1, L2: 1}
+	conf.CacheFeatureNum = true
+	var test_file string
+	var trainers []string
+	if len(args) > 1 && args[1] != "-" {
+		test_file = args[1]
+	}
+	if len(args) > 2 {
+		conf.NumThreads = util.String2Int(args[2])
+	}
+	if len(args) > 3 {
+		conf.Epoch = util.String2Int(args[3])
+	}
+	if len(args) > 4 {
+		trainers = strings.Split(args[4], ",")
+	}
+
+	results, err := trainer.BenchmarkTrainers(context.Background(), conf, trainers, args[0], test_file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(b))
+}
+
+//参数服务器分片进程，收到SIGTERM/SIGINT时退出
+func ps(args []string) {
+	if len(args) < 1 {
+		Usage()
+		return
+	}
+
+	listener, err := solver.ServeParamShard(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer listener.Close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	<-sig
+}
+
+//多进程训练的协调进程，初始化各分片，全部worker完成后保存模型
+func dist(args []string) {
+	if len(args) < 9 {
+		Usage()
+		return
+	}
+
+	var dft trainer.DistFtrlTrainer
+	dft.SetJobName("distjob")
+	dft.Initialize(1, 0, true, 0, 0)
+	var test_file string
+	if len(args) > 9 && args[9] != "-" {
+		test_file = args[9]
+	}
+	sharding := solver.ShardRange
+	if len(args) > 10 {
+		sharding = args[10]
+	}
+	group_size := 0
+	if len(args) > 11 {
+		group_size = util.String2Int(args[11])
+	}
+	dft.SetSharding(sharding, group_size, "")
+
+	err := dft.Coordinate(strings.Split(args[0], ","), util.String2Int(args[1]),
+		util.String2Float64(args[4]), util.String2Float64(args[5]), util.String2Float64(args[6]),
+		util.String2Float64(args[7]), util.String2Float64(args[8]), args[3], args[2], test_file)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//多进程训练的worker进程，训练数据的第part个分区
+func worker(args []string) {
+	if len(args) < 4 {
+		Usage()
+		return
+	}
+
+	threads := 0
+	epoch := 1
+	if len(args) > 4 {
+		threads = util.String2Int(args[4])
+	}
+	if len(args) > 5 {
+		epoch = util.String2Int(args[5])
+	}
+
+	var dft trainer.DistFtrlTrainer
+	dft.SetJobName(fmt.Sprintf("workerjob-%s", args[1]))
+	dft.Initialize(epoch, threads, true, 0, 0)
+	dft.SetPartition(util.String2Int(args[1]), util.String2Int(args[2]))
+	err := dft.TrainWorker(strings.Split(args[0], ","), args[3])
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 func main() {
@@ -19,6 +327,46 @@ func main() {
 		return
 	}
 
+	if args[1] == "profile" {
+		profile(args[2:])
+		return
+	}
+
+	if args[1] == "stream" {
+		stream(args[2:])
+		return
+	}
+
+	if args[1] == "search" {
+		search(args[2:])
+		return
+	}
+
+	if args[1] == "cv" {
+		cv(args[2:])
+		return
+	}
+
+	if args[1] == "bench" {
+		bench(args[2:])
+		return
+	}
+
+	if args[1] == "ps" {
+		ps(args[2:])
+		return
+	}
+
+	if args[1] == "dist" {
+		dist(args[2:])
+		return
+	}
+
+	if args[1] == "worker" {
+		worker(args[2:])
+		return
+	}
+
 	plugin := &server.Lands{}
 	//"..\\conf\\settings.conf"
 	fmt.Println(args[1])
@@ -27,6 +375,15 @@ func main() {
 		fmt.Println(err)
 		return
 	}
+	//restart.sh通过kill重启，退出前取消运行中的任务，避免留下写了一半的模型文件
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		plugin.Shutdown()
+		os.Exit(0)
+	}()
+
 	server := http.Server{
 		Addr:        ":9090",
 		Handler:     plugin,
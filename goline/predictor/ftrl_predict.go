@@ -1,6 +1,8 @@
 package predictor
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"goline/solver"
@@ -10,6 +12,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"sync/atomic"
 )
 
 const (
@@ -123,6 +126,11 @@ func calc_auc(scores util.Dvector) float64 {
 }
 
 func Run(argc int, argv []string) (string, error) {
+	return RunContext(context.Background(), argc, argv)
+}
+
+//ctx取消或超时时停止预测，删除写了一半的结果文件
+func RunContext(ctx context.Context, argc int, argv []string) (string, error) {
 
 	var job_name string
 	var test_file string
@@ -180,8 +188,8 @@ func Run(argc int, argv []string) (string, error) {
 	pcnt := 0     //正样本总数
 	ncorrect := 0 //负样本预测正确数
 	var loss float64 = 0.
-	var parser trainer.FileParser
-	err := parser.OpenFile(test_file)
+	//训练时评估生成的二进制缓存有效时直接读缓存，单线程读取保证输出与样本顺序一致
+	parser, err := trainer.OpenSampleReader(test_file, 1, model.Dict, trainer.CacheReuse)
 	if err != nil {
 		log.Error("[Predictor-Run] Open file error." + err.Error())
 		return fmt.Sprintf(errorjson, err.Error()), errors.New("[Predictor-Run] Open file error." + err.Error())
@@ -189,8 +197,12 @@ func Run(argc int, argv []string) (string, error) {
 
 	var pred_scores util.Dvector
 
-	for {
-		res, y, x := parser.ReadSample()
+	var stop int32 = 0
+	unwatch := util.WatchContext(ctx, &stop)
+	defer unwatch()
+
+	for atomic.LoadInt32(&stop) == 0 {
+		res, y, x := parser.ReadSample(0)
 		if res != nil {
 			break
 		}
@@ -228,6 +240,14 @@ func Run(argc int, argv []string) (string, error) {
 
 	}
 
+	if err := ctx.Err(); err != nil {
+		parser.CloseFile(1)
+		wfp.Close()
+		os.Remove(output_file)
+		log.Error(fmt.Sprintf("[Predictor-RunContext] Prediction interrupted after %d samples.%s", cnt, err.Error()))
+		return fmt.Sprintf(errorjson, err.Error()), errors.New(fmt.Sprintf("[Predictor-RunContext] Prediction interrupted after %d samples.%s", cnt, err.Error()))
+	}
+
 	auc := calc_auc(pred_scores)
 	if auc < 0.5 {
 		auc = 0.5
@@ -245,7 +265,7 @@ func Run(argc int, argv []string) (string, error) {
 		log.Info(fmt.Sprintf("[%s] AUC = %f\n", job_name, auc))
 	}
 
-	parser.CloseFile()
+	parser.CloseFile(1)
 
 	util.Write2File(output_file, fmt.Sprintf(" Log-likelihood = %f\n Precision = %f (%d/%d)\n Recall = %f (%d/%d)\n Accuracy = %f (%d/%d)\n AUC = %f\n",
 		float64(loss)/float64(cnt),
@@ -275,7 +295,7 @@ func StreamRun(model_file string, instances []string) (string, error) {
 	var model solver.LRModel
 	model.Initialize(model_file)
 	for i := 0; i < len(instances); i++ {
-		res, _, x := util.ParseSample(instances[i])
+		res, _, x := util.ParseSampleWithDict(instances[i], model.Dict)
 		if res != nil {
 			break
 		}
@@ -291,3 +311,43 @@ func StreamRun(model_file string, instances []string) (string, error) {
 
 	return fmt.Sprintf(streamjson, rtstr), nil
 }
+
+type explainResult struct {
+	Score    float64               `json:"score"`
+	Features []solver.Contribution `json:"features"`
+}
+
+//预估并返回每个样本贡献最大的topn个特征(使用特征字典中的特征名)
+func StreamExplainRun(model_file string, instances []string, topn int) (string, error) {
+	log := util.GetLogger()
+	if !util.FileExists(model_file) || len(instances) == 0 {
+		log.Error("[Predictor-StreamExplainRun] Model file or instances error.")
+		return fmt.Sprintf(errorjson, "[Predictor-StreamExplainRun] Model file or instances error."), errors.New("[Predictor-StreamExplainRun] Model file or instances error.")
+	}
+
+	var model solver.LRModel
+	err := model.Initialize(model_file)
+	if err != nil {
+		return fmt.Sprintf(errorjson, err.Error()), err
+	}
+
+	var results []explainResult
+	for i := 0; i < len(instances); i++ {
+		res, _, x := util.ParseSampleWithDict(instances[i], model.Dict)
+		if res != nil {
+			break
+		}
+
+		pred := model.Predict(x)
+		pred = math.Max(math.Min(pred, 1.-10e-15), 10e-15)
+		results = append(results, explainResult{util.Round(pred, 6), model.Explain(x, topn)})
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		log.Error("[Predictor-StreamExplainRun] Encode result error." + err.Error())
+		return fmt.Sprintf(errorjson, err.Error()), errors.New("[Predictor-StreamExplainRun] Encode result error." + err.Error())
+	}
+
+	return fmt.Sprintf(streamjson, string(b[1:len(b)-1])), nil
+}
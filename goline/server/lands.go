@@ -2,6 +2,7 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +19,7 @@ import (
 	"strconv"
 	"strings"
 	s "strings"
+	"sync"
 	"time"
 )
 
@@ -26,12 +28,27 @@ type Lands struct {
 	mux        map[string]func(http.ResponseWriter, *util.ModelParam) error
 	conf       *util.Config
 	log4goline log4go.Logger
+
+	jobs      map[string]running_job //运行中的训练、预测任务，key为任务名
+	jobs_lock sync.Mutex
+	jobs_wg   sync.WaitGroup
+}
+
+type running_job struct {
+	biz    string
+	cancel context.CancelFunc
 }
 
 const (
 	JsonError        = "{\"returncode\": 1,\"message\": \"%s\",\"result\": []}"
+	SearchJson       = "{\"returncode\": 0,\"message\": \"best model %s\",\"leaderboard\": %s,\"result\": %s}"
+	OnlineJson       = "{\"returncode\": 0,\"message\": \"ok\",\"progressive\": %s,\"result\": %s}"
+	CancelJson       = "{\"returncode\": 0,\"message\": \"%d jobs canceled\",\"result\": %s}"
+	ValidateJson     = "{\"returncode\": %d,\"message\": %s,\"validation\": %s,\"result\": %s}"
+	ValidSearchJson  = "{\"returncode\": 0,\"message\": \"best model %s\",\"validation\": %s,\"leaderboard\": %s,\"result\": %s}"
 	TimeFormatString = "200601021504"
 	ModelPrefix      = "md_"
+	ExplainTopN      = 10
 )
 
 func (lan *Lands) Initialize(configFile string) error {
@@ -41,6 +58,9 @@ func (lan *Lands) Initialize(configFile string) error {
 	lan.mux["/goline/online"] = lan.onlineServeHttp
 	lan.mux["/goline/offline"] = lan.offlineServeHttp
 	lan.mux["/goline/predict"] = lan.predictServeHttp
+	lan.mux["/goline/profile"] = lan.profileServeHttp
+	lan.mux["/goline/cancel"] = lan.cancelServeHttp
+	lan.jobs = make(map[string]running_job)
 
 	file, err := ioutil.ReadFile(configFile)
 	if err != nil {
@@ -68,6 +88,56 @@ func (lan *Lands) Initialize(configFile string) error {
 	return nil
 }
 
+//登记任务，timeout(秒)大于0时超时自动取消，返回的函数在任务结束时调用
+func (lan *Lands) startJob(job_name string, par *util.ModelParam) (context.Context, func()) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if par.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(par.Timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	lan.jobs_lock.Lock()
+	lan.jobs[job_name] = running_job{biz: par.Biz, cancel: cancel}
+	lan.jobs_wg.Add(1)
+	lan.jobs_lock.Unlock()
+
+	return ctx, func() {
+		lan.jobs_lock.Lock()
+		delete(lan.jobs, job_name)
+		lan.jobs_lock.Unlock()
+		cancel()
+		lan.jobs_wg.Done()
+	}
+}
+
+//取消biz的运行中任务，biz为空时取消全部，返回被取消的任务名
+func (lan *Lands) cancelJobs(biz string) []string {
+	lan.jobs_lock.Lock()
+	defer lan.jobs_lock.Unlock()
+
+	names := []string{}
+	for name, job := range lan.jobs {
+		if len(biz) == 0 || job.biz == biz {
+			job.cancel()
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+//取消全部运行中的任务并等待退出，用于进程退出前清理
+func (lan *Lands) Shutdown() {
+	names := lan.cancelJobs("")
+	if len(names) != 0 {
+		lan.log4goline.Info(fmt.Sprintf("[Lands-Shutdown] Cancel running jobs %v.", names))
+	}
+
+	lan.jobs_wg.Wait()
+}
+
 func (lan *Lands) createHdfsClient() (*hdfs.Client, error) {
 	var err error
 	var client *hdfs.Client
@@ -87,69 +157,143 @@ func (lan *Lands) createHdfsClient() (*hdfs.Client, error) {
 	return client, nil
 }
 
-func (lan *Lands) checkData(filename string) (int64, error) {
-	var count int64 = 0
-	fs, err := os.Open(filename)
+//样本格式检查，validate=strict时按错误预算剔除错误行并写入隔离文件，否则遇到错误行即失败
+//将训练数据划分为训练集和测试集，split为空时按random划分
+func (lan *Lands) splitData(train_path string, test_path string, par *util.ModelParam) error {
+	conf := util.SplitConfig{
+		Method:    par.Split,
+		TestRatio: par.SplitRatio,
+		Key:       par.SplitKey}
+	if len(conf.Method) == 0 {
+		conf.Method = util.SplitRandom
+	}
+
+	all_path := train_path + ".all"
+	err := os.Rename(train_path, all_path)
 	if err != nil {
-		lan.log4goline.Error("[Lands-CheckData] Open file failed." + err.Error())
-		return 0, errors.New("[Lands-CheckData] Open file failed." + err.Error())
+		return err
 	}
 
-	defer fs.Close()
+	summary, err := util.SplitDataset(all_path, train_path, "", test_path, conf)
+	if err != nil {
+		return err
+	}
 
-	buf := bufio.NewReader(fs)
+	lan.log4goline.Info(fmt.Sprintf("[Lands-splitData] method=%s ratio=%.2f lines=%d train=%d test=%d nokey=%d",
+		conf.Method, conf.TestRatio, summary.Lines, summary.TrainLines, summary.TestLines, summary.NoKeyLines))
 
-	for {
-		line, err := buf.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				return count, nil
-			} else {
-				lan.log4goline.Error("[Lands-CheckData] Open file failed." + err.Error())
-				return 0, errors.New("[Lands-CheckData] Open file failed." + err.Error())
-			}
-		}
-		line = s.TrimSpace(line)
-		if len(line) == 0 {
-			continue
-		}
+	return os.Remove(all_path)
+}
 
-		sp := s.Split(line, lan.conf.SampleSpliter)
-		count++
-		if len(sp) <= 1 {
-			str := fmt.Sprintf("[Lands-CheckData] (spliter must be space) file %s,line %d,content %s", filename, count, line)
-			lan.log4goline.Error(str)
-			return 0, errors.New(fmt.Sprintf(JsonError, str)) //分隔符错误(不是space)
-		}
+//search不为空时在space给出的参数空间上搜索超参数，最好的模型写入model_path并载入fft
+func (lan *Lands) searchModel(
+	ctx context.Context,
+	par *util.ModelParam,
+	fft *trainer.FastFtrlTrainer,
+	model_path string,
+	train_path string,
+	test_path string,
+	out_dir string) (*trainer.SearchReport, error) {
+
+	space, err := trainer.ParseSearchSpace(par.Space)
+	if err != nil {
+		return nil, err
+	}
+	space.Default(par.Alpha, par.Beta, par.L1, par.L2, par.Dropout)
+
+	conf := trainer.SearchConfig{
+		Method:      par.Search,
+		Metric:      par.Metric,
+		Trials:      par.Trials,
+		Parallel:    par.Parallel,
+		Seed:        int64(par.Seed),
+		Preprocess:  fft.Preprocess,
+		Cross:       fft.Cross,
+		SampleRates: fft.SampleRates}
+	conf.Epoch = par.Epoch
+	conf.NumThreads = par.Threads
+	conf.PushStep = par.Push
+	conf.FetchStep = par.Fetch
+	conf.CacheFeatureNum = true
+	conf.JobName = fft.JobName
+	conf.ParamGroupSize = par.GroupSize
+	conf.LockMode = par.Lock
+	conf.LockCount = par.LockCount
+	conf.Shuffle = fft.Shuffle
+	conf.Deterministic = fft.Deterministic
+
+	report, err := trainer.HyperSearch(ctx, conf, space, train_path, test_path, out_dir)
+	if err != nil {
+		return report, err
+	}
 
-		var start int
-		var length int
-		if s.Contains(line, "|f") {
-			start = 3
-			length = len(sp) - 3
-		} else {
-			start = 1
-			length = len(sp)
-		}
+	lan.log4goline.Info("[Lands-searchModel] Best model " + report.BestModel)
+	err = util.CopyFile(model_path, report.BestModel)
+	if err != nil {
+		return report, err
+	}
 
-		label, err := strconv.ParseFloat(sp[0], 64)
-		if err != nil || (int(label) != -1 && int(label) != 0 && int(label) != 1) {
-			str := fmt.Sprintf("[Lands-CheckData] (label must be -1,0/1) file %s,line %d,content %s", filename, count, label)
-			lan.log4goline.Error(str)
-			return 0, errors.New(fmt.Sprintf(JsonError, str)) //标注错误(不是0或1)
-		}
+	return report, fft.ParamServer.Construct(model_path)
+}
 
-		for i := start; i < length; i++ {
-			tup := s.Split(sp[i], ":")
-			if len(tup) != 2 {
-				str := fmt.Sprintf("[Lands-CheckData] (feature value must be key:value) file %s,line %d,content %s", filename, count, sp[i])
-				lan.log4goline.Error(str)
-				return 0, errors.New(fmt.Sprintf(JsonError, str)) //特征格式错误(不是key:value)
-			}
-		}
+func (lan *Lands) checkData(filename string, par *util.ModelParam) (*util.ValidateSummary, error) {
+	conf := util.ValidateConfig{
+		Spliter: lan.conf.SampleSpliter,
+		Named:   len(par.Dict) != 0}
+
+	if par.Validate == "strict" {
+		conf.Budget = par.Budget
+		conf.Quarantine = filename + ".quarantine"
+		conf.Clean = true
 	}
 
-	return count, nil
+	summary, err := util.ValidateFile(filename, conf)
+	if err != nil {
+		str := "[Lands-CheckData] " + err.Error()
+		lan.log4goline.Error(str)
+		return summary, errors.New(str)
+	}
+
+	lan.log4goline.Info(fmt.Sprintf("[Lands-CheckData] file %s, lines %d, bad lines %d, max index %d, duplicate index lines %d\n",
+		filename,
+		summary.Lines,
+		summary.BadLines,
+		summary.MaxIndex,
+		summary.DuplicateIndices))
+
+	return summary, nil
+}
+
+//validate=strict时输出训练、测试数据的校验结果
+func (lan *Lands) writeValidation(
+	w http.ResponseWriter,
+	par *util.ModelParam,
+	summaries []*util.ValidateSummary,
+	err error,
+	result string) {
+
+	if par.Validate != "strict" {
+		return
+	}
+
+	code := 0
+	message := "\"\""
+	if err != nil {
+		code = 1
+		message = strconv.Quote(err.Error())
+	}
+
+	fmt.Fprintf(w, ValidateJson, code, message, lan.validationJson(summaries), result)
+}
+
+func (lan *Lands) validationJson(summaries []*util.ValidateSummary) string {
+	b, err := json.Marshal(summaries)
+	if err != nil {
+		lan.log4goline.Error("[Lands-validationJson] Encode validation summary error." + err.Error())
+		return "[]"
+	}
+
+	return string(b)
 }
 
 /*
@@ -160,6 +304,7 @@ func (lan *Lands) checkData(filename string) (int64, error) {
    src:训练数据为redis还是即时stream (初始化模型如果redis不存在则读取local,模型key为biz值)
    dst:模型存储到redis、local和json
    train:训练数据来源于redis或stream
+   返回新模型和每轮先预测后更新的loss、AUC(progressive)
 */
 func (lan *Lands) onlineServeHttp(w http.ResponseWriter, par *util.ModelParam) error {
 	lan.log4goline.Info("[Lands-onlineServeHttp] Begin online learning...")
@@ -228,7 +373,7 @@ func (lan *Lands) onlineServeHttp(w http.ResponseWriter, par *util.ModelParam) e
 		lan.log4goline.Error("[Lands-onlineServeHttp] Instances number error.")
 		return errors.New("[Lands-onlineServeHttp] Instances number error.")
 	}
-	model, err := lff.TrainOnline(encodemodel, instances)
+	model, progressive, err := lff.TrainOnlineWithMetrics(encodemodel, instances)
 	if err != nil {
 		lan.log4goline.Error("[Lands-onlineServeHttp] Online model training error." + err.Error())
 		return errors.New("[Lands-onlineServeHttp] Online model training error." + err.Error())
@@ -259,8 +404,16 @@ func (lan *Lands) onlineServeHttp(w http.ResponseWriter, par *util.ModelParam) e
 		errors.New("[Lands-onlineServeHttp] Clear local file error." + err.Error())
 	}
 
-	//接口输出
-	fmt.Fprintf(w, model)
+	//接口输出新模型，progressive=on时同时输出每轮先预测后更新的loss和AUC
+	if par.Progressive == "on" {
+		b, err := json.Marshal(progressive)
+		if err != nil {
+			b = []byte("[]")
+		}
+		fmt.Fprintf(w, OnlineJson, string(b), model)
+	} else {
+		fmt.Fprintf(w, "%s", model)
+	}
 
 	//模型存入redis
 	lan.log4goline.Info("[Lands-onlineServeHttp] Write model to redis.")
@@ -302,6 +455,9 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 	base_path_off = lan.conf.DataPathBase + par.Biz + "/off/"
 
 	timestamp := time.Now().Format(TimeFormatString)
+	ctx, done := lan.startJob(par.Biz+" offline "+timestamp, par)
+	defer done()
+
 	err := util.Mkdir(base_path_off + "/" + timestamp)
 	if err != nil {
 		lan.log4goline.Error("[Lands-offlineServeHttp] Offline model make local directory error." + err.Error())
@@ -336,10 +492,12 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 			return errors.New("[Lands-offlineServeHttp] Getmerge train data from hdfs to local error." + err.Error())
 		}
 
-		client.GetMerge(par.Test, test_path, false)
-		if err != nil {
-			lan.log4goline.Error("[Lands-offlineServeHttp] Getmerge test data from hdfs to local error." + err.Error())
-			return errors.New("[Lands-offlineServeHttp] Getmerge test data from hdfs to local error." + err.Error())
+		if len(par.Test) != 0 {
+			client.GetMerge(par.Test, test_path, false)
+			if err != nil {
+				lan.log4goline.Error("[Lands-offlineServeHttp] Getmerge test data from hdfs to local error." + err.Error())
+				return errors.New("[Lands-offlineServeHttp] Getmerge test data from hdfs to local error." + err.Error())
+			}
 		}
 
 	} else if par.Src == "local" {
@@ -352,13 +510,24 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 			lan.log4goline.Error("[Lands-offlineServeHttp] Copy train data from local to local error." + err.Error())
 			return errors.New("[Lands-offlineServeHttp] Copy train data from local to local error." + err.Error())
 		}
-		err = util.CopyFile(test_path, par.Test)
+		if len(par.Test) != 0 {
+			err = util.CopyFile(test_path, par.Test)
+			if err != nil {
+				lan.log4goline.Error("[Lands-offlineServeHttp] Copy test data from local to local error." + err.Error())
+				return errors.New("[Lands-offlineServeHttp] Copy test data from local to local error." + err.Error())
+			}
+		}
+	} else {
+		lan.log4goline.Error("[Lands-offlineServeHttp] Training data source path error.")
+		return errors.New("[Lands-offlineServeHttp] Training data source path error.")
+	}
+
+	//未指定测试数据时从训练数据中划分
+	if len(par.Test) == 0 {
+		err = lan.splitData(train_path, test_path, par)
 		if err != nil {
-			lan.log4goline.Error("[Lands-offlineServeHttp] Copy test data from local to local error." + err.Error())
-			return errors.New("[Lands-offlineServeHttp] Copy test data from local to local error." + err.Error())
-		} else {
-			lan.log4goline.Error("[Lands-offlineServeHttp] Training data source path error.")
-			return errors.New("[Lands-offlineServeHttp] Training data source path error.")
+			lan.log4goline.Error("[Lands-offlineServeHttp] Split train data error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Split train data error." + err.Error())
 		}
 	}
 
@@ -369,20 +538,52 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 
 	//训练数据格式检查及转换
 	lan.log4goline.Info("[Lands-offlineServeHttp] Check training data.")
-	_, err = lan.checkData(train_path)
+	summaries := make([]*util.ValidateSummary, 2)
+	summaries[0], err = lan.checkData(train_path, par)
 	if err != nil {
+		lan.writeValidation(w, par, summaries, err, "[]")
 		lan.log4goline.Error("[Lands-offlineServeHttp] Check train data from local to local error." + err.Error())
 		return errors.New("[Lands-offlineServeHttp] Check train data from local to local error." + err.Error())
 	}
 	lan.log4goline.Info("[Lands-offlineServeHttp] Check testing data.")
-	_, err = lan.checkData(test_path)
+	summaries[1], err = lan.checkData(test_path, par)
 	if err != nil {
+		lan.writeValidation(w, par, summaries, err, "[]")
 		lan.log4goline.Error("[Lands-offlineServeHttp] Check test data from local to local error." + err.Error())
 		return errors.New("[Lands-offlineServeHttp] Check test data from local to local error." + err.Error())
 	}
 
 	//数据抽样
-	if !util.UtilFloat64Equal(par.Sample, 1.0) && !util.UtilFloat64Equal(par.Sample, -1.0) {
+	if par.PosRate > 0 {
+		lan.log4goline.Info(fmt.Sprintf("[Lands-offlineServeHttp] Training data sampling to positive rate %f.", par.PosRate))
+		rates, err := util.TargetRateSample(train_path, train_path, par.PosRate, 0)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+		}
+		lan.log4goline.Info("[Lands-offlineServeHttp] Sample rates " + rates.String())
+	} else if len(par.Stratify) != 0 {
+		lan.log4goline.Info("[Lands-offlineServeHttp] Training data stratified sampling " + par.Stratify)
+		conf, err := util.ParseSampleRates(par.Stratify)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Sample rates error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Sample rates error." + err.Error())
+		}
+
+		rates, err := util.StratifiedSample(train_path, train_path, par.StratifyKey, conf, 0)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+		}
+		lan.log4goline.Info("[Lands-offlineServeHttp] Sample rates " + rates.String())
+	} else if par.SampleLines > 0 {
+		lan.log4goline.Info(fmt.Sprintf("[Lands-offlineServeHttp] Training data reservoir sampling %d lines.", par.SampleLines))
+		_, err = util.ReservoirSample(train_path, train_path, par.SampleLines, 0)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Train data sampling error." + err.Error())
+		}
+	} else if !util.UtilFloat64Equal(par.Sample, 1.0) && !util.UtilFloat64Equal(par.Sample, -1.0) {
 		lan.log4goline.Info("[Lands-offlineServeHttp] Training data sampling.")
 		err = util.FileSampleWithRatio(train_path, par.Sample)
 		if err != nil {
@@ -399,16 +600,116 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 		lan.log4goline.Error("[Lands-offlineServeHttp] Initialize ftrl trainer error")
 		return errors.New("[Lands-offlineServeHttp] Initialize ftrl trainer error.")
 	}
+	fft.SetContext(ctx)
+
+	//特征字典:on为从训练数据构建新字典，否则为已有字典文件路径
+	if par.Dict == "on" {
+		fft.SetFeatureDict(util.NewFeatureDict())
+	} else if len(par.Dict) != 0 {
+		dict, err := util.LoadFeatureDict(par.Dict)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Load feature dictionary error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Load feature dictionary error." + err.Error())
+		}
+		fft.SetFeatureDict(dict)
+	}
+
+	if preprocess, ok := lan.conf.Preprocess[par.Biz]; ok {
+		fft.SetPreprocess(&preprocess)
+	}
+
+	if cross, ok := lan.conf.Cross[par.Biz]; ok {
+		fft.SetFeatureCross(&cross)
+	}
+
+	//按测试集指标提前结束，保存最好一轮的模型
+	if len(par.EarlyStop) != 0 {
+		err = fft.SetEarlyStop(par.EarlyStop, par.Patience, par.MinDelta)
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Early stopping config error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Early stopping config error." + err.Error())
+		}
+	}
 
-	err = fft.Train(par.Alpha, par.Beta, par.L1, par.L2, par.Dropout, model_path,
-		train_path, test_path)
+	//按抽样时记录的各类别比例对样本加权
+	if par.Reweight == "on" && util.FileExists(util.SampleRatesPath(train_path)) {
+		rates, err := util.LoadSampleRates(util.SampleRatesPath(train_path))
+		if err != nil {
+			lan.log4goline.Error("[Lands-offlineServeHttp] Load sample rates error." + err.Error())
+			return errors.New("[Lands-offlineServeHttp] Load sample rates error." + err.Error())
+		}
+		fft.SetSampleRates(rates)
+	}
+
+	//检查点:on时每轮结束及每checkpoint_every个样本保存model_path.ckpt，
+	//训练中断后用resume=检查点路径从中断处继续，参数沿用检查点中的设置
+	if par.Checkpoint == "on" {
+		fft.SetCheckpoint("", int64(par.CheckpointEvery))
+	}
+
+	//dropout随机种子，各线程的随机数由种子、轮次和线程号派生
+	fft.SetSeed(int64(par.Seed))
+
+	//确定性训练:相同数据和参数得到逐位相同的模型
+	if par.Deterministic == "on" {
+		fft.SetDeterministic(int64(par.Seed), par.SyncStep)
+	}
+
+	//有界延迟:各线程最多领先最慢的线程staleness个时钟
+	if par.Ssp == "on" {
+		fft.SetStaleness(par.Staleness, par.ClockStep)
+	}
+
+	//参数服务器的分组大小和加锁方式
+	err = fft.SetParamServer(par.GroupSize, par.Lock, par.LockCount)
+	if err != nil {
+		lan.log4goline.Error("[Lands-offlineServeHttp] " + err.Error())
+		return errors.New("[Lands-offlineServeHttp] " + err.Error())
+	}
+
+	//热启动:on为从当前模型开始训练，否则为模型文件路径。训练参数使用本次请求的参数，
+	//训练数据中的新特征从0开始训练，特征字典不同时按特征名映射已有特征的参数
+	if len(par.WarmStart) != 0 && len(par.Resume) == 0 {
+		warm_model := par.WarmStart
+		if warm_model == "on" {
+			warm_model = base_path_on + "/model.dat"
+		}
+
+		if util.FileExists(warm_model) {
+			err = fft.SetBurnIn("", warm_model, "", 1)
+			if err != nil {
+				lan.log4goline.Error("[Lands-offlineServeHttp] Warm start config error." + err.Error())
+				return errors.New("[Lands-offlineServeHttp] Warm start config error." + err.Error())
+			}
+			lan.log4goline.Info("[Lands-offlineServeHttp] Warm start from " + warm_model)
+		} else {
+			lan.log4goline.Warn("[Lands-offlineServeHttp] Warm start model is not exist, train from scratch." + warm_model)
+		}
+	}
+
+	var report *trainer.SearchReport
+	if len(par.Search) != 0 {
+		report, err = lan.searchModel(ctx, par, &fft, model_path, train_path, test_path, base_path_off+"/"+timestamp+"/search")
+	} else if len(par.Resume) != 0 {
+		err = fft.TrainRestore(par.Resume, model_path, train_path, test_path)
+	} else {
+		err = fft.Train(par.Alpha, par.Beta, par.L1, par.L2, par.Dropout, model_path,
+			train_path, test_path)
+	}
 	if err != nil {
 		lan.log4goline.Error("[Lands-offlineServeHttp] Training model error." + err.Error())
 		return errors.New("[Lands-offlineServeHttp] Training model error." + err.Error())
 	}
 
+	if fft.ParamServer.Dict != nil {
+		err = fft.ParamServer.Dict.Save(model_path + ".dict")
+		if err != nil {
+			lan.log4goline.Warn("[Lands-offlineServeHttp] Save feature dictionary error." + err.Error())
+		}
+	}
+
 	lan.log4goline.Info("[Lands-offlineServeHttp] Predict testing data.")
-	_, err = predictor.Run(3, []string{par.Biz + " offline " + timestamp, test_path,
+	_, err = predictor.RunContext(ctx, 3, []string{par.Biz + " offline " + timestamp, test_path,
 		model_path,
 		predict_path,
 		par.Threshold})
@@ -438,7 +739,19 @@ func (lan *Lands) offlineServeHttp(w http.ResponseWriter, par *util.ModelParam)
 		return errors.New("[Lands-offlineServeHttp] Save model error." + err.Error())
 	}
 
-	fmt.Fprintf(w, "%s", m)
+	//校验结果和搜索排行榜同时返回
+	if report != nil {
+		b, _ := json.Marshal(report.Trials)
+		if par.Validate == "strict" {
+			fmt.Fprintf(w, ValidSearchJson, report.BestModel, lan.validationJson(summaries), string(b), m)
+		} else {
+			fmt.Fprintf(w, SearchJson, report.BestModel, string(b), m)
+		}
+	} else if par.Validate == "strict" {
+		lan.writeValidation(w, par, summaries, nil, m)
+	} else {
+		fmt.Fprintf(w, "%s", m)
+	}
 
 	//模型存入redis
 	lan.log4goline.Info("[Lands-offlineServeHttp] Write model to redis.")
@@ -475,6 +788,8 @@ func (lan *Lands) predictServeHttp(w http.ResponseWriter, par *util.ModelParam)
 
 	timestamp := time.Now().Format(TimeFormatString)
 	base_path_prdtime := base_path_prd + "/" + timestamp
+	ctx, done := lan.startJob(par.Biz+" predict "+timestamp, par)
+	defer done()
 
 	err := util.Mkdir(base_path_prdtime)
 	if err != nil {
@@ -559,7 +874,12 @@ func (lan *Lands) predictServeHttp(w http.ResponseWriter, par *util.ModelParam)
 			return errors.New("[Lands-predictServeHttp] Streaming instances number error.")
 		}
 
-		json, err := predictor.StreamRun(base_path_ws+"model.dat", instances)
+		var json string
+		if par.Debug == "on" {
+			json, err = predictor.StreamExplainRun(base_path_ws+"model.dat", instances, ExplainTopN)
+		} else {
+			json, err = predictor.StreamRun(base_path_ws+"model.dat", instances)
+		}
 		if err != nil {
 			lan.log4goline.Error("[Lands-predictServeHttp] Streaming predicting running error." + err.Error())
 			return errors.New("[Lands-predictServeHttp] Streaming predicting running error." + err.Error())
@@ -570,7 +890,7 @@ func (lan *Lands) predictServeHttp(w http.ResponseWriter, par *util.ModelParam)
 		return nil
 	}
 
-	json, err := predictor.Run(3, []string{par.Biz + " predict " + timestamp, predict_path,
+	json, err := predictor.RunContext(ctx, 3, []string{par.Biz + " predict " + timestamp, predict_path,
 		base_path_ws + "model.dat",
 		base_path_prdtime + "/predict_result.dat",
 		par.Threshold})
@@ -585,6 +905,91 @@ func (lan *Lands) predictServeHttp(w http.ResponseWriter, par *util.ModelParam)
 	return nil
 }
 
+/*
+ * 数据统计请求串格式
+ * http://127.0.0.1:8080/profile?biz=[model name]&src=[hdfs/local]&train=[train file name]&test=[test file name]
+				&threads=[threads number]&topn=[top n features]
+   src:训练、测试数据源为hdfs/local
+   test:可选，给出时统计只在训练集中出现的特征
+*/
+func (lan *Lands) profileServeHttp(w http.ResponseWriter, par *util.ModelParam) error {
+	lan.log4goline.Info("[Lands-profileServeHttp] Begin profiling...")
+	train_path := par.Train
+	test_path := par.Test
+
+	if par.Src == "hdfs" {
+		base_path_prf := lan.conf.DataPathBase + par.Biz + "/prf/"
+		timestamp := time.Now().Format(TimeFormatString)
+		err := util.Mkdir(base_path_prf + "/" + timestamp)
+		if err != nil {
+			lan.log4goline.Error("[Lands-profileServeHttp] Make local directory error." + err.Error())
+			return errors.New("[Lands-profileServeHttp] Make local directory error." + err.Error())
+		}
+
+		client, err := lan.createHdfsClient()
+		if err != nil {
+			lan.log4goline.Error("[Lands-profileServeHttp] Create hdfs client error." + err.Error())
+			return errors.New("[Lands-profileServeHttp] Create hdfs client error." + err.Error())
+		}
+
+		train_path = base_path_prf + "/" + timestamp + "/train.dat"
+		client.GetMerge(par.Train, train_path, false)
+		if !util.FileExists(train_path) {
+			lan.log4goline.Error("[Lands-profileServeHttp] Getmerge train data from hdfs to local error.")
+			return errors.New("[Lands-profileServeHttp] Getmerge train data from hdfs to local error.")
+		}
+
+		if len(par.Test) != 0 {
+			test_path = base_path_prf + "/" + timestamp + "/test.dat"
+			client.GetMerge(par.Test, test_path, false)
+			if !util.FileExists(test_path) {
+				lan.log4goline.Error("[Lands-profileServeHttp] Getmerge test data from hdfs to local error.")
+				return errors.New("[Lands-profileServeHttp] Getmerge test data from hdfs to local error.")
+			}
+		}
+
+		err = util.KeepLatestN(base_path_prf, 5)
+		if err != nil {
+			lan.log4goline.Warn("[Lands-profileServeHttp] Clear local file error." + err.Error())
+		}
+	}
+
+	profile, err := trainer.ProfileFile(train_path, test_path, par.Threads, par.TopN, nil)
+	if err != nil {
+		fmt.Fprintf(w, JsonError, err.Error())
+		lan.log4goline.Error("[Lands-profileServeHttp] Profile data error." + err.Error())
+		return errors.New("[Lands-profileServeHttp] Profile data error." + err.Error())
+	}
+
+	b, err := json.Marshal(profile)
+	if err != nil {
+		lan.log4goline.Error("[Lands-profileServeHttp] Encode profile error." + err.Error())
+		return errors.New("[Lands-profileServeHttp] Encode profile error." + err.Error())
+	}
+
+	fmt.Fprintf(w, "%s", b)
+	lan.log4goline.Info("[Lands-profileServeHttp] End profiling.")
+	return nil
+}
+
+/*
+ * 取消任务请求串格式
+ * http://127.0.0.1:8080/cancel?biz=[model name]
+   biz:取消该模型运行中的offline、predict任务，训练在当前样本处停止，不写出模型文件
+*/
+func (lan *Lands) cancelServeHttp(w http.ResponseWriter, par *util.ModelParam) error {
+	if len(par.Biz) == 0 {
+		fmt.Fprintf(w, JsonError, "[Lands-cancelServeHttp] Model name is empty.")
+		return errors.New("[Lands-cancelServeHttp] Model name is empty.")
+	}
+
+	names := lan.cancelJobs(par.Biz)
+	b, _ := json.Marshal(names)
+	lan.log4goline.Info(fmt.Sprintf("[Lands-cancelServeHttp] Cancel jobs %v.", names))
+	fmt.Fprintf(w, CancelJson, len(names), string(b))
+	return nil
+}
+
 func (lan *Lands) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	par := util.ParamParse(r)
 	lan.log4goline.Info("[ServeHTTP] Parameters:" + par.String())
@@ -0,0 +1,24 @@
+package solver
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+//float64按位原子读取
+func atomic_load_float64(addr *float64) float64 {
+	return math.Float64frombits(atomic.LoadUint64((*uint64)(unsafe.Pointer(addr))))
+}
+
+//CAS循环原子累加float64，返回累加后的值
+func atomic_add_float64(addr *float64, delta float64) float64 {
+	p := (*uint64)(unsafe.Pointer(addr))
+	for {
+		old := atomic.LoadUint64(p)
+		val := math.Float64frombits(old) + delta
+		if atomic.CompareAndSwapUint64(p, old, math.Float64bits(val)) {
+			return val
+		}
+	}
+}
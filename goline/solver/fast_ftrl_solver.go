@@ -10,26 +10,35 @@ import (
 )
 
 const (
-	ParamGroupSize = 10
-	FetchStep      = 3
-	PushStep       = 3
+	DefaultParamGroupSize = 10
+	DefaultLockCount      = 1024
+
+	LockGroup   = "group"   //每个参数组一把锁
+	LockStriped = "striped" //固定数量的锁，参数组按hash分配到锁上
+	LockAtomic  = "atomic"  //不加锁，N、Z逐个用CAS累加
 )
 
-func calc_group_num(n int) int {
-	return (n + ParamGroupSize - 1) / ParamGroupSize
+func calc_group_num(n int, group_size int) int {
+	return (n + group_size - 1) / group_size
 }
 
+//参数服务器按ParamGroupSize个特征分组交换参数。LockMode为group时每组一把锁，特征很多时锁的数量也很多；
+//striped时只分配LockCount把锁，参数组按hash共用；atomic时不加锁，逐个参数原子读取和累加
 type FtrlParamServer struct {
 	FtrlSolver
 
-	ParamGroupNum int
-	LockSlots     []sync.Mutex
-	log           log4go.Logger
+	ParamGroupSize int
+	ParamGroupNum  int
+	LockMode       string
+	LockCount      int
+	LockSlots      []sync.Mutex
+	log            log4go.Logger
 }
 
 type FtrlWorker struct {
 	FtrlSolver
 
+	ParamGroupSize int
 	ParamGroupNum  int
 	ParamGroupStep []int
 	PushStep       int
@@ -37,7 +46,24 @@ type FtrlWorker struct {
 
 	NUpdate []float64
 	ZUpdate []float64
-	log     log4go.Logger
+
+	//同步模式下更新不与参数服务器交互，只记录改动过的参数，由SyncWorkers统一合并和分发
+	Sync    bool
+	touched []bool
+	dirty   []int
+
+	//有界延迟模式下每训练ClockStep个样本时钟加1，时钟加1前推送有更新的参数组，
+	//本地参数组读取后经过Clock.Staleness个时钟即重新读取
+	Clock       *SSPClock
+	Id          int
+	ClockStep   int
+	clock       int
+	samples     int
+	group_clock []int
+	group_dirty []bool
+	dirty_group []int
+
+	log log4go.Logger
 }
 
 func (fps *FtrlParamServer) Initialize(
@@ -54,44 +80,119 @@ func (fps *FtrlParamServer) Initialize(
 		return errors.New("[FtrlParamServer-Initialize] Fast ftrl solver initialize error.")
 	}
 
-	fps.ParamGroupNum = calc_group_num(n)
-	fps.LockSlots = make([]sync.Mutex, fps.ParamGroupNum)
+	fps.init_groups()
 
 	fps.Init = true
 	return nil
 }
 
+//设置参数分组和加锁方式，须在Initialize或Construct之前调用，参数为0或空时使用默认值
+func (fps *FtrlParamServer) Configure(group_size int, lock_mode string, lock_count int) error {
+	switch lock_mode {
+	case "", LockGroup, LockStriped, LockAtomic:
+	default:
+		return errors.New("[FtrlParamServer-Configure] Unknown lock mode " + lock_mode + ".")
+	}
+
+	if group_size < 0 || lock_count < 0 {
+		return errors.New(fmt.Sprintf("[FtrlParamServer-Configure] Invalid group size %d or lock count %d.", group_size, lock_count))
+	}
+
+	fps.ParamGroupSize = group_size
+	fps.LockMode = lock_mode
+	fps.LockCount = lock_count
+	return nil
+}
+
+func (fps *FtrlParamServer) init_groups() {
+	if fps.ParamGroupSize <= 0 {
+		fps.ParamGroupSize = DefaultParamGroupSize
+	}
+
+	if len(fps.LockMode) == 0 {
+		fps.LockMode = LockGroup
+	}
+
+	if fps.LockCount <= 0 {
+		fps.LockCount = DefaultLockCount
+	}
+
+	fps.ParamGroupNum = calc_group_num(fps.FtrlSolver.Featnum, fps.ParamGroupSize)
+	switch fps.LockMode {
+	case LockGroup:
+		fps.LockSlots = make([]sync.Mutex, fps.ParamGroupNum)
+	case LockStriped:
+		fps.LockSlots = make([]sync.Mutex, util.MinInt(fps.LockCount, fps.ParamGroupNum))
+	default:
+		fps.LockSlots = nil
+	}
+}
+
+//参数组对应的锁，atomic模式下为nil
+func (fps *FtrlParamServer) slot(group int) *sync.Mutex {
+	switch fps.LockMode {
+	case LockGroup:
+		return &fps.LockSlots[group]
+	case LockStriped:
+		//相邻的参数组分散到不同的锁上
+		h := uint64(group) * 0x9E3779B97F4A7C15
+		return &fps.LockSlots[(h>>32)%uint64(len(fps.LockSlots))]
+	}
+
+	return nil
+}
+
+func (fps *FtrlParamServer) group_range(group int) (int, int) {
+	return group * fps.ParamGroupSize, util.MinInt((group+1)*fps.ParamGroupSize, fps.FtrlSolver.Featnum)
+}
+
 func (fps *FtrlParamServer) Construct(path string) error {
 	err := fps.FtrlSolver.Construct(path)
 	if err != nil {
-		fps.log.Error(fmt.Sprintf("[FtrlParamServer-Construct] Restore fast ftrl solver error.", err.Error()))
-		return errors.New(fmt.Sprintf("[FtrlParamServer-Construct] Restore fast ftrl solver error.", err.Error()))
+		fps.log.Error(fmt.Sprintf("[FtrlParamServer-Construct] Restore fast ftrl solver error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FtrlParamServer-Construct] Restore fast ftrl solver error.%s", err.Error()))
 	}
 
-	fps.ParamGroupNum = calc_group_num(fps.FtrlSolver.Featnum)
-	fps.LockSlots = make([]sync.Mutex, fps.ParamGroupNum)
+	fps.init_groups()
 
 	fps.Init = true
 	return nil
 }
 
+//特征数变化(热启动时扩展或重新映射特征空间)后重新分组
+func (fps *FtrlParamServer) Regroup() {
+	fps.init_groups()
+}
+
 func (fps *FtrlParamServer) FetchParamGroup(n []float64, z []float64, group int) error {
 	if !fps.FtrlSolver.Init {
 		fps.log.Error("[FtrlParamServer-FetchParamGroup] Initialize fast ftrl solver error.")
 		return errors.New("[FtrlParamServer-FetchParamGroup] Initialize fast ftrl solver error.")
 	}
 
-	var start int = group * ParamGroupSize
-	var end int = util.MinInt((group+1)*ParamGroupSize, fps.FtrlSolver.Featnum)
+	start, _ := fps.group_range(group)
+	fps.fetch_group(n, z, group, start)
+	return nil
+}
 
-	fps.LockSlots[group].Lock()
-	for i := start; i < end; i++ {
-		n[i] = fps.FtrlSolver.N[i]
-		z[i] = fps.FtrlSolver.Z[i]
+//读取参数组到n、z中从offset开始的一段
+func (fps *FtrlParamServer) fetch_group(n []float64, z []float64, group int, offset int) {
+	start, end := fps.group_range(group)
+	lock := fps.slot(group)
+	if lock == nil {
+		for i := start; i < end; i++ {
+			n[offset+i-start] = atomic_load_float64(&fps.FtrlSolver.N[i])
+			z[offset+i-start] = atomic_load_float64(&fps.FtrlSolver.Z[i])
+		}
+		return
 	}
-	fps.LockSlots[group].Unlock()
 
-	return nil
+	lock.Lock()
+	for i := start; i < end; i++ {
+		n[offset+i-start] = fps.FtrlSolver.N[i]
+		z[offset+i-start] = fps.FtrlSolver.Z[i]
+	}
+	lock.Unlock()
 }
 
 func (fps *FtrlParamServer) FetchParam(n []float64, z []float64) error {
@@ -103,8 +204,8 @@ func (fps *FtrlParamServer) FetchParam(n []float64, z []float64) error {
 	for i := 0; i < fps.ParamGroupNum; i++ {
 		err := fps.FetchParamGroup(n, z, i)
 		if err != nil {
-			fps.log.Error(fmt.Sprintf("[FtrlParamServer-FetchParam] Initialize fast ftrl solver error.", err.Error()))
-			return errors.New(fmt.Sprintf("[FtrlParamServer-FetchParam] Initialize fast ftrl solver error.", err.Error()))
+			fps.log.Error(fmt.Sprintf("[FtrlParamServer-FetchParam] Initialize fast ftrl solver error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FtrlParamServer-FetchParam] Initialize fast ftrl solver error.%s", err.Error()))
 		}
 	}
 	return nil
@@ -116,31 +217,71 @@ func (fps *FtrlParamServer) PushParamGroup(n []float64, z []float64, group int)
 		return errors.New("[FtrlParamServer-PushParamGroup] Initialize fast ftrl solver error.")
 	}
 
-	var start int = group * ParamGroupSize
-	var end int = util.MinInt((group+1)*ParamGroupSize, fps.FtrlSolver.Featnum)
+	start, _ := fps.group_range(group)
+	fps.push_group(n, z, group, start)
+	return nil
+}
+
+//把n、z中从offset开始的一段累加到参数组并清零
+func (fps *FtrlParamServer) push_group(n []float64, z []float64, group int, offset int) {
+	start, end := fps.group_range(group)
+	lock := fps.slot(group)
+	if lock == nil {
+		for i := start; i < end; i++ {
+			k := offset + i - start
+			if n[k] != 0 || z[k] != 0 {
+				atomic_add_float64(&fps.FtrlSolver.N[i], n[k])
+				atomic_add_float64(&fps.FtrlSolver.Z[i], z[k])
+				n[k] = 0
+				z[k] = 0
+			}
+		}
+		return
+	}
 
-	fps.LockSlots[group].Lock()
+	lock.Lock()
 	for i := start; i < end; i++ {
-		fps.FtrlSolver.N[i] += n[i]
-		fps.FtrlSolver.Z[i] += z[i]
-		n[i] = 0
-		z[i] = 0
+		k := offset + i - start
+		fps.FtrlSolver.N[i] += n[k]
+		fps.FtrlSolver.Z[i] += z[k]
+		n[k] = 0
+		z[k] = 0
 	}
-	fps.LockSlots[group].Unlock()
-	return nil
+	lock.Unlock()
+}
+
+//FtrlWorker通过该接口与参数服务器交换参数，进程内的FtrlParamServer和远程的RemoteParamServer都实现该接口
+type ParamServer interface {
+	FetchParamGroup(n []float64, z []float64, group int) error
+	PushParamGroup(n []float64, z []float64, group int) error
+	FetchParam(n []float64, z []float64) error
+	//超参数、特征数和特征处理
+	Solver() *FtrlSolver
+	GroupSize() int
+}
+
+func (fps *FtrlParamServer) Solver() *FtrlSolver {
+	return &fps.FtrlSolver
+}
+
+func (fps *FtrlParamServer) GroupSize() int {
+	return fps.ParamGroupSize
 }
 
 func (fw *FtrlWorker) Initialize(
-	param_server *FtrlParamServer,
+	param_server ParamServer,
 	push_step int,
 	fetch_step int) bool {
 
-	fw.FtrlSolver.Alpha = param_server.Alpha
-	fw.FtrlSolver.Beta = param_server.Beta
-	fw.FtrlSolver.L1 = param_server.L1
-	fw.FtrlSolver.L2 = param_server.L2
-	fw.FtrlSolver.Featnum = param_server.Featnum
-	fw.FtrlSolver.Dropout = param_server.Dropout
+	ps := param_server.Solver()
+	fw.FtrlSolver.Alpha = ps.Alpha
+	fw.FtrlSolver.Beta = ps.Beta
+	fw.FtrlSolver.L1 = ps.L1
+	fw.FtrlSolver.L2 = ps.L2
+	fw.FtrlSolver.Featnum = ps.Featnum
+	fw.FtrlSolver.Dropout = ps.Dropout
+	fw.FtrlSolver.Preprocess = ps.Preprocess
+	fw.FtrlSolver.Cross = ps.Cross
 
 	fw.NUpdate = make([]float64, fw.FtrlSolver.Featnum)
 	fw.ZUpdate = make([]float64, fw.FtrlSolver.Featnum)
@@ -153,7 +294,8 @@ func (fw *FtrlWorker) Initialize(
 		return false
 	}
 
-	fw.ParamGroupNum = calc_group_num(fw.FtrlSolver.Featnum)
+	fw.ParamGroupSize = param_server.GroupSize()
+	fw.ParamGroupNum = calc_group_num(fw.FtrlSolver.Featnum, fw.ParamGroupSize)
 	fw.ParamGroupStep = make([]int, fw.ParamGroupNum)
 	for i := 0; i < fw.ParamGroupNum; i++ {
 		fw.ParamGroupStep[i] = 0
@@ -168,41 +310,93 @@ func (fw *FtrlWorker) Initialize(
 	return fw.FtrlSolver.Init
 }
 
-func (fw *FtrlWorker) Reset(param_server *FtrlParamServer) error {
+//开启有界延迟，id为worker在clock中的编号
+func (fw *FtrlWorker) SetClock(clock *SSPClock, id int, clock_step int) {
+	fw.Clock = clock
+	fw.Id = id
+	fw.ClockStep = clock_step
+	fw.clock = 0
+	fw.samples = 0
+	fw.group_clock = make([]int, fw.ParamGroupNum)
+	fw.group_dirty = make([]bool, fw.ParamGroupNum)
+	fw.dirty_group = fw.dirty_group[:0]
+}
+
+//推送本时钟内有更新的参数组后时钟加1，超出延迟上限时阻塞
+func (fw *FtrlWorker) tick(param_server ParamServer) {
+	for _, g := range fw.dirty_group {
+		param_server.PushParamGroup(fw.NUpdate, fw.ZUpdate, g)
+		fw.group_dirty[g] = false
+	}
+	fw.dirty_group = fw.dirty_group[:0]
+
+	fw.clock = fw.Clock.Tick(fw.Id)
+}
+
+func (fw *FtrlWorker) Reset(param_server ParamServer) error {
 	if !fw.FtrlSolver.Init {
 		fw.log.Error("[FtrlWorker-Reset] Initialize fast ftrl solver error.")
 		return errors.New("[FtrlWorker-Reset] Initialize fast ftrl solver error.")
 	}
 
+	//有界延迟的时钟只在进程内的线程之间同步，不能用于多进程的远程参数服务器
+	if _, remote := param_server.(*RemoteParamServer); remote && fw.Clock != nil {
+		fw.log.Error("[FtrlWorker-Reset] Bounded staleness is not supported with remote parameter server.")
+		return errors.New("[FtrlWorker-Reset] Bounded staleness is not supported with remote parameter server.")
+	}
+
 	err := param_server.FetchParam(fw.FtrlSolver.N, fw.FtrlSolver.Z)
 	if err != nil {
-		fw.log.Error(fmt.Sprintf("[FtrlWorker-Reset] Initialize fast ftrl solver error.", err.Error()))
-		return errors.New(fmt.Sprintf("[FtrlWorker-Reset] Initialize fast ftrl solver error.", err.Error()))
+		fw.log.Error(fmt.Sprintf("[FtrlWorker-Reset] Initialize fast ftrl solver error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FtrlWorker-Reset] Initialize fast ftrl solver error.%s", err.Error()))
 	}
 
 	for i := 0; i < fw.ParamGroupNum; i++ {
 		fw.ParamGroupStep[i] = 0
 	}
+
+	if fw.Clock != nil {
+		fw.SetClock(fw.Clock, fw.Id, fw.ClockStep)
+	}
 	return nil
 }
 
 func (fw *FtrlWorker) Update(
 	x util.Pvector,
 	y float64,
-	param_server *FtrlParamServer) float64 {
+	param_server ParamServer) float64 {
+	return fw.UpdateWithWeight(x, y, 1., param_server)
+}
+
+//按样本权重更新，梯度乘以weight
+func (fw *FtrlWorker) UpdateWithWeight(
+	x util.Pvector,
+	y float64,
+	weight float64,
+	param_server ParamServer) float64 {
 
 	if !fw.FtrlSolver.Init {
 		return 0.
 	}
 
-	var weights util.Pvector = make(util.Pvector, fw.FtrlSolver.Featnum)
-	var gradients []float64 = make([]float64, fw.FtrlSolver.Featnum)
+	ssp := fw.Clock != nil && !fw.Sync
+	if ssp {
+		fw.samples++
+		if fw.samples%fw.ClockStep == 0 {
+			fw.tick(param_server)
+		}
+	}
+
+	x = fw.FtrlSolver.Transform(x)
+
+	var weights util.Pvector = make(util.Pvector, 0, len(x))
+	var gradients []float64 = make([]float64, 0, len(x))
 	var wTx float64 = 0.
 
 	for i := 0; i < len(x); i++ {
 		item := x[i]
 		if util.UtilGreater(fw.FtrlSolver.Dropout, 0.0) {
-			rand_prob := util.UniformDistribution()
+			rand_prob := fw.FtrlSolver.uniform()
 			if rand_prob < fw.FtrlSolver.Dropout {
 				continue
 			}
@@ -225,19 +419,22 @@ func (fw *FtrlWorker) Update(
 	//计算模型预估值
 	var pred float64 = util.Sigmoid(wTx)
 	//计算p_t-y_t值，为计算每个样本的梯度做准备
-	var grad float64 = pred - y
+	var grad float64 = (pred - y) * weight
 	//计算g_i = (p_t-y_t)*x_i
 	util.VectorMultiplies(gradients, grad)
 
 	for k := 0; k < len(weights); k++ {
 		var i int = weights[k].Index
-		var g int = i / ParamGroupSize
+		var g int = i / fw.ParamGroupSize
 
-		if fw.ParamGroupStep[g]%fw.FetchStep == 0 {
+		if !fw.Sync && (fw.ParamGroupStep[g]%fw.FetchStep == 0 || (ssp && fw.clock-fw.group_clock[g] > fw.Clock.Staleness)) {
 			param_server.FetchParamGroup(
 				fw.FtrlSolver.N,
 				fw.FtrlSolver.Z,
 				g)
+			if ssp {
+				fw.group_clock[g] = fw.clock
+			}
 		}
 
 		var w_i float64 = weights[k].Value
@@ -249,8 +446,16 @@ func (fw *FtrlWorker) Update(
 		fw.ZUpdate[i] += grad_i - sigma*w_i
 		fw.NUpdate[i] += grad_i * grad_i
 
+		if fw.Sync {
+			fw.touch(i)
+			continue
+		}
+
 		if fw.ParamGroupStep[g]%fw.PushStep == 0 {
 			param_server.PushParamGroup(fw.NUpdate, fw.ZUpdate, g)
+		} else if ssp && !fw.group_dirty[g] {
+			fw.group_dirty[g] = true
+			fw.dirty_group = append(fw.dirty_group, g)
 		}
 
 		fw.ParamGroupStep[g] += 1
@@ -259,7 +464,7 @@ func (fw *FtrlWorker) Update(
 	return pred
 }
 
-func (fw *FtrlWorker) PushParam(param_server *FtrlParamServer) error {
+func (fw *FtrlWorker) PushParam(param_server ParamServer) error {
 	if !fw.FtrlSolver.Init {
 		fw.log.Error("[FtrlWorker-PushParam] Initialize fast ftrl solver error.")
 		return errors.New("[FtrlWorker-PushParam] Initialize fast ftrl solver error.")
@@ -268,10 +473,52 @@ func (fw *FtrlWorker) PushParam(param_server *FtrlParamServer) error {
 	for i := 0; i < fw.ParamGroupNum; i++ {
 		err := param_server.PushParamGroup(fw.NUpdate, fw.ZUpdate, i)
 		if err != nil {
-			fw.log.Error(fmt.Sprintf("[FtrlWorker-PushParam] Initialize fast ftrl solver error.", err.Error()))
-			return errors.New(fmt.Sprintf("[FtrlWorker-PushParam] Initialize fast ftrl solver error.", err.Error()))
+			fw.log.Error(fmt.Sprintf("[FtrlWorker-PushParam] Initialize fast ftrl solver error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FtrlWorker-PushParam] Initialize fast ftrl solver error.%s", err.Error()))
 		}
 	}
 
 	return nil
 }
+
+func (fw *FtrlWorker) touch(i int) {
+	if fw.touched == nil {
+		fw.touched = make([]bool, fw.FtrlSolver.Featnum)
+	}
+
+	if !fw.touched[i] {
+		fw.touched[i] = true
+		fw.dirty = append(fw.dirty, i)
+	}
+}
+
+//同步各worker的更新，调用时各worker须暂停。按worker顺序把更新累加到参数服务器，
+//再把所有改动过的参数分发给每个worker，累加顺序固定，结果与线程调度无关
+func (fps *FtrlParamServer) SyncWorkers(workers []FtrlWorker) {
+	for w := 0; w < len(workers); w++ {
+		fw := &workers[w]
+		for _, i := range fw.dirty {
+			fps.FtrlSolver.N[i] += fw.NUpdate[i]
+			fps.FtrlSolver.Z[i] += fw.ZUpdate[i]
+			fw.NUpdate[i] = 0
+			fw.ZUpdate[i] = 0
+		}
+	}
+
+	for w := 0; w < len(workers); w++ {
+		for v := 0; v < len(workers); v++ {
+			for _, i := range workers[v].dirty {
+				workers[w].FtrlSolver.N[i] = fps.FtrlSolver.N[i]
+				workers[w].FtrlSolver.Z[i] = fps.FtrlSolver.Z[i]
+			}
+		}
+	}
+
+	for w := 0; w < len(workers); w++ {
+		fw := &workers[w]
+		for _, i := range fw.dirty {
+			fw.touched[i] = false
+		}
+		fw.dirty = fw.dirty[:0]
+	}
+}
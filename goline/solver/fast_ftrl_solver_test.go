@@ -0,0 +1,66 @@
+package solver
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParamServerLockModes(t *testing.T) {
+	for _, mode := range []string{LockGroup, LockStriped, LockAtomic} {
+		var fps FtrlParamServer
+		if err := fps.Configure(7, mode, 16); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fps.Initialize(0.1, 1, 0, 0, 1000, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		slots := map[string]int{LockGroup: 143, LockStriped: 16, LockAtomic: 0}[mode]
+		if fps.ParamGroupNum != 143 || len(fps.LockSlots) != slots {
+			t.Fatalf("%s: groups=%d locks=%d", mode, fps.ParamGroupNum, len(fps.LockSlots))
+		}
+
+		//各线程并发累加，每个参数的结果都等于全部增量之和
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n := make([]float64, 1000)
+				z := make([]float64, 1000)
+				for round := 0; round < 50; round++ {
+					for g := 0; g < fps.ParamGroupNum; g++ {
+						start, end := fps.group_range(g)
+						for k := start; k < end; k++ {
+							n[k], z[k] = 1, -0.5
+						}
+						fps.PushParamGroup(n, z, g)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		n := make([]float64, 1000)
+		z := make([]float64, 1000)
+		if err := fps.FetchParam(n, z); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			if n[i] != 400 || z[i] != -200 {
+				t.Fatalf("%s: param %d n=%g z=%g", mode, i, n[i], z[i])
+			}
+		}
+	}
+
+	var fps FtrlParamServer
+	if err := fps.Configure(10, "spin", 0); err == nil {
+		t.Fatal("unknown lock mode accepted")
+	}
+
+	if err := fps.Configure(-1, LockGroup, 0); err == nil {
+		t.Fatal("negative group size accepted")
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"goline/util"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 )
 
@@ -32,7 +34,33 @@ type FtrlSolver struct {
 
 	Weights util.Pvector `json:"Weights"`
 
+	Preprocess *util.FeaturePreprocess `json:"Preprocess,omitempty"`
+	Cross      *util.FeatureCross      `json:"Cross,omitempty"`
+	Dict       *util.FeatureDict       `json:"Dict,omitempty"`
+
+	//训练元数据，如shuffle随机种子
+	Meta map[string]string `json:"Meta,omitempty"`
+
 	Init bool `json:"Init"`
+
+	rand *rand.Rand //dropout的随机数生成器，nil时使用全局随机数
+}
+
+//设置dropout的随机数生成器，固定种子时dropout可复现。rd不是并发安全的，各线程的solver需各自设置
+func (fs *FtrlSolver) SetRand(rd *rand.Rand) {
+	fs.rand = rd
+}
+
+func (fs *FtrlSolver) uniform() float64 {
+	return uniform(fs.rand)
+}
+
+func uniform(rd *rand.Rand) float64 {
+	if rd != nil {
+		return rd.Float64()
+	}
+
+	return util.UniformDistribution()
 }
 
 func (fs *FtrlSolver) SetFloatZero(x []float64, n int) {
@@ -87,12 +115,38 @@ func (fs *FtrlSolver) Construct(path string) error {
 	fs.Dropout = fls.Dropout
 	fs.Featnum = fls.Featnum
 	fs.L1 = fls.L1
+	fs.L2 = fls.L2
 	fs.N = fls.N
 	fs.Z = fls.Z
+	fs.Preprocess = fls.Preprocess
+	fs.Cross = fls.Cross
+	fs.Dict = fls.Dict
+	fs.Meta = fls.Meta
 	fs.Init = fls.Init
 	return nil
 }
 
+//用src中相同下标特征的N、Z覆盖本模型的参数，返回复制的特征数
+func (fs *FtrlSolver) CopyParam(src *FtrlSolver) int {
+	n := util.MinInt(fs.Featnum, src.Featnum, len(src.N), len(src.Z))
+	copy(fs.N[:n], src.N[:n])
+	copy(fs.Z[:n], src.Z[:n])
+	return n
+}
+
+func (fs *FtrlSolver) SetMeta(key string, val string) {
+	if fs.Meta == nil {
+		fs.Meta = make(map[string]string)
+	}
+
+	fs.Meta[key] = val
+}
+
+//样本预处理及特征交叉
+func (fs *FtrlSolver) Transform(x util.Pvector) util.Pvector {
+	return fs.Cross.Transform(fs.Preprocess.Transform(x))
+}
+
 //计算每个维度特征值权重
 func (fs *FtrlSolver) GetWeight(idx int) float64 {
 	var sign float64 = 1.
@@ -116,19 +170,31 @@ func (fs *FtrlSolver) GetWeight(idx int) float64 {
 
 //更新权重方法
 func (fs *FtrlSolver) Update(x util.Pvector, y float64) float64 {
+	return fs.UpdateWithWeight(x, y, 1.)
+}
+
+//按样本权重更新，梯度乘以weight，用于抽样后的样本加权
+func (fs *FtrlSolver) UpdateWithWeight(x util.Pvector, y float64, weight float64) float64 {
+	return fs.UpdateWithRand(x, y, weight, fs.rand)
+}
+
+//多线程共享同一个solver时各线程用各自的随机数rd做dropout，rd为nil时使用全局随机数
+func (fs *FtrlSolver) UpdateWithRand(x util.Pvector, y float64, weight float64, rd *rand.Rand) float64 {
 	if !fs.Init {
 		return 0
 	}
 
-	var weights util.Pvector = make(util.Pvector, fs.Featnum)
-	var gradients []float64 = make([]float64, fs.Featnum)
+	x = fs.Transform(x)
+
+	var weights util.Pvector = make(util.Pvector, 0, len(x))
+	var gradients []float64 = make([]float64, 0, len(x))
 
 	var wTx float64 = 0.
 
 	for i := 0; i < len(x); i++ {
 		item := x[i]
 		if util.UtilGreater(fs.Dropout, 0.0) {
-			rand_prob := util.UniformDistribution()
+			rand_prob := uniform(rd)
 			if rand_prob < fs.Dropout {
 				continue
 			}
@@ -152,7 +218,7 @@ func (fs *FtrlSolver) Update(x util.Pvector, y float64) float64 {
 	//计算模型预估值
 	var pred float64 = util.Sigmoid(wTx)
 	//计算p_t-y_t值，为计算每个样本的梯度做准备
-	var grad float64 = pred - y
+	var grad float64 = (pred - y) * weight
 	//计算g_i = (p_t-y_t)*x_i
 	util.VectorMultiplies(gradients, grad)
 
@@ -175,6 +241,8 @@ func (fs *FtrlSolver) Predict(x util.Pvector) float64 {
 		return 0
 	}
 
+	x = fs.Transform(x)
+
 	var wTx float64 = 0.
 	for i := 0; i < len(x); i++ {
 		idx := x[i].Index
@@ -195,7 +263,7 @@ func (fs *FtrlSolver) ToString(util.Pvector) string {
 	for i := 0; i < fs.Featnum; i++ {
 		val := fs.GetWeight(i)
 		if val != 0 {
-			str = str + "(" + strconv.Itoa(i) + "," + FloatToString(val) + ") "
+			str = str + "(" + fs.Dict.Name(i) + "," + FloatToString(val) + ") "
 			fs.Weights[i] = util.Pair{i, fs.GetWeight(i)}
 		}
 	}
@@ -260,9 +328,12 @@ func (fs *FtrlSolver) SaveEncodeModel() (string, error) {
 }
 
 type LRModel struct {
-	Model map[int]float64
-	Init  bool
-	log   log4go.Logger
+	Model      map[int]float64
+	Preprocess *util.FeaturePreprocess
+	Cross      *util.FeatureCross
+	Dict       *util.FeatureDict
+	Init       bool
+	log        log4go.Logger
 }
 
 func (lr *LRModel) Initialize(path string) error {
@@ -292,17 +363,26 @@ func (lr *LRModel) Initialize(path string) error {
 	for i := 0; i < len(fls.Weights); i++ {
 		lr.Model[fls.Weights[i].Index] = fls.Weights[i].Value
 	}
+	lr.Preprocess = fls.Preprocess
+	lr.Cross = fls.Cross
+	lr.Dict = fls.Dict
 
 	lr.Init = true
 
 	return nil
 }
 
+func (lr *LRModel) Transform(x util.Pvector) util.Pvector {
+	return lr.Cross.Transform(lr.Preprocess.Transform(x))
+}
+
 func (lr *LRModel) Predict(x util.Pvector) float64 {
 	if !lr.Init {
 		return 0
 	}
 
+	x = lr.Transform(x)
+
 	var wTx float64 = 0.
 	for i := 0; i < len(x); i++ {
 		item := x[i]
@@ -324,8 +404,62 @@ func (lr *LRModel) ToString() string {
 
 	var str string = ""
 	for k, v := range lr.Model {
-		str = str + "(" + strconv.Itoa(k) + "," + FloatToString(v) + ") "
+		str = str + "(" + lr.Dict.Name(k) + "," + FloatToString(v) + ") "
 	}
 
 	return str
 }
+
+//单个特征对预估值的贡献
+type Contribution struct {
+	Index  int     `json:"index"`
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+	Weight float64 `json:"weight"`
+	Score  float64 `json:"score"`
+}
+
+type cvector []Contribution
+
+func (cv cvector) Less(i, j int) bool {
+	return math.Abs(cv[i].Score) > math.Abs(cv[j].Score)
+}
+
+func (cv cvector) Len() int {
+	return len(cv)
+}
+
+func (cv cvector) Swap(i, j int) {
+	cv[i], cv[j] = cv[j], cv[i]
+}
+
+//解释预估结果，按贡献绝对值从大到小返回前topn个特征，topn<=0时全部返回
+func (lr *LRModel) Explain(x util.Pvector, topn int) []Contribution {
+	if !lr.Init {
+		return nil
+	}
+
+	x = lr.Transform(x)
+
+	var res cvector
+	for i := 0; i < len(x); i++ {
+		w := lr.Model[x[i].Index]
+		if w == 0 {
+			continue
+		}
+
+		res = append(res, Contribution{
+			Index:  x[i].Index,
+			Name:   lr.Dict.Name(x[i].Index),
+			Value:  x[i].Value,
+			Weight: w,
+			Score:  w * x[i].Value})
+	}
+
+	sort.Sort(res)
+	if topn > 0 && len(res) > topn {
+		res = res[:topn]
+	}
+
+	return res
+}
@@ -0,0 +1,114 @@
+package solver
+
+import (
+	"goline/util"
+	"math"
+)
+
+//小批量同步ftrl的worker，批内用批开始时参数服务器的参数预估，只累加各维度的梯度，
+//批结束时由ApplyGradients统一更新。批内参数服务器只读，worker之间不需要加锁
+type MiniBatchWorker struct {
+	FtrlSolver
+
+	Grad    []float64
+	touched []bool
+	dirty   []int
+}
+
+func (mw *MiniBatchWorker) Initialize(param_server *FtrlSolver) bool {
+	mw.FtrlSolver.Alpha = param_server.Alpha
+	mw.FtrlSolver.Beta = param_server.Beta
+	mw.FtrlSolver.L1 = param_server.L1
+	mw.FtrlSolver.L2 = param_server.L2
+	mw.FtrlSolver.Featnum = param_server.Featnum
+	mw.FtrlSolver.Dropout = param_server.Dropout
+
+	mw.Grad = make([]float64, param_server.Featnum)
+	mw.touched = make([]bool, param_server.Featnum)
+	mw.dirty = mw.dirty[:0]
+
+	mw.FtrlSolver.Init = true
+	return mw.FtrlSolver.Init
+}
+
+//用参数服务器的当前参数预估并累加梯度，返回更新前的预估值
+func (mw *MiniBatchWorker) UpdateWithWeight(
+	x util.Pvector,
+	y float64,
+	weight float64,
+	param_server *FtrlSolver) float64 {
+
+	if !mw.FtrlSolver.Init {
+		return 0.
+	}
+
+	x = param_server.Transform(x)
+
+	var wTx float64 = 0.
+	var kept []util.Pair
+	for i := 0; i < len(x); i++ {
+		item := x[i]
+		if util.UtilGreater(mw.FtrlSolver.Dropout, 0.0) {
+			if mw.FtrlSolver.uniform() < mw.FtrlSolver.Dropout {
+				continue
+			}
+		}
+
+		if item.Index >= mw.FtrlSolver.Featnum {
+			continue
+		}
+
+		wTx += param_server.GetWeight(item.Index) * item.Value
+		kept = append(kept, item)
+	}
+
+	var pred float64 = util.Sigmoid(wTx)
+	var grad float64 = (pred - y) * weight
+	for _, item := range kept {
+		i := item.Index
+		if !mw.touched[i] {
+			mw.touched[i] = true
+			mw.dirty = append(mw.dirty, i)
+		}
+
+		mw.Grad[i] += grad * item.Value
+	}
+
+	return pred
+}
+
+//按worker顺序把各worker的梯度汇总到第0个worker，再对每个改动的维度做一次ftrl更新，调用时各worker须暂停。
+//汇总顺序固定，结果与线程调度无关
+func (fs *FtrlSolver) ApplyGradients(workers []MiniBatchWorker) {
+	if len(workers) == 0 {
+		return
+	}
+
+	head := &workers[0]
+	for w := 1; w < len(workers); w++ {
+		mw := &workers[w]
+		for _, i := range mw.dirty {
+			if !head.touched[i] {
+				head.touched[i] = true
+				head.dirty = append(head.dirty, i)
+			}
+
+			head.Grad[i] += mw.Grad[i]
+			mw.Grad[i] = 0
+			mw.touched[i] = false
+		}
+		mw.dirty = mw.dirty[:0]
+	}
+
+	for _, i := range head.dirty {
+		grad_i := head.Grad[i]
+		w_i := fs.GetWeight(i)
+		sigma := (math.Sqrt(fs.N[i]+grad_i*grad_i) - math.Sqrt(fs.N[i])) / fs.Alpha
+		fs.Z[i] += grad_i - sigma*w_i
+		fs.N[i] += grad_i * grad_i
+
+		head.Grad[i] = 0
+		head.touched[i] = false
+	}
+	head.dirty = head.dirty[:0]
+}
@@ -0,0 +1,671 @@
+package solver
+
+import (
+	"errors"
+	"fmt"
+	"goline/deps/log4go"
+	"goline/util"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+const (
+	ShardRange = "range" //参数组按编号分段分配到各分片
+	ShardHash  = "hash"  //参数组按编号取模分配到各分片
+
+	ParamShardService     = "ParamShard"
+	DefaultFlushGroups    = 256
+	DefaultRefreshFlushes = 64
+)
+
+//分片的配置，由协调进程发给每个分片，worker连接时从分片取回超参数和分组信息
+type ShardInit struct {
+	Alpha     float64
+	Beta      float64
+	L1        float64
+	L2        float64
+	Dropout   float64
+	Featnum   int
+	GroupSize int
+	Shard     int
+	Shards    int
+	Sharding  string
+	LockMode  string
+
+	//分片内各参数组的初始值，为空时从0开始
+	N []float64
+	Z []float64
+}
+
+//push/fetch的参数组，Groups为全局编号，N、Z按参数组依次排列，每组GroupSize个
+type ShardParams struct {
+	Groups []int
+	N      []float64
+	Z      []float64
+}
+
+//参数组到分片的映射
+type shard_map struct {
+	groups int
+	shards int
+	per    int //range方式每个分片的参数组数，hash方式为0
+}
+
+func new_shard_map(featnum int, group_size int, shards int, sharding string) (shard_map, error) {
+	if shards <= 0 || group_size <= 0 {
+		return shard_map{}, errors.New(fmt.Sprintf("[new_shard_map] Invalid shards %d or group size %d.", shards, group_size))
+	}
+
+	sm := shard_map{groups: calc_group_num(featnum, group_size), shards: shards}
+	switch sharding {
+	case ShardRange, "":
+		sm.per = (sm.groups + shards - 1) / shards
+		if sm.per == 0 {
+			sm.per = 1
+		}
+	case ShardHash:
+	default:
+		return sm, errors.New("[new_shard_map] Unknown sharding " + sharding + ".")
+	}
+
+	return sm, nil
+}
+
+//参数组所在的分片和分片内的编号
+func (sm shard_map) locate(group int) (int, int) {
+	if sm.per > 0 {
+		return group / sm.per, group % sm.per
+	}
+
+	return group % sm.shards, group / sm.shards
+}
+
+func (sm shard_map) global(shard int, local int) int {
+	if sm.per > 0 {
+		return shard*sm.per + local
+	}
+
+	return local*sm.shards + shard
+}
+
+//分片内的参数组数
+func (sm shard_map) local_groups(shard int) int {
+	if sm.per > 0 {
+		return util.MaxInt(0, util.MinInt(sm.per, sm.groups-shard*sm.per))
+	}
+
+	return (sm.groups - shard + sm.shards - 1) / sm.shards
+}
+
+//参数服务器分片，通过net/rpc提供参数组的读取和累加，分片内的参数组用FtrlParamServer保存，加锁方式与进程内相同
+type ParamShard struct {
+	lock   sync.RWMutex
+	conf   ShardInit
+	smap   shard_map
+	server *FtrlParamServer
+	done   int
+	log    log4go.Logger
+}
+
+//初始化或重新初始化分片，已完成的worker数清零
+func (ps *ParamShard) Init(args *ShardInit, reply *int) error {
+	smap, err := new_shard_map(args.Featnum, args.GroupSize, args.Shards, args.Sharding)
+	if err != nil {
+		return err
+	}
+
+	var server FtrlParamServer
+	err = server.Configure(args.GroupSize, args.LockMode, 0)
+	if err != nil {
+		return err
+	}
+
+	featnum := smap.local_groups(args.Shard) * args.GroupSize
+	err = server.Initialize(args.Alpha, args.Beta, args.L1, args.L2, featnum, args.Dropout)
+	if err != nil {
+		return err
+	}
+
+	if len(args.N) == featnum && len(args.Z) == featnum {
+		copy(server.N, args.N)
+		copy(server.Z, args.Z)
+	}
+
+	ps.lock.Lock()
+	ps.conf = *args
+	ps.conf.N = nil
+	ps.conf.Z = nil
+	ps.smap = smap
+	ps.server = &server
+	ps.done = 0
+	ps.lock.Unlock()
+
+	ps.log.Info(fmt.Sprintf("[ParamShard-Init] Shard %d of %d, %d features, %d local groups.",
+		args.Shard, args.Shards, args.Featnum, smap.local_groups(args.Shard)))
+	*reply = featnum
+	return nil
+}
+
+func (ps *ParamShard) current() (*FtrlParamServer, error) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	if ps.server == nil {
+		return nil, errors.New("[ParamShard] Shard is not initialized.")
+	}
+
+	return ps.server, nil
+}
+
+func (ps *ParamShard) Info(args int, reply *ShardInit) error {
+	if _, err := ps.current(); err != nil {
+		return err
+	}
+
+	ps.lock.RLock()
+	*reply = ps.conf
+	ps.lock.RUnlock()
+	return nil
+}
+
+//参数组在分片内的编号，不属于本分片的参数组返回错误。分片可能被重新初始化，server和smap在同一次加锁中读取
+func (ps *ParamShard) locals(groups []int) (*FtrlParamServer, []int, error) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	if ps.server == nil {
+		return nil, nil, errors.New("[ParamShard] Shard is not initialized.")
+	}
+
+	shard := ps.conf.Shard
+	local_groups := ps.smap.local_groups(shard)
+	locals := make([]int, len(groups))
+	for k, g := range groups {
+		s, local := ps.smap.locate(g)
+		if g < 0 || g >= ps.smap.groups || s != shard || local < 0 || local >= local_groups {
+			return nil, nil, errors.New(fmt.Sprintf("[ParamShard] Group %d does not belong to shard %d.", g, shard))
+		}
+		locals[k] = local
+	}
+
+	return ps.server, locals, nil
+}
+
+func (ps *ParamShard) Fetch(args *ShardParams, reply *ShardParams) error {
+	server, locals, err := ps.locals(args.Groups)
+	if err != nil {
+		return err
+	}
+
+	gs := server.ParamGroupSize
+	reply.Groups = args.Groups
+	reply.N = make([]float64, len(args.Groups)*gs)
+	reply.Z = make([]float64, len(args.Groups)*gs)
+	for k, local := range locals {
+		server.fetch_group(reply.N, reply.Z, local, k*gs)
+	}
+
+	return nil
+}
+
+//累加更新并返回这些参数组更新后的值，worker用来刷新本地副本
+func (ps *ParamShard) Push(args *ShardParams, reply *ShardParams) error {
+	//全部参数组检查通过后再累加，不会只累加一部分
+	server, locals, err := ps.locals(args.Groups)
+	if err != nil {
+		return err
+	}
+
+	gs := server.ParamGroupSize
+	if len(args.N) != len(args.Groups)*gs || len(args.Z) != len(args.Groups)*gs {
+		return errors.New("[ParamShard-Push] Parameter size mismatch.")
+	}
+
+	reply.Groups = args.Groups
+	reply.N = make([]float64, len(args.Groups)*gs)
+	reply.Z = make([]float64, len(args.Groups)*gs)
+	for k, local := range locals {
+		server.push_group(args.N, args.Z, local, k*gs)
+		server.fetch_group(reply.N, reply.Z, local, k*gs)
+	}
+
+	return nil
+}
+
+//分片内全部参数组
+func (ps *ParamShard) Dump(args int, reply *ShardParams) error {
+	server, err := ps.current()
+	if err != nil {
+		return err
+	}
+
+	ps.lock.RLock()
+	shard := ps.conf.Shard
+	smap := ps.smap
+	ps.lock.RUnlock()
+
+	gs := server.ParamGroupSize
+	local_groups := smap.local_groups(shard)
+	reply.Groups = make([]int, local_groups)
+	reply.N = make([]float64, local_groups*gs)
+	reply.Z = make([]float64, local_groups*gs)
+	for l := 0; l < local_groups; l++ {
+		reply.Groups[l] = smap.global(shard, l)
+		server.fetch_group(reply.N, reply.Z, l, l*gs)
+	}
+
+	return nil
+}
+
+//worker训练完成，返回已完成的worker数
+func (ps *ParamShard) Done(args int, reply *int) error {
+	ps.lock.Lock()
+	ps.done++
+	*reply = ps.done
+	ps.lock.Unlock()
+
+	ps.log.Info(fmt.Sprintf("[ParamShard-Done] Worker %d done, %d workers done.", args, *reply))
+	return nil
+}
+
+func (ps *ParamShard) Status(args int, reply *int) error {
+	ps.lock.RLock()
+	*reply = ps.done
+	ps.lock.RUnlock()
+	return nil
+}
+
+//在addr上启动参数服务器分片，关闭返回的listener后停止服务
+func ServeParamShard(addr string) (net.Listener, error) {
+	shard := &ParamShard{log: util.GetLogger()}
+	server := rpc.NewServer()
+	err := server.RegisterName(ParamShardService, shard)
+	if err != nil {
+		return nil, errors.New("[ServeParamShard] Register rpc service error." + err.Error())
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.New("[ServeParamShard] Listen error." + err.Error())
+	}
+
+	go server.Accept(listener)
+	return listener, nil
+}
+
+//协调进程用fs的超参数、特征数(以及已有的N、Z)初始化addrs上的各分片，分片数为len(addrs)
+func InitParamShards(addrs []string, fs *FtrlSolver, group_size int, sharding string, lock_mode string) error {
+	if group_size <= 0 {
+		group_size = DefaultParamGroupSize
+	}
+
+	smap, err := new_shard_map(fs.Featnum, group_size, len(addrs), sharding)
+	if err != nil {
+		return err
+	}
+
+	for s, addr := range addrs {
+		args := ShardInit{
+			Alpha:     fs.Alpha,
+			Beta:      fs.Beta,
+			L1:        fs.L1,
+			L2:        fs.L2,
+			Dropout:   fs.Dropout,
+			Featnum:   fs.Featnum,
+			GroupSize: group_size,
+			Shard:     s,
+			Shards:    len(addrs),
+			Sharding:  sharding,
+			LockMode:  lock_mode}
+
+		if len(fs.N) == fs.Featnum && len(fs.Z) == fs.Featnum {
+			local_groups := smap.local_groups(s)
+			args.N = make([]float64, local_groups*group_size)
+			args.Z = make([]float64, local_groups*group_size)
+			for l := 0; l < local_groups; l++ {
+				start := smap.global(s, l) * group_size
+				end := util.MinInt(start+group_size, fs.Featnum)
+				copy(args.N[l*group_size:], fs.N[start:end])
+				copy(args.Z[l*group_size:], fs.Z[start:end])
+			}
+		}
+
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return errors.New(fmt.Sprintf("[InitParamShards] Connect shard %s error.%s", addr, err.Error()))
+		}
+
+		var featnum int
+		err = client.Call(ParamShardService+".Init", &args, &featnum)
+		client.Close()
+		if err != nil {
+			return errors.New(fmt.Sprintf("[InitParamShards] Initialize shard %s error.%s", addr, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+//远程参数服务器的客户端，FtrlWorker通过它与各分片交换参数。进程内各线程共用一个客户端，
+//FtrlSolver中的N、Z为参数的本地副本：fetch读取本地副本，push先累加到本地副本并缓存，
+//缓存的参数组达到FlushGroups个时按分片发送，用返回的最新值刷新这些参数组的副本；FetchParam刷新全部副本。
+//FetchParamGroup不访问分片，本进程没有push的参数组看不到其他worker的更新，
+//因此每RefreshFlushes次flush后刷新一次全部副本，副本最多落后RefreshFlushes*FlushGroups个参数组的push
+type RemoteParamServer struct {
+	FtrlSolver
+
+	ParamGroupSize int
+	ParamGroupNum  int
+	FlushGroups    int
+	RefreshFlushes int
+
+	smap    shard_map
+	clients []*rpc.Client
+	lock    sync.Mutex
+	pend_n  []float64
+	pend_z  []float64
+	pending [][]int //各分片待发送的参数组
+	marked  []bool
+	npend   int
+	flushes int //上次刷新全部副本之后的flush次数
+	log     log4go.Logger
+}
+
+func (rps *RemoteParamServer) Connect(addrs []string) error {
+	rps.log = util.GetLogger()
+	if len(addrs) == 0 {
+		return errors.New("[RemoteParamServer-Connect] No parameter server address.")
+	}
+
+	var info ShardInit
+	for s, addr := range addrs {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			rps.Close()
+			return errors.New(fmt.Sprintf("[RemoteParamServer-Connect] Connect shard %s error.%s", addr, err.Error()))
+		}
+		rps.clients = append(rps.clients, client)
+
+		var shard_info ShardInit
+		err = client.Call(ParamShardService+".Info", 0, &shard_info)
+		if err != nil {
+			rps.Close()
+			return errors.New(fmt.Sprintf("[RemoteParamServer-Connect] Shard %s info error.%s", addr, err.Error()))
+		}
+
+		if shard_info.Shard != s || shard_info.Shards != len(addrs) {
+			rps.Close()
+			return errors.New(fmt.Sprintf("[RemoteParamServer-Connect] Shard %s is %d of %d, expect %d of %d.",
+				addr, shard_info.Shard, shard_info.Shards, s, len(addrs)))
+		}
+
+		if s == 0 {
+			info = shard_info
+		}
+	}
+
+	smap, err := new_shard_map(info.Featnum, info.GroupSize, info.Shards, info.Sharding)
+	if err != nil {
+		rps.Close()
+		return err
+	}
+
+	if !rps.FtrlSolver.Initialize(info.Alpha, info.Beta, info.L1, info.L2, info.Featnum, info.Dropout) {
+		rps.Close()
+		return errors.New("[RemoteParamServer-Connect] Solver initializing error.")
+	}
+
+	rps.smap = smap
+	rps.ParamGroupSize = info.GroupSize
+	rps.ParamGroupNum = smap.groups
+	if rps.FlushGroups <= 0 {
+		rps.FlushGroups = DefaultFlushGroups
+	}
+	if rps.RefreshFlushes <= 0 {
+		rps.RefreshFlushes = DefaultRefreshFlushes
+	}
+
+	rps.pend_n = make([]float64, info.Featnum)
+	rps.pend_z = make([]float64, info.Featnum)
+	rps.pending = make([][]int, info.Shards)
+	rps.marked = make([]bool, smap.groups)
+	rps.npend = 0
+
+	rps.lock.Lock()
+	defer rps.lock.Unlock()
+	return rps.refresh()
+}
+
+func (rps *RemoteParamServer) Close() {
+	for _, client := range rps.clients {
+		client.Close()
+	}
+	rps.clients = nil
+}
+
+func (rps *RemoteParamServer) Solver() *FtrlSolver {
+	return &rps.FtrlSolver
+}
+
+func (rps *RemoteParamServer) GroupSize() int {
+	return rps.ParamGroupSize
+}
+
+func (rps *RemoteParamServer) group_range(group int) (int, int) {
+	return group * rps.ParamGroupSize, util.MinInt((group+1)*rps.ParamGroupSize, rps.FtrlSolver.Featnum)
+}
+
+func (rps *RemoteParamServer) FetchParamGroup(n []float64, z []float64, group int) error {
+	if !rps.FtrlSolver.Init {
+		return errors.New("[RemoteParamServer-FetchParamGroup] Remote parameter server is not connected.")
+	}
+
+	start, end := rps.group_range(group)
+	rps.lock.Lock()
+	copy(n[start:end], rps.FtrlSolver.N[start:end])
+	copy(z[start:end], rps.FtrlSolver.Z[start:end])
+	rps.lock.Unlock()
+	return nil
+}
+
+func (rps *RemoteParamServer) PushParamGroup(n []float64, z []float64, group int) error {
+	if !rps.FtrlSolver.Init {
+		return errors.New("[RemoteParamServer-PushParamGroup] Remote parameter server is not connected.")
+	}
+
+	start, end := rps.group_range(group)
+	rps.lock.Lock()
+	defer rps.lock.Unlock()
+
+	for i := start; i < end; i++ {
+		rps.FtrlSolver.N[i] += n[i]
+		rps.FtrlSolver.Z[i] += z[i]
+		rps.pend_n[i] += n[i]
+		rps.pend_z[i] += z[i]
+		n[i] = 0
+		z[i] = 0
+	}
+
+	if !rps.marked[group] {
+		rps.marked[group] = true
+		shard, _ := rps.smap.locate(group)
+		rps.pending[shard] = append(rps.pending[shard], group)
+		rps.npend++
+	}
+
+	if rps.npend >= rps.FlushGroups {
+		rps.flushes++
+		if rps.flushes >= rps.RefreshFlushes {
+			return rps.refresh()
+		}
+		return rps.flush()
+	}
+
+	return nil
+}
+
+//刷新全部本地副本并读取
+func (rps *RemoteParamServer) FetchParam(n []float64, z []float64) error {
+	if !rps.FtrlSolver.Init {
+		return errors.New("[RemoteParamServer-FetchParam] Remote parameter server is not connected.")
+	}
+
+	rps.lock.Lock()
+	defer rps.lock.Unlock()
+
+	err := rps.refresh()
+	if err != nil {
+		return err
+	}
+
+	copy(n, rps.FtrlSolver.N)
+	copy(z, rps.FtrlSolver.Z)
+	return nil
+}
+
+//发送缓存的全部更新
+func (rps *RemoteParamServer) Flush() error {
+	rps.lock.Lock()
+	defer rps.lock.Unlock()
+	return rps.flush()
+}
+
+//按分片并行调用，调用时持有lock。调用失败的分片的reply置为nil
+func (rps *RemoteParamServer) call_shards(method string, args []interface{}, replies []*ShardParams) error {
+	calls := make([]*rpc.Call, len(rps.clients))
+	for s, client := range rps.clients {
+		if args[s] != nil {
+			calls[s] = client.Go(ParamShardService+"."+method, args[s], replies[s], nil)
+		}
+	}
+
+	var err error
+	for s, call := range calls {
+		if call == nil {
+			continue
+		}
+
+		<-call.Done
+		if call.Error != nil {
+			replies[s] = nil
+			if err == nil {
+				err = errors.New(fmt.Sprintf("[RemoteParamServer-%s] Shard %d error.%s", method, s, call.Error.Error()))
+			}
+		}
+	}
+
+	return err
+}
+
+//用分片返回的参数组更新本地副本
+func (rps *RemoteParamServer) apply(reply *ShardParams) {
+	gs := rps.ParamGroupSize
+	for k, g := range reply.Groups {
+		start, end := rps.group_range(g)
+		copy(rps.FtrlSolver.N[start:end], reply.N[k*gs:k*gs+end-start])
+		copy(rps.FtrlSolver.Z[start:end], reply.Z[k*gs:k*gs+end-start])
+	}
+}
+
+func (rps *RemoteParamServer) flush() error {
+	if rps.npend == 0 {
+		return nil
+	}
+
+	gs := rps.ParamGroupSize
+	args := make([]interface{}, len(rps.clients))
+	replies := make([]*ShardParams, len(rps.clients))
+	for s, groups := range rps.pending {
+		if len(groups) == 0 {
+			continue
+		}
+
+		params := &ShardParams{Groups: groups, N: make([]float64, len(groups)*gs), Z: make([]float64, len(groups)*gs)}
+		for k, g := range groups {
+			start, end := rps.group_range(g)
+			copy(params.N[k*gs:], rps.pend_n[start:end])
+			copy(params.Z[k*gs:], rps.pend_z[start:end])
+		}
+
+		args[s] = params
+		replies[s] = &ShardParams{}
+	}
+
+	//只清除发送成功的分片的缓存，失败的分片保留更新，下次flush时重新发送
+	err := rps.call_shards("Push", args, replies)
+	for s, reply := range replies {
+		if reply == nil {
+			continue
+		}
+
+		for _, g := range rps.pending[s] {
+			start, end := rps.group_range(g)
+			for i := start; i < end; i++ {
+				rps.pend_n[i] = 0
+				rps.pend_z[i] = 0
+			}
+			rps.marked[g] = false
+		}
+		rps.npend -= len(rps.pending[s])
+		rps.pending[s] = nil
+		rps.apply(reply)
+	}
+
+	if err != nil {
+		rps.log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+//发送缓存的更新后取回全部参数，调用时持有lock
+func (rps *RemoteParamServer) refresh() error {
+	err := rps.flush()
+	if err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(rps.clients))
+	replies := make([]*ShardParams, len(rps.clients))
+	for s := 0; s < len(rps.clients); s++ {
+		args[s] = 0
+		replies[s] = &ShardParams{}
+	}
+
+	err = rps.call_shards("Dump", args, replies)
+	if err != nil {
+		rps.log.Error(err.Error())
+		return err
+	}
+
+	for _, reply := range replies {
+		rps.apply(reply)
+	}
+
+	rps.flushes = 0
+	return nil
+}
+
+//通知分片本worker训练完成，返回已完成的worker数
+func (rps *RemoteParamServer) Done(worker int) (int, error) {
+	var done int
+	if len(rps.clients) == 0 {
+		return 0, errors.New("[RemoteParamServer-Done] Remote parameter server is not connected.")
+	}
+
+	err := rps.clients[0].Call(ParamShardService+".Done", worker, &done)
+	return done, err
+}
+
+//已完成的worker数
+func (rps *RemoteParamServer) Status() (int, error) {
+	var done int
+	if len(rps.clients) == 0 {
+		return 0, errors.New("[RemoteParamServer-Status] Remote parameter server is not connected.")
+	}
+
+	err := rps.clients[0].Call(ParamShardService+".Status", 0, &done)
+	return done, err
+}
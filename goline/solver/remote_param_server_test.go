@@ -0,0 +1,204 @@
+package solver
+
+import (
+	"goline/util"
+	"net/rpc"
+	"testing"
+)
+
+func TestShardMap(t *testing.T) {
+	for _, sharding := range []string{ShardRange, ShardHash} {
+		for _, shards := range []int{1, 3, 7} {
+			sm, err := new_shard_map(1000, 7, shards, sharding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//每个参数组恰好属于一个分片，分片内编号连续
+			counts := make([]int, shards)
+			for g := 0; g < sm.groups; g++ {
+				shard, local := sm.locate(g)
+				if shard < 0 || shard >= shards || local < 0 || local >= sm.local_groups(shard) {
+					t.Fatalf("%s/%d: group %d at shard %d local %d", sharding, shards, g, shard, local)
+				}
+
+				if sm.global(shard, local) != g {
+					t.Fatalf("%s/%d: group %d maps back to %d", sharding, shards, g, sm.global(shard, local))
+				}
+				counts[shard]++
+			}
+
+			for s, count := range counts {
+				if count != sm.local_groups(s) {
+					t.Fatalf("%s/%d: shard %d has %d groups, expect %d", sharding, shards, s, count, sm.local_groups(s))
+				}
+			}
+		}
+	}
+
+	if _, err := new_shard_map(1000, 7, 3, "mod"); err == nil {
+		t.Fatal("unknown sharding accepted")
+	}
+}
+
+func TestParamShardGroups(t *testing.T) {
+	ps := &ParamShard{log: util.GetLogger()}
+	args := &ShardParams{Groups: []int{1}, N: make([]float64, 4), Z: make([]float64, 4)}
+	if err := ps.Push(args, &ShardParams{}); err == nil {
+		t.Fatal("push to uninitialized shard accepted")
+	}
+
+	var featnum int
+	init := &ShardInit{Alpha: 0.1, Beta: 1, Featnum: 40, GroupSize: 4, Shard: 1, Shards: 3, Sharding: ShardHash, LockMode: LockGroup}
+	if err := ps.Init(init, &featnum); err != nil {
+		t.Fatal(err)
+	}
+
+	//10个参数组按取模分配，分片1有1、4、7三组
+	for _, g := range []int{0, 2, 3, 10, -2} {
+		args := &ShardParams{Groups: []int{4, g}, N: make([]float64, 8), Z: make([]float64, 8)}
+		args.N[0] = 1
+		if err := ps.Push(args, &ShardParams{}); err == nil {
+			t.Fatalf("push to group %d accepted", g)
+		}
+
+		if err := ps.Fetch(&ShardParams{Groups: []int{g}}, &ShardParams{}); err == nil {
+			t.Fatalf("fetch group %d accepted", g)
+		}
+	}
+
+	//检查失败的push不累加任何参数组
+	var reply ShardParams
+	if err := ps.Fetch(&ShardParams{Groups: []int{1, 4, 7}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range reply.N {
+		if v != 0 {
+			t.Fatalf("n[%d]=%g after rejected push", i, v)
+		}
+	}
+}
+
+func TestRemoteParamServerFlushError(t *testing.T) {
+	var addrs []string
+	for s := 0; s < 2; s++ {
+		listener, err := ServeParamShard("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		addrs = append(addrs, listener.Addr().String())
+	}
+
+	var fs FtrlSolver
+	fs.Initialize(0.1, 1, 0, 0, 100, 0)
+	if err := InitParamShards(addrs, &fs, 10, ShardRange, LockGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	rps := &RemoteParamServer{FlushGroups: 100}
+	if err := rps.Connect(addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer rps.Close()
+
+	n := make([]float64, 100)
+	z := make([]float64, 100)
+	for g := 0; g < rps.ParamGroupNum; g++ {
+		start, end := rps.group_range(g)
+		for i := start; i < end; i++ {
+			n[i], z[i] = 1, -1
+		}
+		if err := rps.PushParamGroup(n, z, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//分片1的连接断开，分片0的更新发送成功，分片1的更新保留
+	rps.clients[1].Close()
+	if err := rps.Flush(); err == nil {
+		t.Fatal("flush to closed shard succeeded")
+	}
+
+	if rps.npend != 5 || len(rps.pending[0]) != 0 || len(rps.pending[1]) != 5 || rps.pend_n[0] != 0 || rps.pend_n[50] != 1 {
+		t.Fatalf("pending=%v npend=%d", rps.pending, rps.npend)
+	}
+
+	client, err := rpc.Dial("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rps.clients[1] = client
+
+	//重新连接后重发，每个更新在分片上只累加一次
+	if err := rps.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := &RemoteParamServer{}
+	if err := check.Connect(addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer check.Close()
+
+	if err := check.FetchParam(n, z); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range n {
+		if n[i] != 1 || z[i] != -1 {
+			t.Fatalf("param %d: n=%g z=%g", i, n[i], z[i])
+		}
+	}
+}
+
+//没有push的参数组在RefreshFlushes次flush后看到其他worker的更新
+func TestRemoteParamServerRefresh(t *testing.T) {
+	listener, err := ServeParamShard("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addrs := []string{listener.Addr().String()}
+
+	var fs FtrlSolver
+	fs.Initialize(0.1, 1, 0, 0, 100, 0)
+	if err := InitParamShards(addrs, &fs, 10, ShardRange, LockGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &RemoteParamServer{FlushGroups: 1}
+	if err := other.Connect(addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	rps := &RemoteParamServer{FlushGroups: 1, RefreshFlushes: 2}
+	if err := rps.Connect(addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer rps.Close()
+
+	n := make([]float64, 100)
+	z := make([]float64, 100)
+	z[0] = 1
+	if err := other.PushParamGroup(n, z, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := 1; k <= 2; k++ {
+		z[10] = 1
+		if err := rps.PushParamGroup(n, z, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rps.FetchParamGroup(n, z, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := float64(k - 1); z[0] != expect {
+			t.Fatalf("flush %d: z[0]=%g, expect %g", k, z[0], expect)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package solver
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//有界延迟(stale synchronous parallel)的时钟：每个worker训练固定数量的样本时钟加1，
+//worker的时钟比最慢的活动worker快Staleness以上时阻塞，直到最慢的worker追上，之后才能继续从参数服务器读取参数。
+//读完数据的worker退出后不再阻塞其它worker
+type SSPClock struct {
+	Staleness int
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	clocks []int
+	active []bool
+	stats  SSPStats
+}
+
+//延迟统计，Gap为worker时钟加1时与最慢的活动worker的时钟差
+type SSPStats struct {
+	Ticks    int64
+	Waits    int64
+	WaitTime float64 //阻塞的总秒数，各worker累加
+	MaxGap   int
+	GapSum   int64
+}
+
+func (ss SSPStats) MeanGap() float64 {
+	if ss.Ticks == 0 {
+		return 0
+	}
+
+	return float64(ss.GapSum) / float64(ss.Ticks)
+}
+
+func NewSSPClock(workers int, staleness int) *SSPClock {
+	sc := &SSPClock{Staleness: staleness}
+	sc.cond = sync.NewCond(&sc.lock)
+	sc.clocks = make([]int, workers)
+	sc.active = make([]bool, workers)
+	sc.Reset()
+	return sc
+}
+
+//每轮开始时所有worker的时钟清零并重新加入
+func (sc *SSPClock) Reset() {
+	sc.lock.Lock()
+	for i := 0; i < len(sc.clocks); i++ {
+		sc.clocks[i] = 0
+		sc.active[i] = true
+	}
+	sc.lock.Unlock()
+}
+
+//调用时持有lock，没有活动worker时返回最大值
+func (sc *SSPClock) min_active() int {
+	min := math.MaxInt32
+	for i := 0; i < len(sc.clocks); i++ {
+		if sc.active[i] && sc.clocks[i] < min {
+			min = sc.clocks[i]
+		}
+	}
+
+	return min
+}
+
+//worker的时钟加1，超出延迟上限时阻塞，返回新的时钟
+func (sc *SSPClock) Tick(worker int) int {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sc.clocks[worker]++
+	clock := sc.clocks[worker]
+	sc.cond.Broadcast()
+
+	gap := clock - sc.min_active()
+	sc.stats.Ticks++
+	sc.stats.GapSum += int64(gap)
+	if gap > sc.stats.MaxGap {
+		sc.stats.MaxGap = gap
+	}
+
+	if gap > sc.Staleness {
+		sc.stats.Waits++
+		start := time.Now()
+		for clock-sc.min_active() > sc.Staleness {
+			sc.cond.Wait()
+		}
+		sc.stats.WaitTime += time.Since(start).Seconds()
+	}
+
+	return clock
+}
+
+//worker读完本轮数据或中断退出
+func (sc *SSPClock) Leave(worker int) {
+	sc.lock.Lock()
+	sc.active[worker] = false
+	sc.cond.Broadcast()
+	sc.lock.Unlock()
+}
+
+//返回并清空统计
+func (sc *SSPClock) Stats() SSPStats {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	stats := sc.stats
+	sc.stats = SSPStats{}
+	return stats
+}
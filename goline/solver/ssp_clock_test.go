@@ -0,0 +1,75 @@
+package solver
+
+import (
+	"testing"
+	"time"
+)
+
+func ticked(t *testing.T, done chan int, expect bool) {
+	select {
+	case <-done:
+		if !expect {
+			t.Fatal("tick returned while ahead of the slowest worker")
+		}
+	case <-time.After(100 * time.Millisecond):
+		if expect {
+			t.Fatal("tick still blocked")
+		}
+	}
+}
+
+func TestSSPClockBlocks(t *testing.T) {
+	sc := NewSSPClock(3, 1)
+	if sc.Tick(0) != 1 {
+		t.Fatal("first tick")
+	}
+
+	//worker 0领先最慢的worker两个时钟，阻塞到worker 1、2都追上
+	done := make(chan int, 1)
+	go func() {
+		done <- sc.Tick(0)
+	}()
+	ticked(t, done, false)
+
+	sc.Tick(1)
+	ticked(t, done, false)
+
+	//读完数据退出的worker不再阻塞其它worker
+	sc.Leave(2)
+	ticked(t, done, true)
+
+	stats := sc.Stats()
+	if stats.Ticks != 3 || stats.Waits != 1 || stats.MaxGap != 2 || stats.WaitTime <= 0 {
+		t.Fatalf("stats=%+v", stats)
+	}
+
+	//新的一轮时钟清零，全部worker重新加入
+	sc.Reset()
+	sc.Tick(0)
+	go func() {
+		done <- sc.Tick(0)
+	}()
+	ticked(t, done, false)
+
+	sc.Tick(1)
+	sc.Tick(2)
+	ticked(t, done, true)
+}
+
+func TestSSPClockRemoteRejected(t *testing.T) {
+	var fps FtrlParamServer
+	if err := fps.Initialize(0.1, 1, 0, 0, 100, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var fw FtrlWorker
+	fw.Initialize(&fps, 1, 1)
+	fw.SetClock(NewSSPClock(1, 2), 0, 10)
+	if err := fw.Reset(&fps); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fw.Reset(&RemoteParamServer{}); err == nil {
+		t.Fatal("bounded staleness accepted with remote parameter server")
+	}
+}
@@ -0,0 +1,142 @@
+package solver
+
+import (
+	"errors"
+	"goline/util"
+)
+
+//模型的原始特征数。特征空间中原始特征在前，之后依次为分箱特征和交叉特征
+func (fs *FtrlSolver) RawFeatnum() int {
+	raw := fs.Featnum
+	if fs.Preprocess != nil {
+		for i := 0; i < len(fs.Preprocess.Rules) && i < len(fs.Preprocess.BinOffset); i++ {
+			if fs.Preprocess.Rules[i].Bins > 0 {
+				raw = util.MinInt(raw, fs.Preprocess.BinOffset[i])
+			}
+		}
+	}
+
+	if fs.Cross != nil && fs.Cross.Init {
+		raw = util.MinInt(raw, fs.Cross.Offset)
+	}
+
+	return raw
+}
+
+//按特征块复制src的参数：原始特征、各分箱规则的分箱特征和交叉特征分别按各自的偏移对齐，
+//两个模型的特征空间布局不同时也不会把一类特征的参数复制给另一类。返回复制的特征数
+func (fs *FtrlSolver) CopyParamBlocks(src *FtrlSolver) int {
+	copy_block := func(dst_start int, src_start int, size int) int {
+		size = util.MinInt(size, fs.Featnum-dst_start, src.Featnum-src_start, len(src.N)-src_start, len(src.Z)-src_start)
+		if dst_start < 0 || src_start < 0 || size <= 0 {
+			return 0
+		}
+
+		copy(fs.N[dst_start:dst_start+size], src.N[src_start:src_start+size])
+		copy(fs.Z[dst_start:dst_start+size], src.Z[src_start:src_start+size])
+		return size
+	}
+
+	cnt := copy_block(0, 0, util.MinInt(fs.RawFeatnum(), src.RawFeatnum()))
+
+	//分箱规则按顺序对应，字段区间或分箱数不同时分箱特征的含义不同，不复制
+	if fs.Preprocess != nil && src.Preprocess != nil {
+		dst_pp, src_pp := fs.Preprocess, src.Preprocess
+		for i := 0; i < len(dst_pp.Rules) && i < len(src_pp.Rules) && i < len(dst_pp.BinOffset) && i < len(src_pp.BinOffset); i++ {
+			rule, src_rule := dst_pp.Rules[i], src_pp.Rules[i]
+			if rule.Bins <= 0 || rule.Bins != src_rule.Bins || rule.Field.Start != src_rule.Field.Start || rule.Field.End != src_rule.Field.End {
+				continue
+			}
+
+			cnt += copy_block(dst_pp.BinOffset[i], src_pp.BinOffset[i], (rule.Field.End-rule.Field.Start)*rule.Bins)
+		}
+	}
+
+	//交叉特征的下标由原始特征下标的hash决定，hash位数相同时逐个对应
+	if fs.Cross != nil && fs.Cross.Init && src.Cross != nil && src.Cross.Init && fs.Cross.HashBits == src.Cross.HashBits {
+		cnt += copy_block(fs.Cross.Offset, src.Cross.Offset, 1<<uint(fs.Cross.HashBits))
+	}
+
+	return cnt
+}
+
+//原始特征扩展到raw_num个，分箱特征和交叉特征连同参数整体后移，新特征的N、Z为0。
+//raw_num不大于原始特征数时不变，返回是否扩展
+func (fs *FtrlSolver) Grow(raw_num int) bool {
+	raw := fs.RawFeatnum()
+	if raw_num <= raw {
+		return false
+	}
+
+	delta := raw_num - raw
+	featnum := fs.Featnum + delta
+	n := make([]float64, featnum)
+	z := make([]float64, featnum)
+	copy(n[:raw], fs.N[:raw])
+	copy(z[:raw], fs.Z[:raw])
+	copy(n[raw+delta:], fs.N[raw:fs.Featnum])
+	copy(z[raw+delta:], fs.Z[raw:fs.Featnum])
+
+	if fs.Preprocess != nil {
+		for i := 0; i < len(fs.Preprocess.Rules) && i < len(fs.Preprocess.BinOffset); i++ {
+			if fs.Preprocess.Rules[i].Bins > 0 {
+				fs.Preprocess.BinOffset[i] += delta
+			}
+		}
+	}
+
+	if fs.Cross != nil && fs.Cross.Init {
+		fs.Cross.Offset += delta
+	}
+
+	fs.N = n
+	fs.Z = z
+	fs.Featnum = featnum
+	return true
+}
+
+//按特征名把参数映射到dict的下标空间，dict中没有的特征丢弃，返回保留和丢弃的特征数。
+//分箱和交叉特征与原始特征的下标有关，模型有预处理或特征交叉时不能重新映射
+func (fs *FtrlSolver) Remap(dict *util.FeatureDict) (int, int, error) {
+	if fs.Dict == nil || dict == nil {
+		return 0, 0, errors.New("[FtrlSolver-Remap] Feature dictionary is missing.")
+	}
+
+	if fs.Preprocess != nil || fs.Cross != nil {
+		return 0, 0, errors.New("[FtrlSolver-Remap] Can not remap model with feature preprocess or cross.")
+	}
+
+	featnum := util.MaxInt(dict.Size(), 1)
+	n := make([]float64, featnum)
+	z := make([]float64, featnum)
+
+	//偏置的下标不变
+	if fs.Featnum > 0 {
+		n[0] = fs.N[0]
+		z[0] = fs.Z[0]
+	}
+
+	kept, dropped := 0, 0
+	for i := 0; i < len(fs.Dict.Entries); i++ {
+		entry := fs.Dict.Entries[i]
+		if entry.Index >= fs.Featnum {
+			continue
+		}
+
+		idx, ok := dict.Find(entry.Key())
+		if !ok || idx >= featnum {
+			dropped++
+			continue
+		}
+
+		n[idx] = fs.N[entry.Index]
+		z[idx] = fs.Z[entry.Index]
+		kept++
+	}
+
+	fs.N = n
+	fs.Z = z
+	fs.Featnum = featnum
+	fs.Dict = dict
+	return kept, dropped, nil
+}
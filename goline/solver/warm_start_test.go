@@ -0,0 +1,135 @@
+package solver
+
+import (
+	"goline/util"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func random_params(fs *FtrlSolver, seed int64) {
+	rd := rand.New(rand.NewSource(seed))
+	for i := 0; i < fs.Featnum; i++ {
+		fs.N[i] = rd.Float64()
+		fs.Z[i] = rd.Float64()*40 - 20
+	}
+}
+
+func TestGrow(t *testing.T) {
+	var cross util.FeatureCross
+	fields := []util.FieldRange{{Name: "a", Start: 1, End: 5}, {Name: "b", Start: 5, End: 10}}
+	featnum, err := cross.Initialize(util.CrossConfig{Fields: fields, HashBits: 3}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fs FtrlSolver
+	fs.Initialize(0.1, 1, 1, 1, featnum, 0)
+	fs.Cross = &cross
+	random_params(&fs, 1)
+	if fs.RawFeatnum() != 10 {
+		t.Fatalf("raw features %d", fs.RawFeatnum())
+	}
+
+	x := util.Pvector{{Index: 0, Value: 1}, {Index: 2, Value: 1}, {Index: 7, Value: 1}, {Index: 9, Value: 0.5}}
+	before := fs.Predict(x)
+	if fs.Grow(8) {
+		t.Fatal("grow to fewer raw features")
+	}
+
+	//交叉特征连同参数后移，已有样本的预估值不变，新特征从0开始
+	if !fs.Grow(14) || fs.Featnum != featnum+4 || fs.RawFeatnum() != 14 || cross.Offset != 14 {
+		t.Fatalf("featnum=%d raw=%d offset=%d", fs.Featnum, fs.RawFeatnum(), cross.Offset)
+	}
+
+	if after := fs.Predict(x); math.Abs(after-before) > 1e-12 {
+		t.Fatalf("prediction %g, before growth %g", after, before)
+	}
+
+	for i := 10; i < 14; i++ {
+		if fs.N[i] != 0 || fs.Z[i] != 0 {
+			t.Fatalf("new feature %d: n=%g z=%g", i, fs.N[i], fs.Z[i])
+		}
+	}
+}
+
+func TestRemap(t *testing.T) {
+	old := util.NewFeatureDict()
+	old.Grow = true
+	for _, key := range []string{"a", "b", "c"} {
+		old.Lookup(key)
+	}
+
+	dict := util.NewFeatureDict()
+	dict.Grow = true
+	for _, key := range []string{"d", "c", "e", "a"} {
+		dict.Lookup(key)
+	}
+
+	var fs FtrlSolver
+	fs.Initialize(0.1, 1, 1, 1, old.Size(), 0)
+	fs.Dict = old
+	random_params(&fs, 2)
+	expect := fs.Predict(util.Pvector{{Index: 0, Value: 1}, {Index: 1, Value: 1}, {Index: 3, Value: 1}})
+
+	//a、c按特征名移到新下标，b不在新字典中被丢弃，也不会加入新字典
+	kept, dropped, err := fs.Remap(dict)
+	if err != nil || kept != 2 || dropped != 1 || fs.Featnum != 5 || dict.Size() != 5 || fs.Dict != dict {
+		t.Fatalf("kept=%d dropped=%d featnum=%d err=%v", kept, dropped, fs.Featnum, err)
+	}
+
+	a, _ := dict.Lookup("a")
+	c, _ := dict.Lookup("c")
+	if got := fs.Predict(util.Pvector{{Index: 0, Value: 1}, {Index: a, Value: 1}, {Index: c, Value: 1}}); math.Abs(got-expect) > 1e-12 {
+		t.Fatalf("prediction %g, before remap %g", got, expect)
+	}
+
+	for _, key := range []string{"d", "e"} {
+		idx, _ := dict.Lookup(key)
+		if fs.N[idx] != 0 || fs.Z[idx] != 0 {
+			t.Fatalf("feature %s not reset", key)
+		}
+	}
+
+	fs.Cross = &util.FeatureCross{Init: true, Offset: fs.Featnum}
+	if _, _, err := fs.Remap(old); err == nil {
+		t.Fatal("remap with feature cross accepted")
+	}
+}
+
+func TestCopyParamBlocks(t *testing.T) {
+	fields := []util.FieldRange{{Name: "a", Start: 1, End: 5}, {Name: "b", Start: 5, End: 10}}
+	build := func(raw int) *FtrlSolver {
+		var cross util.FeatureCross
+		featnum, err := cross.Initialize(util.CrossConfig{Fields: fields, HashBits: 3}, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fs FtrlSolver
+		fs.Initialize(0.1, 1, 1, 1, featnum, 0)
+		fs.Cross = &cross
+		return &fs
+	}
+
+	src := build(14)
+	random_params(src, 3)
+	x := util.Pvector{{Index: 0, Value: 1}, {Index: 2, Value: 1}, {Index: 7, Value: 1}, {Index: 12, Value: 0.5}}
+	expect := src.Predict(x)
+
+	//目标的原始特征更多，交叉特征整体后移
+	dst := build(20)
+	if cnt := dst.CopyParamBlocks(src); cnt != 14+8 {
+		t.Fatalf("copied %d", cnt)
+	}
+
+	if got := dst.Predict(x); math.Abs(got-expect) > 1e-12 {
+		t.Fatalf("prediction %g, source %g", got, expect)
+	}
+
+	for i := 14; i < 20; i++ {
+		if dst.N[i] != 0 || dst.Z[i] != 0 {
+			t.Fatalf("feature %d copied from cross block", i)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"goline/util"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//吞吐对比配置，各训练器使用相同的训练参数和线程数
+type BenchConfig struct {
+	TrainerConfig
+
+	Alpha   float64
+	Beta    float64
+	L1      float64
+	L2      float64
+	Dropout float64
+}
+
+//一个训练器的吞吐结果，Time只计训练时间，不含预扫描和评估。
+//给出测试文件时LogLoss、AUC为测试集指标，否则为最后一轮先预测后更新的指标。
+//各训练器的每个线程使用由Seed派生的dropout随机数。minibatch每BatchSize*Threads个样本才更新一次参数，
+//批内用批开始时的参数预估，BatchSize较大时同样轮数下的指标比逐样本更新的训练器差
+type ThroughputResult struct {
+	Trainer       string  `json:"Trainer"`
+	Threads       int     `json:"Threads"`
+	BatchSize     int     `json:"BatchSize,omitempty"`
+	Seed          int64   `json:"Seed"`
+	Samples       int64   `json:"Samples"`
+	Time          float64 `json:"Time"`
+	SamplesPerSec float64 `json:"SamplesPerSec"`
+	LogLoss       float64 `json:"LogLoss"`
+	AUC           float64 `json:"AUC"`
+	Error         string  `json:"Error,omitempty"`
+}
+
+//依次用各训练器训练同一份数据并比较吞吐，trainers为空时比较lockfree、fast和minibatch。
+//fast可写为fast:加锁方式[:分组大小]比较参数服务器的配置，如fast:group:10、fast:striped:100、fast:atomic。
+//训练前先建好特征数缓存，避免第一个训练器承担缓存的开销
+func BenchmarkTrainers(
+	ctx context.Context,
+	conf BenchConfig,
+	trainers []string,
+	train_file string,
+	test_file string) ([]ThroughputResult, error) {
+
+	if !util.FileExists(train_file) || (len(test_file) != 0 && !util.FileExists(test_file)) {
+		return nil, errors.New("[BenchmarkTrainers] Train file or test file is not exist.")
+	}
+
+	if len(trainers) == 0 {
+		trainers = []string{TrainerLockFree, TrainerFast, TrainerMiniBatch}
+	}
+
+	if conf.Epoch <= 0 {
+		conf.Epoch = 1
+	}
+
+	if conf.CacheFeatureNum {
+		read_problem_info(ctx, train_file, true, conf.NumThreads, nil)
+	}
+
+	results := make([]ThroughputResult, 0, len(trainers))
+	for _, kind := range trainers {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		results = append(results, conf.run(ctx, kind, train_file, test_file))
+	}
+
+	return results, nil
+}
+
+//解析fast:加锁方式[:分组大小]
+func parse_bench_kind(name string, conf *TrainerConfig) string {
+	parts := strings.Split(name, ":")
+	if len(parts) > 1 {
+		conf.LockMode = parts[1]
+	}
+
+	if len(parts) > 2 {
+		conf.ParamGroupSize = util.String2Int(parts[2])
+	}
+
+	return parts[0]
+}
+
+func (bc *BenchConfig) run(ctx context.Context, name string, train_file string, test_file string) ThroughputResult {
+	res := ThroughputResult{Trainer: name, Threads: thread_num(bc.NumThreads), Seed: bc.Seed}
+
+	conf := bc.TrainerConfig
+	conf.JobName = "bench " + name
+	kind := parse_bench_kind(name, &conf)
+	if kind == TrainerFtrl {
+		res.Threads = 1
+	}
+
+	tr, err := NewTrainer(kind, conf)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	tr.SetContext(ctx)
+	if mbt, ok := tr.(*MiniBatchFtrlTrainer); ok {
+		res.BatchSize = mbt.BatchSize
+	}
+
+	var last TrainState
+	tr.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+		res.Samples += state.Processed
+		last = *state
+		return nil
+	}})
+
+	fp, err := ioutil.TempFile("", "goline_bench_")
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	model_file := fp.Name()
+	fp.Close()
+	defer os.Remove(model_file)
+
+	err = tr.Train(bc.Alpha, bc.Beta, bc.L1, bc.L2, bc.Dropout, model_file, train_file, "")
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Time = last.Time
+	if res.Time > 0 {
+		res.SamplesPerSec = float64(res.Samples) / res.Time
+	}
+
+	if len(test_file) == 0 {
+		res.LogLoss = last.TrainLoss
+		res.AUC = last.TrainAUC
+		return res
+	}
+
+	model := tr.Model()
+	eval := evaluate_file(ctx, test_file, model.Predict, bc.NumThreads, model.Dict, read_mode(bc.CacheFeatureNum, false))
+	res.LogLoss = eval.Loss
+	res.AUC = eval.AUC
+	return res
+}
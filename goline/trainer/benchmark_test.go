@@ -0,0 +1,48 @@
+package trainer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchmarkMiniBatchConvergence(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, train_file, 40000, 1)
+	write_synthetic_file(t, test_file, 5000, 2)
+
+	bench := func(batch_size int) map[string]ThroughputResult {
+		conf := BenchConfig{Alpha: 0.1, Beta: 1, L1: 1, L2: 1, Dropout: 0.1}
+		conf.NumThreads = 4
+		conf.Epoch = 2
+		conf.BatchSize = batch_size
+		conf.Seed = 3
+		results, err := BenchmarkTrainers(context.Background(), conf, []string{TrainerFast, TrainerMiniBatch}, train_file, test_file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		named := make(map[string]ThroughputResult)
+		for _, res := range results {
+			if len(res.Error) != 0 || res.Samples != 80000 || res.SamplesPerSec <= 0 || res.Seed != 3 {
+				t.Fatalf("result=%+v", res)
+			}
+			named[res.Trainer] = res
+		}
+		return named
+	}
+
+	//大批量时每个特征每批最多更新一次，同样轮数下loss比fast高
+	large := bench(2000)
+	if large[TrainerMiniBatch].BatchSize != 2000 || large[TrainerMiniBatch].LogLoss <= large[TrainerFast].LogLoss {
+		t.Fatalf("minibatch %+v fast %+v", large[TrainerMiniBatch], large[TrainerFast])
+	}
+
+	//批较小时收敛到与fast接近的loss
+	small := bench(10)
+	if gap := small[TrainerMiniBatch].LogLoss - small[TrainerFast].LogLoss; gap > 0.005 || gap >= large[TrainerMiniBatch].LogLoss-large[TrainerFast].LogLoss {
+		t.Fatalf("small batch gap %g", gap)
+	}
+}
@@ -0,0 +1,200 @@
+package trainer
+
+import (
+	"errors"
+	"fmt"
+	"goline/solver"
+	"goline/util"
+	"math"
+	"sync"
+)
+
+const (
+	ScheduleConstant = "constant"
+	ScheduleLinear   = "linear"
+	ScheduleExp      = "exp"
+
+	DefaultBurnInAlphaStart = 0.1
+)
+
+//预热配置：File不为空时首轮前先用单独的预热数据训练一遍，否则用首轮前BurnIn比例的训练样本预热，
+//这部分样本只训练一次，计入首轮。Model不为空时从已有模型读入已有特征的N、Z作为初始参数(热启动)。
+//预热期间各线程每个样本都与参数服务器交换参数，学习率按Schedule从AlphaStart*alpha变化到alpha
+type BurnInConfig struct {
+	File       string
+	Model      string
+	Schedule   string  //constant、linear或exp
+	AlphaStart float64 //预热开始时的学习率与alpha之比
+
+	lines int //预热数据的行数
+}
+
+//schedule为空时为constant，alpha_start小于等于0时使用默认值
+func NewBurnInConfig(file string, model string, schedule string, alpha_start float64) (*BurnInConfig, error) {
+	switch schedule {
+	case "":
+		schedule = ScheduleConstant
+	case ScheduleConstant, ScheduleLinear, ScheduleExp:
+	default:
+		return nil, errors.New("[NewBurnInConfig] Unknown learning rate schedule " + schedule)
+	}
+
+	if (len(file) != 0 && !util.FileExists(file)) || (len(model) != 0 && !util.FileExists(model)) {
+		return nil, errors.New("[NewBurnInConfig] Burn-in file or model is not exist.")
+	}
+
+	if alpha_start <= 0 {
+		alpha_start = DefaultBurnInAlphaStart
+	}
+
+	return &BurnInConfig{File: file, Model: model, Schedule: schedule, AlphaStart: alpha_start}, nil
+}
+
+//预热进度为frac(0~1)时的学习率
+func (bc *BurnInConfig) alpha(alpha float64, frac float64) float64 {
+	if bc == nil {
+		return alpha
+	}
+
+	start := alpha * bc.AlphaStart
+	switch bc.Schedule {
+	case ScheduleLinear:
+		return start + (alpha-start)*frac
+	case ScheduleExp:
+		return start * math.Pow(alpha/start, frac)
+	}
+
+	return start
+}
+
+//读入热启动模型，conf为nil或未指定模型时返回nil
+func (bc *BurnInConfig) load_model() (*solver.FtrlSolver, error) {
+	if bc == nil || len(bc.Model) == 0 {
+		return nil, nil
+	}
+
+	last := &solver.FtrlSolver{}
+	err := last.Construct(bc.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	return last, nil
+}
+
+//预热配置写入模型元数据，ratio为训练数据中用于预热的比例
+func (bc *BurnInConfig) record(fs *solver.FtrlSolver, ratio float64) {
+	if util.UtilGreater(ratio, 0) && (bc == nil || len(bc.File) == 0) {
+		fs.SetMeta("BurnIn", fmt.Sprintf("%g", ratio))
+	}
+
+	if bc == nil {
+		return
+	}
+
+	fs.SetMeta("BurnInSchedule", fmt.Sprintf("%s:%g", bc.Schedule, bc.AlphaStart))
+	if len(bc.File) != 0 {
+		fs.SetMeta("BurnInFile", bc.File)
+	}
+
+	if len(bc.Model) != 0 {
+		fs.SetMeta("WarmStart", bc.Model)
+	}
+}
+
+//各线程的预热进度，每个线程只访问自己的计数
+type burn_in_schedule struct {
+	conf  *BurnInConfig
+	alpha float64
+	total []int64 //各线程预热的样本数
+	count []int64
+}
+
+func new_burn_in_schedule(conf *BurnInConfig, alpha float64, workers int, samples int) *burn_in_schedule {
+	bs := &burn_in_schedule{conf: conf, alpha: alpha}
+	bs.total = make([]int64, workers)
+	bs.count = make([]int64, workers)
+	for i := 0; i < workers; i++ {
+		bs.total[i] = int64(samples / workers)
+		if i < samples%workers {
+			bs.total[i]++
+		}
+	}
+
+	return bs
+}
+
+//线程i下一个样本的学习率，预热结束后返回alpha和false
+func (bs *burn_in_schedule) next(i int) (float64, bool) {
+	if bs.count[i] >= bs.total[i] {
+		return bs.alpha, false
+	}
+
+	frac := float64(bs.count[i]) / float64(bs.total[i])
+	bs.count[i]++
+	return bs.conf.alpha(bs.alpha, frac), true
+}
+
+//用reader中的全部样本多线程预热，update返回更新前的预估值
+func (tb *trainer_base) burn_in_pass(
+	reader SampleReader,
+	workers int,
+	line_cnt int,
+	update func(i int, x util.Pvector, y float64) float64) {
+
+	var timer util.StopWatch
+	timer.StartTimer()
+
+	var lock sync.Mutex
+	var processed int64 = 0
+	var loss float64 = 0
+	log_progress := func() {
+		if processed == 0 {
+			return
+		}
+
+		tb.log.Info(fmt.Sprintf("[%s] burn-in processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
+			tb.JobName,
+			float64(processed*100)/float64(util.MaxInt(line_cnt, 1)),
+			timer.StopTimer(),
+			loss/float64(processed)))
+	}
+
+	merge := func(local_count *int64, local_loss *float64) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		last := processed
+		processed += *local_count
+		loss += *local_loss
+		*local_count, *local_loss = 0, 0
+		if processed/TrainLogStep != last/TrainLogStep {
+			log_progress()
+		}
+	}
+
+	worker_func := func(i int, c *sync.WaitGroup) {
+		defer c.Done()
+
+		var local_count int64 = 0
+		var local_loss float64 = 0
+		for {
+			flag, y, x := reader.ReadSampleMultiThread(i)
+			if flag != nil {
+				break
+			}
+
+			pred := update(i, x, y)
+			local_loss += calc_loss(y, pred)
+			local_count++
+			if local_count >= TrainBatchSize {
+				merge(&local_count, &local_loss)
+			}
+		}
+
+		merge(&local_count, &local_loss)
+	}
+
+	util.UtilParallelRunContext(tb.context(), worker_func, workers)
+	log_progress()
+}
@@ -0,0 +1,116 @@
+package trainer
+
+import (
+	"goline/solver"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBurnInSchedule(t *testing.T) {
+	if _, err := NewBurnInConfig("", "", "cosine", 0); err == nil {
+		t.Fatal("unknown schedule accepted")
+	}
+
+	for _, schedule := range []string{ScheduleConstant, ScheduleLinear, ScheduleExp} {
+		conf, err := NewBurnInConfig("", "", schedule, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//学习率从AlphaStart*alpha开始，linear和exp逐渐接近alpha
+		if math.Abs(conf.alpha(0.1, 0)-0.001) > 1e-12 {
+			t.Fatalf("%s: start alpha %g", schedule, conf.alpha(0.1, 0))
+		}
+
+		end := map[string]float64{ScheduleConstant: 0.001, ScheduleLinear: 0.1, ScheduleExp: 0.1}[schedule]
+		if math.Abs(conf.alpha(0.1, 1)-end) > 1e-12 {
+			t.Fatalf("%s: end alpha %g", schedule, conf.alpha(0.1, 1))
+		}
+	}
+
+	//预热样本按线程分配，每个线程预热完后恢复alpha
+	conf, _ := NewBurnInConfig("", "", ScheduleLinear, 0.5)
+	bs := new_burn_in_schedule(conf, 0.2, 3, 10)
+	for i, total := range []int{4, 3, 3} {
+		for k := 0; k < total; k++ {
+			if alpha, on := bs.next(i); !on || alpha < 0.1 || alpha >= 0.2 {
+				t.Fatalf("worker %d sample %d: alpha=%g on=%v", i, k, alpha, on)
+			}
+		}
+
+		if alpha, on := bs.next(i); on || alpha != 0.2 {
+			t.Fatalf("worker %d after burn-in: alpha=%g on=%v", i, alpha, on)
+		}
+	}
+}
+
+func TestBurnIn(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, train_file, 4000, 1)
+	write_synthetic_file(t, test_file, 1000, 2)
+
+	train := func(name string, epoch int, burn_in float64, setup func(fft *FastFtrlTrainer)) *solver.FtrlSolver {
+		var fft FastFtrlTrainer
+		fft.Initialize(epoch, 2, false, burn_in, DefaultPushStep, DefaultFetchStep)
+		fft.SetJobName(name)
+		fft.SetDeterministic(3, 100)
+		if setup != nil {
+			setup(&fft)
+		}
+		return train_model(t, &fft, 0, filepath.Join(dir, name+".dat"), train_file)
+	}
+
+	burn_in := func(file string, model string, schedule string, alpha_start float64) func(fft *FastFtrlTrainer) {
+		return func(fft *FastFtrlTrainer) {
+			if err := fft.SetBurnIn(file, model, schedule, alpha_start); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	//学习率不变时用训练数据预热等同于不预热，预热的样本不会重复训练
+	plain := train("plain", 1, 0, nil)
+	same := train("same", 1, 0.3, burn_in("", "", ScheduleConstant, 1))
+	if !same_params(plain, same) || same.Meta["BurnIn"] != "0.3" {
+		t.Fatalf("burn-in with the epoch alpha differs, meta=%v", same.Meta)
+	}
+
+	slow := train("slow", 1, 0.3, burn_in("", "", ScheduleLinear, 0.1))
+	if same_params(plain, slow) || slow.Meta["BurnInSchedule"] != "linear:0.1" {
+		t.Fatalf("burn-in schedule ignored, meta=%v", slow.Meta)
+	}
+
+	//预热数据中的特征计入特征空间
+	warm_file := filepath.Join(dir, "warm.dat")
+	if err := ioutil.WriteFile(warm_file, []byte(synthetic_data(1000, 3)+"1 80:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := train("file", 1, 0, burn_in(warm_file, "", ScheduleConstant, 1))
+	second := train("file", 1, 0, burn_in(warm_file, "", ScheduleConstant, 1))
+	if first.Featnum <= 80 || first.Meta["BurnInFile"] != warm_file || same_params(first, plain) {
+		t.Fatalf("featnum=%d meta=%v", first.Featnum, first.Meta)
+	}
+
+	if !same_params(first, second) {
+		t.Fatal("deterministic burn-in file differs")
+	}
+
+	//热启动模型的参数作为初始值
+	last := filepath.Join(dir, "last.dat")
+	train_model(t, func() Trainer {
+		var fft FastFtrlTrainer
+		fft.Initialize(3, 2, false, 0, DefaultPushStep, DefaultFetchStep)
+		fft.SetDeterministic(3, 100)
+		return &fft
+	}(), 0, last, train_file)
+
+	warm := train("warm", 1, 0, burn_in("", last, ScheduleConstant, 1))
+	if warm.Meta["WarmStart"] != last || model_loss(t, warm, test_file) >= model_loss(t, plain, test_file) {
+		t.Fatalf("warm start loss %g, cold loss %g", model_loss(t, warm, test_file), model_loss(t, plain, test_file))
+	}
+}
@@ -0,0 +1,212 @@
+package trainer
+
+import (
+	"encoding/json"
+	"errors"
+	"goline/solver"
+	"goline/util"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	CheckpointSuffix = ".ckpt"
+	ProgressMetaKey  = "Progress"
+)
+
+//检查点配置，Every为0时只在每轮结束时保存
+type CheckpointConfig struct {
+	Path  string //为空时为model_file+".ckpt"
+	Every int64  //每训练多少样本保存一次
+}
+
+func (cc *CheckpointConfig) path(model_file string) string {
+	if len(cc.Path) != 0 {
+		return cc.Path
+	}
+
+	return model_file + CheckpointSuffix
+}
+
+//训练进度，保存在检查点模型的Meta["Progress"]中。各线程按固定数据分区读取时Offsets为各线程在本轮
+//已读取的样本数，续训时按线程跳过；打乱顺序时每轮的随机种子由ShuffleSeed派生，跳过相同样本数即恢复随机状态。
+//线程i本轮的dropout随机数由(DropoutSeed, Epoch, i)派生，DropoutDraws为各线程已取值的次数，续训时同样跳过
+type TrainProgress struct {
+	Epoch         int                `json:"Epoch"`
+	Processed     int64              `json:"Processed"`
+	Loss          float64            `json:"Loss"`          //本轮已训练样本的loss之和
+	AUC           *util.StreamingAUC `json:"AUC,omitempty"` //本轮已训练样本先预测后更新的AUC分桶计数
+	Offsets       []int64            `json:"Offsets"`
+	ShuffleSeed   int64              `json:"ShuffleSeed"`
+	ShuffleBuffer int                `json:"ShuffleBuffer"`
+	DropoutSeed   int64              `json:"DropoutSeed"`
+	DropoutDraws  []int64            `json:"DropoutDraws,omitempty"`
+}
+
+//读取模型中的训练进度，模型不是检查点时返回nil
+func load_progress(fs *solver.FtrlSolver) (*TrainProgress, error) {
+	buf, ok := fs.Meta[ProgressMetaKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var progress TrainProgress
+	err := json.Unmarshal([]byte(buf), &progress)
+	if err != nil {
+		return nil, errors.New("[load_progress] Parse training progress error." + err.Error())
+	}
+
+	return &progress, nil
+}
+
+//写入带训练进度的检查点，先写临时文件再改名
+func save_checkpoint(fs *solver.FtrlSolver, progress *TrainProgress, path string) error {
+	buf, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	fs.SetMeta(ProgressMetaKey, string(buf))
+	defer delete(fs.Meta, ProgressMetaKey)
+
+	return save_model_atomic(fs.SaveModel, path)
+}
+
+//最终模型写完后检查点不再需要
+func remove_checkpoint(conf *CheckpointConfig, model_file string) {
+	if conf != nil {
+		os.Remove(conf.path(model_file))
+	}
+}
+
+//记录各线程读取样本数的reader
+type counted_reader struct {
+	SampleReader
+	counts []int64
+}
+
+func new_counted_reader(reader SampleReader, threadnum int) *counted_reader {
+	return &counted_reader{SampleReader: reader, counts: make([]int64, threadnum)}
+}
+
+func (cr *counted_reader) ReadSample(i int) (error, float64, util.Pvector) {
+	err, y, x := cr.SampleReader.ReadSample(i)
+	if err == nil && i < len(cr.counts) {
+		cr.counts[i]++
+	}
+
+	return err, y, x
+}
+
+func (cr *counted_reader) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	err, y, x := cr.SampleReader.ReadSampleMultiThread(i)
+	if err == nil && i < len(cr.counts) {
+		cr.counts[i]++
+	}
+
+	return err, y, x
+}
+
+//文本文件由各线程共享读取，只能按总数跳过
+func shared_reader(reader SampleReader) bool {
+	switch reader.(type) {
+	case *TextFileParser, *stream_reader:
+		return true
+	}
+
+	return false
+}
+
+//续训时跳过本轮已训练的样本，线程数变化时数据分区不同，无法定位，返回false从头训练本轮
+func (cr *counted_reader) skip(progress *TrainProgress) bool {
+	skip := make([]int64, len(cr.counts))
+	if shared_reader(cr.SampleReader) {
+		for _, n := range progress.Offsets {
+			skip[0] += n
+		}
+	} else if len(progress.Offsets) == len(cr.counts) {
+		copy(skip, progress.Offsets)
+	} else {
+		return false
+	}
+
+	for i := 0; i < len(skip); i++ {
+		for cr.counts[i] < skip[i] {
+			if err, _, _ := cr.ReadSampleMultiThread(i); err != nil {
+				break
+			}
+		}
+	}
+
+	return true
+}
+
+//检查点屏障：请求后各线程到达暂停点等待，全部活动线程都暂停后执行action，再一起继续
+type pause_barrier struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	flag   int32
+	active int
+	paused int
+	gen    int
+	action func()
+}
+
+func new_pause_barrier(active int, action func()) *pause_barrier {
+	pb := &pause_barrier{active: active, action: action}
+	pb.cond = sync.NewCond(&pb.lock)
+	return pb
+}
+
+func (pb *pause_barrier) request() {
+	atomic.StoreInt32(&pb.flag, 1)
+}
+
+func (pb *pause_barrier) requested() bool {
+	return atomic.LoadInt32(&pb.flag) == 1
+}
+
+//调用时持有lock
+func (pb *pause_barrier) fire() {
+	if pb.requested() {
+		pb.action()
+	}
+
+	pb.paused = 0
+	atomic.StoreInt32(&pb.flag, 0)
+	pb.gen++
+	pb.cond.Broadcast()
+}
+
+//线程到达暂停点，检查请求与加锁之间action可能已由其它线程执行
+func (pb *pause_barrier) wait() {
+	pb.lock.Lock()
+	defer pb.lock.Unlock()
+
+	if !pb.requested() {
+		return
+	}
+
+	pb.paused++
+	if pb.paused >= pb.active {
+		pb.fire()
+		return
+	}
+
+	gen := pb.gen
+	for gen == pb.gen {
+		pb.cond.Wait()
+	}
+}
+
+//线程读完数据退出，其余线程都在等待时由退出的线程执行action
+func (pb *pause_barrier) leave() {
+	pb.lock.Lock()
+	defer pb.lock.Unlock()
+
+	pb.active--
+	if pb.requested() && pb.active > 0 && pb.paused >= pb.active {
+		pb.fire()
+	}
+}
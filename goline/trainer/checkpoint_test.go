@@ -0,0 +1,133 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"goline/solver"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func train_model(t *testing.T, tr Trainer, dropout float64, model_file string, train_file string) *solver.FtrlSolver {
+	if err := tr.Train(0.1, 1, 0, 1, dropout, model_file, train_file, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var model solver.FtrlSolver
+	if err := model.Construct(model_file); err != nil {
+		t.Fatal(err)
+	}
+
+	return &model
+}
+
+func same_params(a *solver.FtrlSolver, b *solver.FtrlSolver) bool {
+	return reflect.DeepEqual(a.N, b.N) && reflect.DeepEqual(a.Z, b.Z)
+}
+
+func TestPartialProgressError(t *testing.T) {
+	pe := &PartialProgressError{JobName: "job", Epoch: 1, Processed: 10, Checkpoint: "m.ckpt", Err: context.Canceled}
+	if msg := pe.Error(); !strings.HasSuffix(msg, "Checkpoint saved to m.ckpt. "+context.Canceled.Error()) {
+		t.Fatalf("message %q", msg)
+	}
+
+	if !errors.Is(pe, context.Canceled) || !IsCanceled(pe) {
+		t.Fatal("cancel error not unwrapped")
+	}
+
+	pe.Err = nil
+	if msg := pe.Error(); !strings.HasSuffix(msg, "m.ckpt.") {
+		t.Fatalf("message %q", msg)
+	}
+}
+
+//单线程训练在轮次中途取消，从检查点继续后与不中断的训练得到相同的模型，dropout随机数的位置也被恢复
+func TestCheckpointResumeMidEpoch(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	write_synthetic_file(t, train_file, 50000, 1)
+
+	var full FtrlTrainer
+	full.Initialize(2, false)
+	full.SetSeed(5)
+	expect := train_model(t, &full, 0.3, filepath.Join(dir, "full.dat"), train_file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ft FtrlTrainer
+	ft.Initialize(2, false)
+	ft.SetSeed(5)
+	ft.SetContext(ctx)
+	ft.SetCheckpoint("", 0)
+	ft.AddCallback(Callback{OnBatch: func(state *TrainState) error {
+		if state.Epoch == 1 {
+			cancel()
+		}
+		return nil
+	}})
+
+	model_file := filepath.Join(dir, "model.dat")
+	err := ft.Train(0.1, 1, 0, 1, 0.3, model_file, train_file, "")
+	var pe *PartialProgressError
+	if !errors.As(err, &pe) || pe.Epoch != 1 || pe.Processed >= 50000 || len(pe.Checkpoint) == 0 {
+		t.Fatalf("interrupted with %v", err)
+	}
+
+	//种子从检查点中读出
+	var resumed FtrlTrainer
+	resumed.Initialize(2, false)
+	if err := resumed.TrainRestore(pe.Checkpoint, model_file, train_file, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.Seed != 5 || !same_params(&resumed.Solver, expect) {
+		t.Fatalf("resumed model differs, seed=%d", resumed.Seed)
+	}
+}
+
+//确定性训练在轮次结束时取消，从检查点继续后与不中断的训练逐位相同
+func TestCheckpointResumeDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	write_synthetic_file(t, train_file, 5000, 1)
+
+	new_trainer := func() *FastFtrlTrainer {
+		var fft FastFtrlTrainer
+		fft.Initialize(3, 2, false, 0, DefaultPushStep, DefaultFetchStep)
+		fft.SetJobName("det")
+		fft.SetDeterministic(3, 100)
+		return &fft
+	}
+
+	expect := train_model(t, new_trainer(), 0.3, filepath.Join(dir, "full.dat"), train_file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fft := new_trainer()
+	fft.SetContext(ctx)
+	fft.SetCheckpoint("", 0)
+	fft.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+		cancel()
+		return nil
+	}})
+
+	model_file := filepath.Join(dir, "model.dat")
+	err := fft.Train(0.1, 1, 0, 1, 0.3, model_file, train_file, "")
+	var pe *PartialProgressError
+	if !errors.As(err, &pe) || pe.Epoch != 1 {
+		t.Fatalf("interrupted with %v", err)
+	}
+
+	resumed := new_trainer()
+	if err := resumed.TrainRestore(pe.Checkpoint, model_file, train_file, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if !same_params(resumed.Model(), expect) {
+		t.Fatal("resumed model differs")
+	}
+}
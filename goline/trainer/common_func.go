@@ -1,16 +1,16 @@
 package trainer
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"goline/solver"
 	"goline/util"
 	"io"
 	"math"
-	"os"
-	"strconv"
-	s "strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 func calc_loss(y float64, pred float64) float64 {
@@ -28,154 +28,207 @@ func calc_loss(y float64, pred float64) float64 {
 	return loss
 }
 
-func read_problem_info(
-	train_file string,
-	read_cache bool,
-	num_threads int) (int, int, error) {
+//在模型元数据中记录训练样本的抽样比例，预估时可据此校准
+func record_sample_rates(rates *util.SampleRates, fs *solver.FtrlSolver) {
+	if rates != nil {
+		fs.SetMeta("SampleRates", rates.String())
+	}
+}
 
-	feat_num := 0
-	line_cnt := 0
+func thread_num(num_threads int) int {
+	if num_threads == 0 {
+		return runtime.NumCPU()
+	}
 
-	log := util.GetLogger()
+	return num_threads
+}
+
+//启用缓存时首次读取即生成二进制样本缓存，之后的迭代和评估直接读缓存，mmap为true时通过内存映射读取
+func read_mode(cache bool, mmap bool) int {
+	mode := CacheNone
+	if cache {
+		mode |= CacheBuild
+	}
+
+	if mmap {
+		mode |= ReadMmap
+	}
+
+	return mode
+}
+
+//多线程扫描样本文件，线程i对读到的每个样本调用on_sample(i, y, x)，writer不为nil时同时生成样本缓存，
+//ctx取消时停止扫描并返回ctx.Err()
+func scan_file(
+	ctx context.Context,
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	writer *CacheWriter,
+	on_sample func(i int, y float64, x util.Pvector)) error {
 
-	var lock sync.Mutex
 	var parser FileParser
-	var errall error
+	parser.Dict = dict
+	err := parser.OpenFile(path)
+	if err != nil {
+		return err
+	}
 
-	read_from_cache := func(path string) error {
-		fs, err := os.Open(path)
-		defer fs.Close()
-		if err != nil {
-			return err
-		}
+	var stop int32 = 0
+	unwatch := util.WatchContext(ctx, &stop)
+	defer unwatch()
 
-		bfRd := bufio.NewReader(fs)
-		line, err := bfRd.ReadString('\n')
-		if err != nil {
-			return err
-		}
+	var read_err error
+	var lock sync.Mutex
+	scan_worker := func(i int, c *sync.WaitGroup) {
+		for atomic.LoadInt32(&stop) == 0 {
+			flag, seq, local_y, local_x := parser.ReadSampleWithSeq()
+			if seq < 0 {
+				//读文件出错时停止扫描并返回错误，不当作文件结束
+				if flag != nil && flag != io.EOF {
+					lock.Lock()
+					if read_err == nil {
+						read_err = errors.New("[scan_file] Read file error." + flag.Error())
+					}
+					lock.Unlock()
+					atomic.StoreInt32(&stop, 1)
+				}
+				break
+			}
 
-		var res []string = s.Split(line, " ")
-		if len(res) != 2 {
-			log.Error("[read_problem_info] File format error.")
-			return errors.New("[read_problem_info] File format error.")
-		}
+			if writer != nil {
+				writer.WriteSample(seq, local_y, local_x)
+			}
 
-		feat_num, errall = strconv.Atoi(res[0])
-		if errall != nil {
-			log.Error("[read_problem_info] Label format error." + errall.Error())
-			return errors.New("[read_problem_info] Label format error." + errall.Error())
-		}
-		line_cnt, errall = strconv.Atoi(res[1])
-		if errall != nil {
-			log.Error("[read_problem_info] Feature format error." + errall.Error())
-			return errors.New("[read_problem_info] Feature format error." + errall.Error())
+			if flag != nil {
+				continue
+			}
+
+			on_sample(i, local_y, local_x)
 		}
 
-		return nil
+		defer c.Done()
 	}
 
-	exist := func(filename string) bool {
-		var exist = true
-		if _, err := os.Stat(filename); os.IsNotExist(err) {
-			exist = false
-		}
-		return exist
+	err = util.UtilParallelRunContext(ctx, scan_worker, thread_num(num_threads))
+	parser.CloseFile()
+	if err == nil {
+		err = read_err
 	}
 
-	write_to_cache := func(filename string) error {
-		var f *os.File
-		var err1 error
-		if exist(filename) {
-			f, err1 = os.OpenFile(filename, os.O_WRONLY, 0666)
-		} else {
-			f, err1 = os.Create(filename)
-		}
+	return err
+}
 
-		if err1 != nil {
-			return err1
-		}
+//统计特征数和样本数，read_cache为true时读取二进制样本缓存，缓存无效时在扫描的同时生成缓存
+func read_problem_info(
+	ctx context.Context,
+	train_file string,
+	read_cache bool,
+	num_threads int,
+	dict *util.FeatureDict) (int, int, error) {
+
+	feat_num := 0
+	line_cnt := 0
 
-		defer f.Close()
+	log := util.GetLogger()
 
-		wireteString := string(feat_num) + " " + string(line_cnt) + "\n"
-		_, err1 = io.WriteString(f, wireteString)
-		if err1 != nil {
-			return err1
+	//使用特征字典时新特征名在扫描过程中分配下标，只有字典一致时才能读缓存
+	if read_cache {
+		cache, err := OpenSampleCache(cache_path(train_file), train_file, dict)
+		if err == nil {
+			log.Info(fmt.Sprintf("[read_problem_info] Read cache, instances=[%d] features=[%d]\n", cache.LineCnt, cache.FeatNum))
+			return cache.FeatNum, cache.LineCnt, nil
 		}
-		return nil
 	}
 
-	read_problem_worker := func(i int, c *sync.WaitGroup) {
-		local_max_feat := 0
-		local_count := 0
-		for {
-			flag, _, local_x := parser.ReadSampleMultiThread()
-			if flag != nil {
-				break
-			}
+	if dict != nil {
+		dict.Grow = true
+		defer func() { dict.Grow = false }()
+	}
 
-			for i := 0; i < len(local_x); i++ {
-				if local_x[i].Index+1 > local_max_feat {
-					local_max_feat = local_x[i].Index + 1
-				}
-			}
-			local_count++
+	var writer *CacheWriter
+	if read_cache {
+		var err error
+		writer, err = CreateSampleCache(cache_path(train_file), train_file)
+		if err != nil {
+			log.Warn("[read_problem_info] Create sample cache failed." + err.Error())
+			writer = nil
 		}
+	}
 
-		lock.Lock()
-		line_cnt += local_count
-		lock.Unlock()
-		if local_max_feat > feat_num {
-			feat_num = local_max_feat
+	num_threads = thread_num(num_threads)
+	local_max_feat := make([]int, num_threads)
+	local_count := make([]int, num_threads)
+	read_problem_worker := func(i int, y float64, local_x util.Pvector) {
+		for k := 0; k < len(local_x); k++ {
+			if local_x[k].Index+1 > local_max_feat[i] {
+				local_max_feat[i] = local_x[k].Index + 1
+			}
 		}
-
-		defer c.Done()
+		local_count[i]++
 	}
 
-	cache_file := string(train_file) + ".cache"
-	cache_exists := exist(cache_file)
-	if read_cache && cache_exists {
-		read_from_cache(cache_file)
-	} else {
-		parser.OpenFile(train_file)
-		util.UtilParallelRun(read_problem_worker, num_threads)
-		parser.CloseFile()
+	err := scan_file(ctx, train_file, num_threads, dict, writer, read_problem_worker)
+	for i := 0; i < num_threads; i++ {
+		line_cnt += local_count[i]
+		feat_num = util.MaxInt(feat_num, local_max_feat[i])
 	}
 
 	log.Info(fmt.Sprintf("[read_problem_info] Instances=[%d] features=[%d]\n", line_cnt, feat_num))
+	if dict != nil {
+		log.Info(fmt.Sprintf("[read_problem_info] Named features=[%d]\n", dict.Len()))
+	}
 
-	if read_cache && !cache_exists {
-		write_to_cache(cache_file)
+	//扫描失败或被取消时缓存不完整，丢弃后下次重新扫描
+	if writer != nil && err != nil {
+		writer.Abort()
+	} else if writer != nil {
+		//字典在扫描结束后才确定，签名在此时写入
+		if _, err := writer.Close(feat_num, dict); err != nil {
+			log.Warn("[read_problem_info] Write sample cache failed." + err.Error())
+		}
 	}
 
-	return feat_num, line_cnt, nil
+	return feat_num, line_cnt, err
 }
 
-func evaluate_file(path string, func_predict func(x util.Pvector) float64, num_threads int) float64 {
-	var parser FileParser
-	parser.OpenFile(path)
+//测试集评估结果，AUC在只有一类样本时为0
+type EvalResult struct {
+	Count int
+	Loss  float64
+	AUC   float64
+}
 
-	count := 0
-	var loss float64 = 0
+//多线程读取parser中的样本计算平均loss和AUC，读完后关闭parser
+func evaluate_reader(
+	parser SampleReader,
+	func_predict func(x util.Pvector) float64,
+	num_threads int) EvalResult {
+
+	var res EvalResult
+	var scores util.Dvector
 	var lock sync.Mutex
 	var predict_worker = func(i int, c *sync.WaitGroup) {
 
 		local_count := 0
 		var local_loss float64 = 0
+		var local_scores util.Dvector
 		for {
-			res, local_y, local_x := parser.ReadSampleMultiThread()
-			if res != nil {
+			flag, local_y, local_x := parser.ReadSampleMultiThread(i)
+			if flag != nil {
 				break
 			}
 
-			local_loss += calc_loss(local_y, func_predict(local_x))
+			pred := func_predict(local_x)
+			local_loss += calc_loss(local_y, pred)
+			local_scores = append(local_scores, util.DPair{First: pred, Second: local_y})
 			local_count++
 		}
 
 		lock.Lock()
-		count += local_count
-		loss += local_loss
+		res.Count += local_count
+		res.Loss += local_loss
+		scores = append(scores, local_scores...)
 		lock.Unlock()
 
 		defer c.Done()
@@ -183,49 +236,99 @@ func evaluate_file(path string, func_predict func(x util.Pvector) float64, num_t
 
 	util.UtilParallelRun(predict_worker, num_threads)
 
-	parser.CloseFile()
-	if count > 0 {
-		loss = loss / float64(count)
+	parser.CloseFile(num_threads)
+	if res.Count > 0 {
+		res.Loss = res.Loss / float64(res.Count)
 	}
+	res.AUC = util.AUC(scores)
 
-	return loss
+	return res
+}
+
+func evaluate_file(
+	ctx context.Context,
+	path string,
+	func_predict func(x util.Pvector) float64,
+	num_threads int,
+	dict *util.FeatureDict,
+	read_mode int) EvalResult {
+	num_threads = thread_num(num_threads)
+	parser, err := OpenSampleReader(path, num_threads, dict, read_mode)
+	if err != nil {
+		util.GetLogger().Error("[evaluate_file] Open file failed." + err.Error())
+		return EvalResult{}
+	}
+
+	return evaluate_reader(with_context(ctx, parser), func_predict, num_threads)
 }
 
-func evaluate_stream(stream []string, func_predict func(x util.Pvector) float64, num_threads int) float64 {
-	var parser StreamParser
+func evaluate_stream(
+	ctx context.Context,
+	stream []string,
+	func_predict func(x util.Pvector) float64,
+	num_threads int,
+	dict *util.FeatureDict) EvalResult {
+	var parser stream_reader
+	parser.Dict = dict
 	parser.Open(stream)
 
-	count := 0
-	var loss float64 = 0
-	var lock sync.Mutex
-	var predict_worker = func(i int, c *sync.WaitGroup) {
+	return evaluate_reader(with_context(ctx, &parser), func_predict, thread_num(num_threads))
+}
 
-		local_count := 0
-		var local_loss float64 = 0
-		for {
-			res, local_y, local_x := parser.ReadSampleMultiThread()
-			if res != nil {
-				break
-			}
+//根据交叉配置扩展特征空间，conf为nil时不做特征交叉
+func build_feature_cross(conf *util.CrossConfig, feat_num int) (*util.FeatureCross, int, error) {
+	if conf == nil {
+		return nil, feat_num, nil
+	}
 
-			local_loss += calc_loss(local_y, func_predict(local_x))
-			local_count++
-		}
+	var cross util.FeatureCross
+	feat_num, err := cross.Initialize(*conf, feat_num)
+	if err != nil {
+		return nil, feat_num, err
+	}
 
-		lock.Lock()
-		count += local_count
-		loss += local_loss
-		lock.Unlock()
+	util.GetLogger().Info(fmt.Sprintf("[build_feature_cross] Cross pairs=[%d] offset=[%d] features=[%d]\n",
+		len(cross.Crosses), cross.Offset, feat_num))
 
-		defer c.Done()
+	return &cross, feat_num, nil
+}
+
+//扫描训练数据拟合数值特征预处理参数，conf为nil时不做预处理
+func build_feature_preprocess(
+	ctx context.Context,
+	conf *util.PreprocessConfig,
+	train_file string,
+	feat_num int,
+	num_threads int,
+	dict *util.FeatureDict) (*util.FeaturePreprocess, int, error) {
+
+	if conf == nil {
+		return nil, feat_num, nil
 	}
 
-	util.UtilParallelRun(predict_worker, num_threads)
+	var preprocess util.FeaturePreprocess
+	new_feat_num, err := preprocess.Initialize(*conf, feat_num)
+	if err != nil {
+		return nil, feat_num, err
+	}
 
-	parser.Close()
-	if count > 0 {
-		loss = loss / float64(count)
+	num_threads = thread_num(num_threads)
+	fitters := make([]*util.PreprocessFitter, num_threads)
+	for i := 0; i < num_threads; i++ {
+		fitters[i] = preprocess.NewFitter(int64(i))
 	}
 
-	return loss
+	err = scan_file(ctx, train_file, num_threads, dict, nil, func(i int, y float64, local_x util.Pvector) {
+		fitters[i].Add(local_x)
+	})
+	if err != nil {
+		return nil, feat_num, err
+	}
+
+	preprocess.Fit(fitters)
+
+	util.GetLogger().Info(fmt.Sprintf("[build_feature_preprocess] Rules=[%d] fitted features=[%d] features=[%d]\n",
+		len(preprocess.Rules), len(preprocess.Stats), new_feat_num))
+
+	return &preprocess, new_feat_num, nil
 }
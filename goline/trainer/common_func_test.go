@@ -0,0 +1,49 @@
+package trainer
+
+import (
+	"context"
+	"fmt"
+	"goline/util"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//扫描时新特征名按行的顺序分配下标，与扫描线程数无关
+func TestReadProblemInfoDictOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "named.dat")
+	var text strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&text, "%d f%d:1 u^%d:0.5 %d:1\n", i%2, i, i%300, i%7)
+	}
+	if err := ioutil.WriteFile(path, []byte(text.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var dicts []*util.FeatureDict
+	for _, threads := range []int{1, 8, 8} {
+		dict := util.NewFeatureDict()
+		feat_num, lines, err := read_problem_info(context.Background(), path, false, threads, dict)
+		if err != nil || lines != 20000 || feat_num != dict.Size() {
+			t.Fatalf("threads=%d features=%d lines=%d dict=%d err=%v", threads, feat_num, lines, dict.Size(), err)
+		}
+		dicts = append(dicts, dict)
+	}
+
+	for _, dict := range dicts[1:] {
+		if !reflect.DeepEqual(dict.Entries, dicts[0].Entries) {
+			t.Fatal("dictionary depends on scan threads")
+		}
+	}
+
+	//数字形式的特征名在第一行之后才出现时也由字典分配下标
+	if idx, _ := dicts[0].Lookup("f0"); idx != 1 {
+		t.Fatalf("f0 at %d", idx)
+	}
+
+	if idx, ok := dicts[0].Lookup("3"); !ok || idx <= 3 {
+		t.Fatalf("numeric name 3 at %d", idx)
+	}
+}
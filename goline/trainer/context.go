@@ -0,0 +1,110 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goline/util"
+	"sync/atomic"
+)
+
+//训练被取消或超时时返回，记录已完成的进度。训练中断时不写出model_file，
+//设置了检查点时在中断处保存检查点，可用TrainRestore继续
+type PartialProgressError struct {
+	JobName    string
+	Epoch      int
+	Processed  int64  //中断所在轮次已训练的样本数
+	Checkpoint string //保存的检查点路径，没有时为空
+	Err        error  //ctx.Err()
+}
+
+func (pe *PartialProgressError) Error() string {
+	msg := fmt.Sprintf("[%s] Training interrupted at epoch %d after %d samples.", pe.JobName, pe.Epoch, pe.Processed)
+	if len(pe.Checkpoint) != 0 {
+		msg += " Checkpoint saved to " + pe.Checkpoint + "."
+	}
+
+	if pe.Err != nil {
+		msg += " " + pe.Err.Error()
+	}
+
+	return msg
+}
+
+func (pe *PartialProgressError) Unwrap() error {
+	return pe.Err
+}
+
+//err是否为训练取消或超时
+func IsCanceled(err error) bool {
+	var pe *PartialProgressError
+	return errors.As(err, &pe)
+}
+
+func (tb *trainer_base) context() context.Context {
+	if tb.ctx == nil {
+		return context.Background()
+	}
+
+	return tb.ctx
+}
+
+//ctx已取消时返回PartialProgressError
+func (tb *trainer_base) check_canceled(epoch int, processed int64) error {
+	err := tb.context().Err()
+	if err == nil {
+		return nil
+	}
+
+	return &PartialProgressError{JobName: tb.JobName, Epoch: epoch, Processed: processed, Err: err}
+}
+
+//stop置位后读取返回ctx.Err()，CloseFile时结束监听
+type context_reader struct {
+	SampleReader
+	ctx     context.Context
+	stop    *int32
+	unwatch func()
+}
+
+func with_context(ctx context.Context, reader SampleReader) SampleReader {
+	if ctx.Done() == nil {
+		return reader
+	}
+
+	cr := &context_reader{SampleReader: reader, ctx: ctx, stop: new(int32)}
+	cr.unwatch = util.WatchContext(ctx, cr.stop)
+	return cr
+}
+
+func (cr *context_reader) err() error {
+	if err := cr.ctx.Err(); err != nil {
+		return err
+	}
+
+	return ErrStopTraining
+}
+
+func (cr *context_reader) ReadSample(i int) (error, float64, util.Pvector) {
+	if atomic.LoadInt32(cr.stop) != 0 {
+		return cr.err(), 0, nil
+	}
+
+	return cr.SampleReader.ReadSample(i)
+}
+
+func (cr *context_reader) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	if atomic.LoadInt32(cr.stop) != 0 {
+		return cr.err(), 0, nil
+	}
+
+	return cr.SampleReader.ReadSampleMultiThread(i)
+}
+
+func (cr *context_reader) CloseFile(threadnum int) bool {
+	if cr.unwatch != nil {
+		cr.unwatch()
+	}
+
+	return cr.SampleReader.CloseFile(threadnum)
+}
@@ -0,0 +1,256 @@
+package trainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goline/util"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const CVReportFile = "cv_report.json"
+
+//k折交叉验证配置，Trainer为训练器类型，训练参数对每折和最终模型相同。
+//训练数据按行hash分为Folds折，Key不为空时按该字段(如用户id)的hash划分，同一用户的样本落在同一折
+type CVConfig struct {
+	Folds   int
+	Key     string
+	Trainer string
+	TrainerConfig
+
+	Alpha   float64
+	Beta    float64
+	L1      float64
+	L2      float64
+	Dropout float64
+
+	Final      bool //交叉验证后用全部数据训练最终模型
+	KeepModels bool //保留各折的模型，默认训练评估后删除
+
+	Preprocess  *util.PreprocessConfig
+	Cross       *util.CrossConfig
+	SampleRates *util.SampleRates
+}
+
+//一折的结果，指标为该折模型在留出数据上的评估
+type CVFold struct {
+	Fold       int     `json:"Fold"`
+	TrainLines int64   `json:"TrainLines"`
+	TestLines  int64   `json:"TestLines"`
+	LogLoss    float64 `json:"LogLoss"`
+	AUC        float64 `json:"AUC"`
+	Time       float64 `json:"Time"`
+	Model      string  `json:"Model,omitempty"`
+}
+
+//交叉验证结果，Std为各折指标的标准差
+type CVReport struct {
+	Trainer     string   `json:"Trainer"`
+	Folds       []CVFold `json:"Folds"`
+	MeanLogLoss float64  `json:"MeanLogLoss"`
+	StdLogLoss  float64  `json:"StdLogLoss"`
+	MeanAUC     float64  `json:"MeanAUC"`
+	StdAUC      float64  `json:"StdAUC"`
+	FinalModel  string   `json:"FinalModel,omitempty"`
+}
+
+//训练器都内嵌trainer_base，特征处理的设置不在Trainer接口中
+type feature_setter interface {
+	SetPreprocess(conf *util.PreprocessConfig)
+	SetFeatureCross(conf *util.CrossConfig)
+	SetSampleRates(rates *util.SampleRates)
+}
+
+func (cc *CVConfig) check() error {
+	if cc.Folds < 2 {
+		return errors.New(fmt.Sprintf("[CrossValidate] The number of folds must be at least 2, got %d.", cc.Folds))
+	}
+
+	if len(cc.Trainer) == 0 {
+		cc.Trainer = TrainerFast
+	}
+
+	if cc.Epoch <= 0 {
+		cc.Epoch = 1
+	}
+
+	return nil
+}
+
+func (cc *CVConfig) new_trainer(ctx context.Context, job_name string) (Trainer, error) {
+	conf := cc.TrainerConfig
+	conf.JobName = job_name
+	tr, err := NewTrainer(cc.Trainer, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.SetContext(ctx)
+	fs := tr.(feature_setter)
+	fs.SetPreprocess(cc.Preprocess)
+	fs.SetFeatureCross(cc.Cross)
+	fs.SetSampleRates(cc.SampleRates)
+	return tr, nil
+}
+
+//训练第i折并在留出数据上评估，划分出的数据文件用完即删除
+func (cc *CVConfig) train_fold(ctx context.Context, i int, train_file string, work_dir string) (CVFold, error) {
+	fold := CVFold{Fold: i, Model: filepath.Join(work_dir, fmt.Sprintf("fold_%02d.dat", i))}
+	fold_train := filepath.Join(work_dir, fmt.Sprintf("fold_%02d.train", i))
+	fold_test := filepath.Join(work_dir, fmt.Sprintf("fold_%02d.test", i))
+	defer func() {
+		for _, path := range []string{fold_train, fold_test} {
+			os.Remove(path)
+			os.Remove(cache_path(path))
+		}
+	}()
+
+	summary, err := util.FoldSplit(train_file, fold_train, fold_test, cc.Folds, i, cc.Key)
+	if err != nil {
+		return fold, err
+	}
+
+	fold.TrainLines = summary.TrainLines
+	fold.TestLines = summary.TestLines
+	if summary.TrainLines == 0 || summary.TestLines == 0 {
+		return fold, errors.New(fmt.Sprintf("[CrossValidate] Fold %d is empty.", i))
+	}
+
+	tr, err := cc.new_trainer(ctx, fmt.Sprintf("%s fold %d", cc.JobName, i))
+	if err != nil {
+		return fold, err
+	}
+
+	var timer util.StopWatch
+	timer.StartTimer()
+
+	//训练时不评估留出数据，训练结束后只评估一次
+	err = tr.Train(cc.Alpha, cc.Beta, cc.L1, cc.L2, cc.Dropout, fold.Model, fold_train, "")
+	fold.Time = timer.StopTimer()
+	if err != nil {
+		return fold, err
+	}
+
+	model := tr.Model()
+	res := evaluate_file(ctx, fold_test, model.Predict, cc.NumThreads, model.Dict, read_mode(cc.CacheFeatureNum, false))
+	fold.LogLoss = res.Loss
+	fold.AUC = res.AUC
+	if !cc.KeepModels {
+		os.Remove(fold.Model)
+		fold.Model = ""
+	}
+
+	return fold, ctx.Err()
+}
+
+func mean_std(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum float64 = 0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64 = 0
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+//k折交叉验证，依次训练Folds个模型，报告各折留出数据上log loss和AUC的均值和标准差。
+//各折的临时数据和模型写入work_dir，报告写入work_dir/cv_report.json；
+//conf.Final为true时再用全部训练数据训练模型写入model_file
+func CrossValidate(
+	ctx context.Context,
+	conf CVConfig,
+	train_file string,
+	work_dir string,
+	model_file string) (*CVReport, error) {
+
+	log := util.GetLogger()
+	if err := conf.check(); err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	if !util.FileExists(train_file) {
+		log.Error("[CrossValidate] Train file is not exist.")
+		return nil, errors.New("[CrossValidate] Train file is not exist.")
+	}
+
+	if conf.Final && len(model_file) == 0 {
+		log.Error("[CrossValidate] Model file is needed for the final model.")
+		return nil, errors.New("[CrossValidate] Model file is needed for the final model.")
+	}
+
+	if err := util.Mkdir(work_dir); err != nil {
+		log.Error("[CrossValidate] Make work directory error." + err.Error())
+		return nil, errors.New("[CrossValidate] Make work directory error." + err.Error())
+	}
+
+	log.Info(fmt.Sprintf("[%s] %d-fold cross validation, trainer=%s alpha=%g beta=%g l1=%g l2=%g dropout=%g epoch=%d\n",
+		conf.JobName, conf.Folds, conf.Trainer, conf.Alpha, conf.Beta, conf.L1, conf.L2, conf.Dropout, conf.Epoch))
+
+	report := &CVReport{Trainer: conf.Trainer}
+	var losses, aucs []float64
+	for i := 0; i < conf.Folds; i++ {
+		fold, err := conf.train_fold(ctx, i, train_file, work_dir)
+		if err != nil {
+			if IsCanceled(err) || ctx.Err() != nil {
+				log.Warn(fmt.Sprintf("[%s] Cross validation interrupted at fold %d.", conf.JobName, i))
+				return report, &PartialProgressError{JobName: conf.JobName, Err: ctx.Err()}
+			}
+
+			log.Error(fmt.Sprintf("[CrossValidate] Fold %d failed.%s", i, err.Error()))
+			return report, errors.New(fmt.Sprintf("[CrossValidate] Fold %d failed.%s", i, err.Error()))
+		}
+
+		log.Info(fmt.Sprintf("[%s] fold %d train=%d test=%d logloss=[%f] auc=[%f]\n",
+			conf.JobName, i, fold.TrainLines, fold.TestLines, fold.LogLoss, fold.AUC))
+
+		report.Folds = append(report.Folds, fold)
+		losses = append(losses, fold.LogLoss)
+		aucs = append(aucs, fold.AUC)
+	}
+
+	report.MeanLogLoss, report.StdLogLoss = mean_std(losses)
+	report.MeanAUC, report.StdAUC = mean_std(aucs)
+	log.Info(fmt.Sprintf("[%s] cross validation logloss=[%f +- %f] auc=[%f +- %f]\n",
+		conf.JobName, report.MeanLogLoss, report.StdLogLoss, report.MeanAUC, report.StdAUC))
+
+	if conf.Final {
+		tr, err := conf.new_trainer(ctx, conf.JobName+" final")
+		if err == nil {
+			err = tr.Train(conf.Alpha, conf.Beta, conf.L1, conf.L2, conf.Dropout, model_file, train_file, "")
+		}
+
+		if err != nil {
+			log.Error("[CrossValidate] Train final model failed." + err.Error())
+			if IsCanceled(err) {
+				return report, err
+			}
+			return report, errors.New("[CrossValidate] Train final model failed." + err.Error())
+		}
+
+		report.FinalModel = model_file
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(work_dir, CVReportFile), b, 0644)
+	}
+	if err != nil {
+		log.Warn("[CrossValidate] Write report error." + err.Error())
+	}
+
+	return report, nil
+}
@@ -0,0 +1,55 @@
+package trainer
+
+import (
+	"context"
+	"goline/solver"
+	"goline/util"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestCrossValidate(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	model_file := filepath.Join(dir, "final.dat")
+	write_synthetic_file(t, train_file, 3000, 1)
+
+	conf := CVConfig{Folds: 3, Trainer: TrainerFtrl, Alpha: 0.1, Beta: 1, L2: 1, Final: true, KeepModels: true}
+	conf.Epoch = 2
+	report, err := CrossValidate(context.Background(), conf, train_file, filepath.Join(dir, "cv"), model_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Folds) != 3 || report.FinalModel != model_file || report.MeanLogLoss > 0.6 {
+		t.Fatalf("report=%+v", report)
+	}
+
+	var lines int64
+	for _, fold := range report.Folds {
+		lines += fold.TestLines
+		if fold.TrainLines+fold.TestLines != 3000 {
+			t.Fatalf("fold %d train=%d test=%d", fold.Fold, fold.TrainLines, fold.TestLines)
+		}
+
+		//报告的指标是训练完成后的模型在留出数据上的评估
+		fold_test := filepath.Join(dir, "fold.test")
+		if _, err := util.FoldSplit(train_file, filepath.Join(dir, "fold.train"), fold_test, 3, fold.Fold, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var model solver.FtrlSolver
+		if err := model.Construct(fold.Model); err != nil {
+			t.Fatal(err)
+		}
+
+		if loss := model_loss(t, &model, fold_test); math.Abs(loss-fold.LogLoss) > 1e-6 {
+			t.Fatalf("fold %d reported %g, model loss %g", fold.Fold, fold.LogLoss, loss)
+		}
+	}
+
+	if lines != 3000 {
+		t.Fatalf("test folds cover %d lines", lines)
+	}
+}
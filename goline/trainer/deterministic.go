@@ -0,0 +1,115 @@
+package trainer
+
+import (
+	"goline/solver"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+const (
+	DefaultSyncStep = 1000
+)
+
+//确定性训练配置：dropout使用固定种子，各线程读取固定的数据分区，每训练SyncStep个样本在屏障处
+//按线程顺序同步参数。相同的数据和配置得到逐位相同的模型，线程数不同时结果不同。
+//打乱顺序时需要同时指定shuffle的随机种子
+type DeterministicConfig struct {
+	Seed     int64
+	SyncStep int
+}
+
+func NewDeterministicConfig(seed int64, sync_step int) *DeterministicConfig {
+	if sync_step <= 0 {
+		sync_step = DefaultSyncStep
+	}
+
+	return &DeterministicConfig{Seed: seed, SyncStep: sync_step}
+}
+
+//第epoch轮线程i的dropout随机数
+func dropout_rand(seed int64, epoch int, i int) *rand.Rand {
+	return rand.New(dropout_source(seed, epoch, i))
+}
+
+func dropout_source(seed int64, epoch int, i int) *counted_source {
+	return &counted_source{Source: rand.NewSource(seed + int64(epoch)*1000003 + int64(i) + 1)}
+}
+
+//记录取值次数的随机数源，检查点中保存各线程的取值次数，续训时由相同种子跳过相同次数即恢复随机状态
+type counted_source struct {
+	rand.Source
+	draws int64
+}
+
+func (cs *counted_source) Int63() int64 {
+	cs.draws++
+	return cs.Source.Int63()
+}
+
+func (cs *counted_source) skip(draws int64) {
+	for cs.draws < draws {
+		cs.Int63()
+	}
+}
+
+//配置写入模型元数据，conf为nil时不记录
+func (dc *DeterministicConfig) record(fs *solver.FtrlSolver) {
+	if dc == nil {
+		return
+	}
+
+	fs.SetMeta("DeterministicSeed", strconv.FormatInt(dc.Seed, 10))
+	fs.SetMeta("SyncStep", strconv.Itoa(dc.SyncStep))
+}
+
+//同步屏障：各线程每训练固定数量的样本后到达，全部活动线程到达或读完退出后执行action，再一起继续。
+//最后一个线程退出时再执行一次action，合并最后一段的更新
+type round_barrier struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	active  int
+	arrived int
+	gen     int
+	action  func()
+}
+
+func new_round_barrier(active int, action func()) *round_barrier {
+	rb := &round_barrier{active: active, action: action}
+	rb.cond = sync.NewCond(&rb.lock)
+	return rb
+}
+
+//调用时持有lock
+func (rb *round_barrier) fire() {
+	rb.action()
+	rb.arrived = 0
+	rb.gen++
+	rb.cond.Broadcast()
+}
+
+func (rb *round_barrier) wait() {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.arrived++
+	if rb.arrived >= rb.active {
+		rb.fire()
+		return
+	}
+
+	gen := rb.gen
+	for gen == rb.gen {
+		rb.cond.Wait()
+	}
+}
+
+func (rb *round_barrier) leave() {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.active--
+	if rb.arrived >= rb.active {
+		rb.fire()
+	}
+}
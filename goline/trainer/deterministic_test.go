@@ -0,0 +1,52 @@
+package trainer
+
+import (
+	"goline/solver"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestDropoutSeed(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	write_synthetic_file(t, train_file, 3000, 1)
+
+	//单线程时模型只取决于dropout的随机数
+	for _, kind := range []string{TrainerFtrl, TrainerLockFree} {
+		var models []*solver.FtrlSolver
+		for _, seed := range []int64{7, 7, 8} {
+			tr, err := NewTrainer(kind, TrainerConfig{Epoch: 2, NumThreads: 1, Seed: seed})
+			if err != nil {
+				t.Fatal(err)
+			}
+			models = append(models, train_model(t, tr, 0.3, filepath.Join(dir, kind+".dat"), train_file))
+		}
+
+		if !same_params(models[0], models[1]) {
+			t.Fatalf("%s: same seed gives different models", kind)
+		}
+
+		if same_params(models[0], models[2]) {
+			t.Fatalf("%s: different seeds give the same model", kind)
+		}
+
+		if models[0].Meta["DropoutSeed"] != "7" {
+			t.Fatalf("%s: meta=%v", kind, models[0].Meta)
+		}
+	}
+
+	//多线程时各线程的随机数独立，确定性训练逐位可复现
+	var models []*solver.FtrlSolver
+	for i := 0; i < 2; i++ {
+		var fft FastFtrlTrainer
+		fft.Initialize(2, 3, false, 0, DefaultPushStep, DefaultFetchStep)
+		fft.SetJobName("det" + strconv.Itoa(i))
+		fft.SetDeterministic(11, 100)
+		models = append(models, train_model(t, &fft, 0.3, filepath.Join(dir, "fast.dat"), train_file))
+	}
+
+	if !same_params(models[0], models[1]) || models[0].Meta["DropoutSeed"] != "11" {
+		t.Fatalf("deterministic runs differ, meta=%v", models[0].Meta)
+	}
+}
@@ -0,0 +1,287 @@
+package trainer
+
+import (
+	"errors"
+	"fmt"
+	"goline/solver"
+	"goline/util"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	DistPollInterval = time.Second //协调进程查询worker完成情况的间隔
+)
+
+//多进程训练：参数服务器的各分片运行在goline ps进程中(ServeParamShard)，协调进程(Coordinate)扫描训练数据、
+//初始化各分片，等待全部worker完成后汇总并保存模型；各worker进程(TrainWorker)按行hash取训练数据的第Part个分区，
+//多线程FtrlWorker通过RemoteParamServer与分片交换参数。暂不支持特征字典、数值预处理和特征交叉
+type DistFtrlTrainer struct {
+	trainer_base
+	PushStep  int
+	FetchStep int
+
+	Part      int
+	Parts     int
+	Sharding  string //range或hash
+	GroupSize int
+	LockMode  string
+
+	ParamServer solver.RemoteParamServer
+	Solver      solver.FtrlSolver //协调进程汇总的模型
+}
+
+func (dft *DistFtrlTrainer) SetJobName(name string) {
+
+	dft.JobName = "distftrljob"
+	if name != "" {
+		dft.JobName = name
+	}
+}
+
+func (dft *DistFtrlTrainer) Model() *solver.FtrlSolver {
+	return &dft.Solver
+}
+
+func (dft *DistFtrlTrainer) Initialize(
+	epoch int,
+	num_threads int,
+	cache_feature_num bool,
+	push_step int,
+	fetch_step int) bool {
+	dft.Epoch = epoch
+	dft.CacheFeatureNum = cache_feature_num
+	dft.PushStep = push_step
+	dft.FetchStep = fetch_step
+	if push_step <= 0 {
+		dft.PushStep = DefaultPushStep
+	}
+
+	if fetch_step <= 0 {
+		dft.FetchStep = DefaultFetchStep
+	}
+
+	dft.NumThreads = num_threads
+	if num_threads == 0 {
+		dft.NumThreads = runtime.NumCPU()
+	}
+
+	dft.Parts = 1
+	dft.log = util.GetLogger()
+	dft.Init = true
+	return dft.Init
+}
+
+//本worker训练第part个分区，共parts个worker
+func (dft *DistFtrlTrainer) SetPartition(part int, parts int) {
+	dft.Part = part
+	dft.Parts = parts
+}
+
+//参数组的分片方式(range或hash)、分组大小和分片内的加锁方式，只对协调进程有效
+func (dft *DistFtrlTrainer) SetSharding(sharding string, group_size int, lock_mode string) {
+	dft.Sharding = sharding
+	dft.GroupSize = group_size
+	dft.LockMode = lock_mode
+}
+
+func (dft *DistFtrlTrainer) check_features() error {
+	if dft.Dict != nil || dft.Preprocess != nil || dft.Cross != nil {
+		return errors.New("feature dict, preprocess and cross are not supported in distributed training.")
+	}
+
+	return nil
+}
+
+//协调进程：初始化addrs上的分片，等待workers个worker完成后汇总模型，给出test_file时评估
+func (dft *DistFtrlTrainer) Coordinate(
+	addrs []string,
+	workers int,
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	train_file string,
+	test_file string) error {
+
+	if !dft.Init {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] Distributed ftrl trainer initialize error.")
+		return errors.New("[DistFtrlTrainer-Coordinate] Distributed ftrl trainer initialize error.")
+	}
+
+	if err := dft.check_features(); err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+		return errors.New("[DistFtrlTrainer-Coordinate] " + err.Error())
+	}
+
+	feat_num, _, _ := read_problem_info(dft.context(), train_file, dft.CacheFeatureNum, dft.NumThreads, nil)
+	if err := dft.check_canceled(0, 0); err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+		return err
+	}
+
+	if feat_num == 0 {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] The number of features is zero.")
+		return errors.New("[DistFtrlTrainer-Coordinate] The number of features is zero.")
+	}
+
+	if !dft.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] Solver initializing error.")
+		return errors.New("[DistFtrlTrainer-Coordinate] Solver initializing error.")
+	}
+
+	err := solver.InitParamShards(addrs, &dft.Solver, dft.GroupSize, dft.Sharding, dft.LockMode)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+		return errors.New("[DistFtrlTrainer-Coordinate] " + err.Error())
+	}
+
+	err = dft.ParamServer.Connect(addrs)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+		return errors.New("[DistFtrlTrainer-Coordinate] " + err.Error())
+	}
+	defer dft.ParamServer.Close()
+
+	dft.log.Info(fmt.Sprintf("[%s] %d shards initialized with %d features, waiting for %d workers.", dft.JobName, len(addrs), feat_num, workers))
+
+	var timer util.StopWatch
+	timer.StartTimer()
+	last := -1
+	for {
+		done, err := dft.ParamServer.Status()
+		if err != nil {
+			dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+			return errors.New("[DistFtrlTrainer-Coordinate] " + err.Error())
+		}
+
+		if done != last {
+			dft.log.Info(fmt.Sprintf("[%s] workers done=[%d/%d] time=[%.2f]\n", dft.JobName, done, workers, timer.StopTimer()))
+			last = done
+		}
+
+		if done >= workers {
+			break
+		}
+
+		select {
+		case <-dft.context().Done():
+			err := dft.check_canceled(0, 0)
+			dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+			return err
+		case <-time.After(DistPollInterval):
+		}
+	}
+
+	err = dft.ParamServer.FetchParam(dft.Solver.N, dft.Solver.Z)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] " + err.Error())
+		return errors.New("[DistFtrlTrainer-Coordinate] " + err.Error())
+	}
+
+	if evaluate := dft.evaluator(test_file, &dft.Solver); evaluate != nil {
+		res := evaluate()
+		dft.log.Info(fmt.Sprintf("[%s] validation-loss=[%f] validation-auc=[%f]\n", dft.JobName, res.Loss, res.AUC))
+	}
+
+	dft.Solver.SetMeta("Workers", fmt.Sprintf("%d", workers))
+	dft.Solver.SetMeta("Shards", fmt.Sprintf("%d", len(addrs)))
+	err = dft.save_model(&dft.Solver, model_file)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-Coordinate] Save model error." + err.Error())
+		return errors.New("[DistFtrlTrainer-Coordinate] Save model error." + err.Error())
+	}
+
+	return nil
+}
+
+//worker进程：取训练数据的第Part个分区，训练Epoch轮后通知分片完成。分片须已由协调进程初始化
+func (dft *DistFtrlTrainer) TrainWorker(addrs []string, train_file string) error {
+	if !dft.Init {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] Distributed ftrl trainer initialize error.")
+		return errors.New("[DistFtrlTrainer-TrainWorker] Distributed ftrl trainer initialize error.")
+	}
+
+	if err := dft.check_features(); err != nil {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		return errors.New("[DistFtrlTrainer-TrainWorker] " + err.Error())
+	}
+
+	err := dft.ParamServer.Connect(addrs)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		return errors.New("[DistFtrlTrainer-TrainWorker] " + err.Error())
+	}
+	defer dft.ParamServer.Close()
+
+	part_file := train_file
+	if dft.Parts > 1 {
+		part_file = fmt.Sprintf("%s.part-%d-of-%d", train_file, dft.Part, dft.Parts)
+		_, err = util.PartitionFile(train_file, part_file, dft.Parts, dft.Part, "")
+		defer func() {
+			os.Remove(part_file)
+			os.Remove(cache_path(part_file))
+		}()
+		if err != nil {
+			dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+			return errors.New("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		}
+	}
+
+	_, line_cnt, _ := read_problem_info(dft.context(), part_file, dft.CacheFeatureNum, dft.NumThreads, nil)
+	if err := dft.check_canceled(0, 0); err != nil {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		return err
+	}
+
+	var workers []solver.FtrlWorker = make([]solver.FtrlWorker, dft.NumThreads)
+	for i := 0; i < dft.NumThreads; i++ {
+		workers[i].Initialize(&dft.ParamServer, dft.PushStep, dft.FetchStep)
+	}
+
+	ops := train_ops{
+		workers: dft.NumThreads,
+		model:   &dft.ParamServer.FtrlSolver,
+		open: func(epoch int) (SampleReader, error) {
+			return open_partitioned_reader(part_file, dft.NumThreads, nil, dft.CacheFeatureNum, dft.Mmap, dft.Shuffle, epoch)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			return workers[i].UpdateWithWeight(x, y, dft.SampleRates.Weight(y), &dft.ParamServer)
+		},
+		before_epoch: func(epoch int, reader SampleReader, resume bool) bool {
+			for i := 0; i < dft.NumThreads; i++ {
+				workers[i].Reset(&dft.ParamServer)
+			}
+
+			return true
+		},
+		after_worker: func(i int) {
+			workers[i].PushParam(&dft.ParamServer)
+		}}
+
+	err = dft.train_epochs(&ops, line_cnt)
+	if err == nil {
+		err = dft.ParamServer.Flush()
+	}
+
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		if IsCanceled(err) {
+			return err
+		}
+
+		return errors.New("[DistFtrlTrainer-TrainWorker] " + err.Error())
+	}
+
+	done, err := dft.ParamServer.Done(dft.Part)
+	if err != nil {
+		dft.log.Error("[DistFtrlTrainer-TrainWorker] " + err.Error())
+		return errors.New("[DistFtrlTrainer-TrainWorker] " + err.Error())
+	}
+
+	dft.log.Info(fmt.Sprintf("[%s] worker %d of %d done, %d workers done.", dft.JobName, dft.Part, dft.Parts, done))
+	return nil
+}
@@ -0,0 +1,113 @@
+package trainer
+
+import (
+	"goline/solver"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//启动分片，协调进程和各worker分别用自己的RemoteParamServer客户端与分片交换参数，返回协调进程保存的模型
+func train_dist(t *testing.T, dir string, name string, shards int, workers int, push_step int, train_file string, test_file string) *solver.FtrlSolver {
+	var addrs []string
+	for s := 0; s < shards; s++ {
+		listener, err := solver.ServeParamShard("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		addrs = append(addrs, listener.Addr().String())
+	}
+
+	model_file := filepath.Join(dir, name+".dat")
+	coordinated := make(chan error, 1)
+	go func() {
+		var dft DistFtrlTrainer
+		dft.Initialize(1, 1, false, push_step, push_step)
+		dft.SetJobName(name)
+		dft.SetSharding(solver.ShardHash, 4, "")
+		coordinated <- dft.Coordinate(addrs, workers, 0.1, 1, 0, 1, 0, model_file, train_file, test_file)
+	}()
+
+	//worker须在协调进程初始化分片之后启动
+	for {
+		var rps solver.RemoteParamServer
+		if rps.Connect(addrs) == nil {
+			rps.Close()
+			break
+		}
+
+		select {
+		case err := <-coordinated:
+			t.Fatalf("coordinator exited: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	trained := make(chan error, workers)
+	for part := 0; part < workers; part++ {
+		go func(part int) {
+			var dft DistFtrlTrainer
+			dft.Initialize(1, 1, false, push_step, push_step)
+			dft.SetJobName(name)
+			dft.SetPartition(part, workers)
+			trained <- dft.TrainWorker(addrs, train_file)
+		}(part)
+	}
+
+	for part := 0; part < workers; part++ {
+		if err := <-trained; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := <-coordinated; err != nil {
+		t.Fatal(err)
+	}
+
+	var model solver.FtrlSolver
+	if err := model.Construct(model_file); err != nil {
+		t.Fatal(err)
+	}
+
+	return &model
+}
+
+func TestDistFtrlTrainer(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, train_file, 6000, 1)
+	write_synthetic_file(t, test_file, 2000, 2)
+
+	//单线程的进程内训练
+	var fft FastFtrlTrainer
+	fft.Initialize(1, 1, false, 0, 1, 1)
+	fft.SetJobName("local")
+	local := train_model(t, &fft, 0, filepath.Join(dir, "local.dat"), train_file)
+
+	//一个worker、每个样本都交换参数时与进程内训练的参数相同，与分片数无关
+	single := train_dist(t, dir, "single", 3, 1, 1, train_file, "")
+	if single.Featnum != local.Featnum || single.Meta["Workers"] != "1" || single.Meta["Shards"] != "3" {
+		t.Fatalf("featnum=%d meta=%v", single.Featnum, single.Meta)
+	}
+
+	for i := 0; i < local.Featnum; i++ {
+		if math.Abs(single.N[i]-local.N[i]) > 1e-9 || math.Abs(single.Z[i]-local.Z[i]) > 1e-9 {
+			t.Fatalf("param %d: n=%g/%g z=%g/%g", i, single.N[i], local.N[i], single.Z[i], local.Z[i])
+		}
+	}
+
+	//两个worker各训练一个分区，异步交换参数，效果与进程内训练相近
+	multi := train_dist(t, dir, "multi", 2, 2, DefaultPushStep, train_file, test_file)
+	if multi.Meta["Workers"] != "2" {
+		t.Fatalf("meta=%v", multi.Meta)
+	}
+
+	local_loss := model_loss(t, local, test_file)
+	multi_loss := model_loss(t, multi, test_file)
+	if math.Abs(multi_loss-local_loss) > 0.02 {
+		t.Fatalf("distributed loss %g, local loss %g", multi_loss, local_loss)
+	}
+}
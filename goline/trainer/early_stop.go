@@ -0,0 +1,100 @@
+package trainer
+
+import (
+	"errors"
+	"goline/solver"
+)
+
+const (
+	MetricLogLoss = "logloss"
+	MetricAUC     = "auc"
+)
+
+//按测试集指标提前结束训练：连续Patience轮没有比最好结果提升MinDelta以上时停止，
+//每次提升时保存参数快照，训练结束后恢复为最好一轮的参数
+type EarlyStopping struct {
+	Metric    string
+	Patience  int
+	MinDelta  float64
+	BestEpoch int
+	BestScore float64
+
+	wait   int
+	best_n []float64
+	best_z []float64
+}
+
+//metric为logloss或auc，patience小于1时按1处理
+func NewEarlyStopping(metric string, patience int, min_delta float64) (*EarlyStopping, error) {
+	if metric != MetricLogLoss && metric != MetricAUC {
+		return nil, errors.New("[NewEarlyStopping] Unknown metric " + metric)
+	}
+
+	if patience < 1 {
+		patience = 1
+	}
+
+	if min_delta < 0 {
+		min_delta = -min_delta
+	}
+
+	es := &EarlyStopping{Metric: metric, Patience: patience, MinDelta: min_delta}
+	es.reset()
+	return es, nil
+}
+
+func (es *EarlyStopping) reset() {
+	es.BestEpoch = -1
+	es.BestScore = 0
+	es.wait = 0
+	es.best_n = nil
+	es.best_z = nil
+}
+
+//auc越大越好，logloss越小越好
+func (es *EarlyStopping) improved(score float64) bool {
+	if es.BestEpoch < 0 {
+		return true
+	}
+
+	if es.Metric == MetricAUC {
+		return score > es.BestScore+es.MinDelta
+	}
+
+	return score < es.BestScore-es.MinDelta
+}
+
+//每轮评估后调用，提升时保存快照，超过patience时返回ErrStopTraining
+func (es *EarlyStopping) update(state *TrainState) error {
+	score := state.EvalLoss
+	if es.Metric == MetricAUC {
+		score = state.EvalAUC
+	}
+
+	if es.improved(score) {
+		es.BestEpoch = state.Epoch
+		es.BestScore = score
+		es.wait = 0
+		es.best_n = append(es.best_n[:0], state.Model.N...)
+		es.best_z = append(es.best_z[:0], state.Model.Z...)
+		return nil
+	}
+
+	es.wait++
+	if es.wait >= es.Patience {
+		return ErrStopTraining
+	}
+
+	return nil
+}
+
+//将模型恢复为最好一轮的参数，最后一轮即为最好时不需要恢复，返回是否恢复
+func (es *EarlyStopping) restore(model *solver.FtrlSolver) bool {
+	if es.BestEpoch < 0 || es.wait == 0 {
+		return false
+	}
+
+	copy(model.N, es.best_n)
+	copy(model.Z, es.best_z)
+	return true
+}
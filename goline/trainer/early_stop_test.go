@@ -0,0 +1,86 @@
+package trainer
+
+import (
+	"goline/solver"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestEarlyStoppingRestoresBestEpoch(t *testing.T) {
+	es, err := NewEarlyStopping(MetricLogLoss, 2, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := &solver.FtrlSolver{N: []float64{0, 0}, Z: []float64{0, 0}}
+	losses := []float64{0.6, 0.5, 0.495, 0.52}
+	var stop error
+	for epoch, loss := range losses {
+		model.N[0], model.Z[0] = float64(epoch), float64(-epoch)
+		stop = es.update(&TrainState{Epoch: epoch, EvalLoss: loss, Model: model})
+		if epoch < 3 && stop != nil {
+			t.Fatalf("stopped at epoch %d", epoch)
+		}
+	}
+
+	//0.495没有比0.5提升0.01以上，连续两轮没有提升
+	if stop != ErrStopTraining || es.BestEpoch != 1 || es.BestScore != 0.5 {
+		t.Fatalf("stop=%v best epoch=%d score=%g", stop, es.BestEpoch, es.BestScore)
+	}
+
+	if !es.restore(model) || model.N[0] != 1 || model.Z[0] != -1 {
+		t.Fatalf("restored N=%v Z=%v", model.N, model.Z)
+	}
+
+	auc, _ := NewEarlyStopping(MetricAUC, 0, 0)
+	auc.update(&TrainState{Epoch: 0, EvalAUC: 0.7, Model: model})
+	if auc.update(&TrainState{Epoch: 1, EvalAUC: 0.8, Model: model}) != nil || auc.BestEpoch != 1 {
+		t.Fatal("higher auc is not an improvement")
+	}
+
+	if _, err := NewEarlyStopping("accuracy", 1, 0); err == nil {
+		t.Fatal("unknown metric accepted")
+	}
+}
+
+func TestTrainEarlyStop(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	model_file := filepath.Join(dir, "model.dat")
+	write_synthetic_file(t, train_file, 2000, 1)
+	write_synthetic_file(t, test_file, 2000, 2)
+
+	var ft FtrlTrainer
+	ft.Initialize(30, false)
+	//测试集loss每轮下降不到0.01时停止
+	if err := ft.SetEarlyStop(MetricLogLoss, 1, 0.01); err != nil {
+		t.Fatal(err)
+	}
+
+	epochs := 0
+	ft.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+		epochs++
+		return nil
+	}})
+
+	if err := ft.Train(0.1, 1, 0, 0, 0, model_file, train_file, test_file); err != nil {
+		t.Fatal(err)
+	}
+
+	var model solver.FtrlSolver
+	if err := model.Construct(model_file); err != nil {
+		t.Fatal(err)
+	}
+
+	if epochs >= 30 || model.Meta["BestEpoch"] != strconv.Itoa(ft.EarlyStop.BestEpoch) {
+		t.Fatalf("epochs=%d meta=%v best=%d", epochs, model.Meta, ft.EarlyStop.BestEpoch)
+	}
+
+	//保存的是最好一轮的参数
+	if loss := model_loss(t, &model, test_file); math.Abs(loss-ft.EarlyStop.BestScore) > 1e-6 {
+		t.Fatalf("saved model loss %g, best %g", loss, ft.EarlyStop.BestScore)
+	}
+}
@@ -3,28 +3,33 @@ package trainer
 import (
 	"errors"
 	"fmt"
-	"goline/deps/log4go"
 	"goline/solver"
 	"goline/util"
+	"io"
 	"math"
 	"runtime"
-	"sync"
 )
 
-type FastFtrlTrainer struct {
-	Epoch           int
-	CacheFeatureNum bool
-	PusStep         int
-	FetchStep       int
-	BurnIn          float64
-
-	ParamServer solver.FtrlParamServer
-	NumThreads  int
+const (
+	DefaultClockStep = 1000
+)
 
-	JobName string
+//有界延迟配置：各线程每训练ClockStep个样本时钟加1，比最慢的线程快Staleness个时钟以上时等待
+type StalenessConfig struct {
+	Staleness int
+	ClockStep int
+}
 
-	Init    bool
-	log4fft log4go.Logger
+type FastFtrlTrainer struct {
+	trainer_base
+	PusStep   int
+	FetchStep int
+	BurnIn    float64
+
+	BurnInConf    *BurnInConfig
+	Deterministic *DeterministicConfig
+	Staleness     *StalenessConfig
+	ParamServer   solver.FtrlParamServer
 }
 
 func (fft *FastFtrlTrainer) SetJobName(name string) {
@@ -35,6 +40,10 @@ func (fft *FastFtrlTrainer) SetJobName(name string) {
 	}
 }
 
+func (fft *FastFtrlTrainer) Model() *solver.FtrlSolver {
+	return &fft.ParamServer.FtrlSolver
+}
+
 func (fft *FastFtrlTrainer) Initialize(
 	epoch int,
 	num_threads int,
@@ -54,10 +63,63 @@ func (fft *FastFtrlTrainer) Initialize(
 
 	fft.Init = true
 	fft.BurnIn = burn_in
-	fft.log4fft = util.GetLogger()
+	fft.log = util.GetLogger()
 	return fft.Init
 }
 
+//预热数据、热启动模型和预热期间的学习率计划，file为空时用首轮前BurnIn比例的训练样本预热。
+//TrainRestore时参数来自last_model，不使用热启动模型
+func (fft *FastFtrlTrainer) SetBurnIn(file string, model string, schedule string, alpha_start float64) error {
+	conf, err := NewBurnInConfig(file, model, schedule, alpha_start)
+	if err != nil {
+		return err
+	}
+
+	fft.BurnInConf = conf
+	return nil
+}
+
+//扫描预热数据，使用特征字典时为预热数据中的新特征分配下标，返回预热数据的特征数
+func (fft *FastFtrlTrainer) scan_burn_in(dict *util.FeatureDict) int {
+	if fft.BurnInConf == nil || len(fft.BurnInConf.File) == 0 {
+		return 0
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(fft.context(), fft.BurnInConf.File, fft.CacheFeatureNum, fft.scan_threads(), dict)
+	fft.BurnInConf.lines = line_cnt
+	return feat_num
+}
+
+//确定性训练，相同的数据和配置得到逐位相同的模型，用于流水线的回归测试。
+//参数每训练sync_step个样本同步一次，取代PushStep、FetchStep的异步同步，sync_step为0时使用默认值
+func (fft *FastFtrlTrainer) SetDeterministic(seed int64, sync_step int) {
+	fft.Deterministic = NewDeterministicConfig(seed, sync_step)
+}
+
+//有界延迟的异步训练，staleness为允许领先最慢线程的时钟数，clock_step为0时使用默认值。
+//与确定性训练同时设置时按确定性训练
+func (fft *FastFtrlTrainer) SetStaleness(staleness int, clock_step int) {
+	if clock_step <= 0 {
+		clock_step = DefaultClockStep
+	}
+
+	fft.Staleness = &StalenessConfig{Staleness: staleness, ClockStep: clock_step}
+}
+
+//参数服务器的分组大小和加锁方式(group、striped、atomic)，lock_count只对striped有效，参数为0或空时使用默认值
+func (fft *FastFtrlTrainer) SetParamServer(group_size int, lock_mode string, lock_count int) error {
+	return fft.ParamServer.Configure(group_size, lock_mode, lock_count)
+}
+
+//确定性训练时特征字典的下标分配和预处理的拟合与扫描顺序有关，单线程扫描
+func (fft *FastFtrlTrainer) scan_threads() int {
+	if fft.Deterministic != nil && (fft.Dict != nil || fft.ParamServer.Dict != nil || fft.Preprocess != nil) {
+		return 1
+	}
+
+	return fft.NumThreads
+}
+
 func (fft *FastFtrlTrainer) Train(
 	alpha float64,
 	beta float64,
@@ -69,26 +131,78 @@ func (fft *FastFtrlTrainer) Train(
 	test_file string) error {
 
 	if !fft.Init {
-		fft.log4fft.Error("[FastFtrlTrainer-Train] Fast ftrl trainer initialize error.")
+		fft.log.Error("[FastFtrlTrainer-Train] Fast ftrl trainer initialize error.")
 		return errors.New("[FastFtrlTrainer-Train] Fast ftrl trainer initialize error.")
 	}
 
-	if !util.FileExists(train_file) || !util.FileExists(test_file) {
-		fft.log4fft.Error("[FastFtrlTrainer-Train] Train file or test file is not exist.")
+	if !util.FileExists(train_file) || (len(test_file) != 0 && !util.FileExists(test_file)) {
+		fft.log.Error("[FastFtrlTrainer-Train] Train file or test file is not exist.")
 		return errors.New("[FastFtrlTrainer-Train] Train file or test file is not exist.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, fft.CacheFeatureNum, fft.NumThreads)
+	warm, err := fft.BurnInConf.load_model()
+	if err != nil {
+		fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Warm start model error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FastFtrlTrainer-Train] Warm start model error.%s", err.Error()))
+	}
+
+	//热启动时以模型的特征字典为基础，训练数据中的新特征名在其后分配下标
+	if warm != nil && warm.Dict != nil && fft.Dict != nil && fft.Dict.Len() == 0 {
+		fft.Dict = warm.Dict
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(fft.context(), train_file, fft.CacheFeatureNum, fft.scan_threads(), fft.Dict)
+	feat_num = util.MaxInt(feat_num, fft.scan_burn_in(fft.Dict))
+	//热启动模型的原始特征可能多于训练数据，分箱和交叉特征排在全部原始特征之后
+	if warm != nil {
+		feat_num = util.MaxInt(feat_num, warm.RawFeatnum())
+	}
+
+	if err := fft.check_canceled(0, 0); err != nil {
+		fft.log.Error("[FastFtrlTrainer-Train] " + err.Error())
+		return err
+	}
+
 	if feat_num == 0 {
-		fft.log4fft.Error("[FastFtrlTrainer-Train] The number of features is zero.")
+		fft.log.Error("[FastFtrlTrainer-Train] The number of features is zero.")
 		return errors.New("[FastFtrlTrainer-Train] The number of features is zero.")
 	}
 
-	err := fft.ParamServer.Initialize(alpha, beta, l1, l2, feat_num, dropout)
+	preprocess, feat_num, err := build_feature_preprocess(fft.context(), fft.Preprocess, train_file, feat_num, fft.scan_threads(), fft.Dict)
+	if cerr := fft.check_canceled(0, 0); cerr != nil {
+		fft.log.Error("[FastFtrlTrainer-Train] " + cerr.Error())
+		return cerr
+	}
+
+	if err != nil {
+		fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FastFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+	}
+
+	cross, feat_num, err := build_feature_cross(fft.Cross, feat_num)
+	if err != nil {
+		fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FastFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+	}
+
+	err = fft.ParamServer.Initialize(alpha, beta, l1, l2, feat_num, dropout)
 	if err != nil {
-		fft.log4fft.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Parameter server initializing error.%s", err.Error()))
+		fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Parameter server initializing error.%s", err.Error()))
 		return errors.New(fmt.Sprintf("[FastFtrlTrainer-Train] Parameter server initializing error.%s", err.Error()))
 	}
+	fft.ParamServer.Preprocess = preprocess
+	fft.ParamServer.Cross = cross
+	fft.ParamServer.Dict = fft.Dict
+
+	if warm != nil {
+		warm_cnt, err := warm_start(&fft.ParamServer.FtrlSolver, warm)
+		if err != nil {
+			fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-Train] Warm start model error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FastFtrlTrainer-Train] Warm start model error.%s", err.Error()))
+		}
+
+		fft.log.Info(fmt.Sprintf("[%s] warm start from %s, features=[%d/%d]\n", fft.JobName, fft.BurnInConf.Model, warm_cnt, feat_num))
+	}
 
 	return fft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
@@ -100,25 +214,52 @@ func (fft *FastFtrlTrainer) TrainRestore(
 	test_file string) error {
 
 	if !fft.Init {
-		fft.log4fft.Error("[FastFtrlTrainer-TrainRestore] Fast ftrl trainer restore error.")
+		fft.log.Error("[FastFtrlTrainer-TrainRestore] Fast ftrl trainer restore error.")
 		return errors.New("[FastFtrlTrainer-TrainRestore] Fast ftrl trainer restore error.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, fft.CacheFeatureNum, fft.NumThreads)
+	err := fft.ParamServer.Construct(last_model)
+	if err != nil {
+		fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-TrainRestore] Parameter server restore error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FastFtrlTrainer-TrainRestore] Parameter server restore error.%s", err.Error()))
+	}
+
+	err = fft.restore_progress(&fft.ParamServer.FtrlSolver)
+	if err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[FastFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	//优先使用模型中的特征字典，指定了不同的字典时按特征名重新映射
+	err = fft.restore_dict(&fft.ParamServer.FtrlSolver)
+	if err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[FastFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(fft.context(), train_file, fft.CacheFeatureNum, fft.scan_threads(), fft.ParamServer.Dict)
+	fft.scan_burn_in(fft.ParamServer.Dict)
+	if err := fft.check_canceled(0, 0); err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainRestore] " + err.Error())
+		return err
+	}
+
 	if feat_num == 0 {
-		fft.log4fft.Error("[FastFtrlTrainer-TrainRestore] The number of features is zero.")
+		fft.log.Error("[FastFtrlTrainer-TrainRestore] The number of features is zero.")
 		return errors.New("[FastFtrlTrainer-TrainRestore] The number of features is zero.")
 	}
 
-	err := fft.ParamServer.Construct(last_model)
-	if err != nil {
-		fft.log4fft.Error(fmt.Sprintf("[FastFtrlTrainer-TrainRestore] Parameter server restore error.%s", err.Error()))
-		return errors.New(fmt.Sprintf("[FastFtrlTrainer-TrainRestore] Parameter server restore error.%s", err.Error()))
+	if fft.grow_features(&fft.ParamServer.FtrlSolver, feat_num) {
+		fft.ParamServer.Regroup()
 	}
 
 	return fft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
 
+func (fft *FastFtrlTrainer) open_train_file(train_file string, epoch int) (SampleReader, error) {
+	return open_partitioned_reader(train_file, fft.NumThreads, fft.ParamServer.Dict, fft.CacheFeatureNum, fft.Mmap, fft.Shuffle, epoch)
+}
+
 func (fft *FastFtrlTrainer) TrainImpl(
 	model_file string,
 	train_file string,
@@ -126,123 +267,237 @@ func (fft *FastFtrlTrainer) TrainImpl(
 	test_file string) error {
 
 	if !fft.Init {
-		fft.log4fft.Error("[FastFtrlTrainer-TrainImpl] Fast ftrl trainer restore error.")
+		fft.log.Error("[FastFtrlTrainer-TrainImpl] Fast ftrl trainer restore error.")
 		return errors.New("[FastFtrlTrainer-TrainImpl] Fast ftrl trainer restore error.")
 	}
 
-	fft.log4fft.Info(fmt.Sprintf(
-		"[%s] params={alpha:%.2f, beta:%.2f, l1:%.2f, l2:%.2f, dropout:%.2f, epoch:%d}\n",
-		fft.JobName,
-		fft.ParamServer.Alpha,
-		fft.ParamServer.Beta,
-		fft.ParamServer.L1,
-		fft.ParamServer.L2,
-		fft.ParamServer.Dropout,
-		fft.Epoch))
-
 	var solvers []solver.FtrlWorker = make([]solver.FtrlWorker, fft.NumThreads)
 	for i := 0; i < fft.NumThreads; i++ {
 		solvers[i].Initialize(&fft.ParamServer, fft.PusStep, fft.FetchStep)
 	}
 
-	predict_func := func(x util.Pvector) float64 {
-		return fft.ParamServer.Predict(x)
-	}
-
-	var timer util.StopWatch
-	timer.StartTimer()
-	for iter := 0; iter < fft.Epoch; iter++ {
-		var file_parser ParallelFileParser
-		file_parser.OpenFile(train_file, fft.NumThreads)
-		count := 0
-		var loss float64 = 0.
-
-		var lock sync.Mutex
-		worker_func := func(i int, c *sync.WaitGroup) {
-			local_count := 0
-			var local_loss float64 = 0
-			for {
-				flag, y, x := file_parser.ReadSampleMultiThread(i)
-				if flag != nil {
-					break
-				}
-
-				pred := solvers[i].Update(x, y, &fft.ParamServer)
-				local_loss += calc_loss(y, pred)
-				local_count++
-
-				if i == 0 && local_count%10000 == 0 {
-					tmp_cnt := math.Min(float64(local_count*fft.NumThreads), float64(line_cnt))
-					fft.log4fft.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-						fft.JobName,
-						iter,
-						float64(tmp_cnt*100)/float64(line_cnt),
-						timer.StopTimer(),
-						float64(local_loss)/float64(local_count)))
-				}
-			}
-			lock.Lock()
-			count += local_count
-			loss += local_loss
-			lock.Unlock()
+	det := fft.Deterministic
+	if det != nil {
+		fft.Seed = det.Seed
+	}
 
-			solvers[i].PushParam(&fft.ParamServer)
-			defer c.Done()
+	var warm *burn_in_schedule
+	before_epoch := func(epoch int, reader SampleReader, resume bool) bool {
+		for i, rd := range fft.dropout_rands(epoch, fft.NumThreads) {
+			solvers[i].SetRand(rd)
 		}
 
-		if iter == 0 && util.UtilGreater(fft.BurnIn, float64(0)) {
-			burn_in_cnt := int(fft.BurnIn * float64(line_cnt))
-			var local_loss float64 = 0
-			for i := 0; i < burn_in_cnt; i++ {
-				//线程0做预热
-				flag, y, x := file_parser.ReadSample(0)
-				if flag != nil {
-					break
-				}
-
-				pred := fft.ParamServer.Update(x, y)
-				local_loss += calc_loss(y, pred)
-				if i%10000 == 0 {
-					fft.log4fft.Info(fmt.Sprintf("[%s] burn-in processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-						fft.JobName,
-						float64((i+1)*100)/float64(line_cnt),
-						timer.StopTimer(),
-						float64(local_loss)/float64(i+1)))
-				}
-			}
+		//首轮开始前预热，从检查点中途继续时预热已完成
+		warm = nil
+		if epoch == 0 && !resume {
+			warm = fft.burn_in(solvers, line_cnt)
+		}
 
-			fft.log4fft.Info(fmt.Sprintf("[%s] burn-in processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-				fft.JobName,
-				float64(burn_in_cnt*100)/float64(line_cnt),
-				timer.StopTimer(),
-				float64(local_loss)/float64(burn_in_cnt)))
+		//上一轮中未完成的预热不带到本轮
+		for i := 0; i < fft.NumThreads; i++ {
+			solvers[i].Alpha = fft.ParamServer.Alpha
+			solvers[i].PushStep = fft.PusStep
+			solvers[i].FetchStep = fft.FetchStep
+			solvers[i].Reset(&fft.ParamServer)
+		}
 
-			if util.UtilFloat64Equal(fft.BurnIn, float64(1)) {
-				continue
+		return true
+	}
+
+	ops := train_ops{
+		workers:    fft.NumThreads,
+		model_file: model_file,
+		model:      &fft.ParamServer.FtrlSolver,
+		open: func(epoch int) (SampleReader, error) {
+			return fft.open_train_file(train_file, epoch)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			if warm != nil {
+				fft.burn_in_step(warm, i, &solvers[i])
 			}
+
+			return solvers[i].UpdateWithWeight(x, y, fft.SampleRates.Weight(y), &fft.ParamServer)
+		},
+		evaluate:     fft.evaluator(test_file, &fft.ParamServer.FtrlSolver),
+		before_epoch: before_epoch,
+		after_worker: func(i int) {
+			solvers[i].PushParam(&fft.ParamServer)
+		}}
+
+	if det == nil && fft.Staleness != nil {
+		ops.clock = solver.NewSSPClock(fft.NumThreads, fft.Staleness.Staleness)
+		for i := 0; i < fft.NumThreads; i++ {
+			solvers[i].SetClock(ops.clock, i, fft.Staleness.ClockStep)
 		}
+	}
 
+	if det != nil {
 		for i := 0; i < fft.NumThreads; i++ {
-			solvers[i].Reset(&fft.ParamServer)
+			solvers[i].Sync = true
+		}
+
+		ops.after_worker = nil
+		ops.sync_step = det.SyncStep
+		ops.sync = func() {
+			fft.ParamServer.SyncWorkers(solvers)
 		}
+	}
+
+	err := fft.train_epochs(&ops, line_cnt)
+	if err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainImpl] " + err.Error())
+		if IsCanceled(err) {
+			return err
+		}
+
+		return errors.New("[FastFtrlTrainer-TrainImpl] " + err.Error())
+	}
+
+	det.record(&fft.ParamServer.FtrlSolver)
+	fft.BurnInConf.record(&fft.ParamServer.FtrlSolver, fft.BurnIn)
+	err = fft.save_model(&fft.ParamServer.FtrlSolver, model_file)
+	if err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainImpl] Save model error." + err.Error())
+		return errors.New("[FastFtrlTrainer-TrainImpl] Save model error." + err.Error())
+	}
+
+	return nil
+}
+
+//预热：设置了预热数据时各线程先训练一遍预热数据并合并到参数服务器；
+//否则返回首轮前BurnIn比例样本的学习率计划，由各线程训练时按计划调整学习率，BurnIn为0时返回nil
+func (fft *FastFtrlTrainer) burn_in(solvers []solver.FtrlWorker, line_cnt int) *burn_in_schedule {
+	conf := fft.BurnInConf
+	alpha := fft.ParamServer.Alpha
+	if conf == nil || len(conf.File) == 0 {
+		if !util.UtilGreater(fft.BurnIn, float64(0)) {
+			return nil
+		}
+
+		samples := int(math.Min(fft.BurnIn, 1) * float64(line_cnt))
+		fft.log.Info(fmt.Sprintf("[%s] burn-in on the first %d samples of epoch 0\n", fft.JobName, samples))
+		return new_burn_in_schedule(conf, alpha, fft.NumThreads, samples)
+	}
+
+	//确定性训练时单线程按文件顺序预热
+	threads := fft.NumThreads
+	if fft.Deterministic != nil {
+		threads = 1
+	}
+
+	reader, err := open_partitioned_reader(conf.File, threads, fft.ParamServer.Dict, fft.CacheFeatureNum, fft.Mmap, nil, 0)
+	if err != nil {
+		fft.log.Warn(fmt.Sprintf("[%s] Open burn-in file error, skip burn-in.%s", fft.JobName, err.Error()))
+		return nil
+	}
+
+	//预热时每个样本都与参数服务器交换参数，不需要有界延迟的时钟
+	clock := solvers[0].Clock
+	for i := 0; i < fft.NumThreads; i++ {
+		solvers[i].Clock = nil
+	}
+
+	reader = with_context(fft.context(), reader)
+	warm := new_burn_in_schedule(conf, alpha, threads, conf.lines)
+	fft.burn_in_pass(reader, threads, conf.lines, func(i int, x util.Pvector, y float64) float64 {
+		fft.burn_in_step(warm, i, &solvers[i])
+		return solvers[i].UpdateWithWeight(x, y, fft.SampleRates.Weight(y), &fft.ParamServer)
+	})
+	reader.CloseFile(threads)
+
+	if fft.Deterministic != nil {
+		fft.ParamServer.SyncWorkers(solvers)
+	}
+
+	for i := 0; i < fft.NumThreads; i++ {
+		solvers[i].PushParam(&fft.ParamServer)
+		solvers[i].Clock = clock
+	}
 
-		util.UtilParallelRun(worker_func, fft.NumThreads)
+	return nil
+}
 
-		file_parser.CloseFile(fft.NumThreads)
+//按学习率计划设置线程i的学习率，预热期间每个样本都与参数服务器交换参数
+func (fft *FastFtrlTrainer) burn_in_step(warm *burn_in_schedule, i int, fw *solver.FtrlWorker) {
+	alpha, on := warm.next(i)
+	fw.Alpha = alpha
+	if on {
+		fw.PushStep, fw.FetchStep = 1, 1
+	} else {
+		fw.PushStep, fw.FetchStep = fft.PusStep, fft.FetchStep
+	}
+}
 
-		//		f(w,
-		//			"[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-		//			fft.JobName,
-		//			iter,
-		//			float64(count*100)/float64(line_cnt),
-		//			timer.StopTimer(),
-		//			float64(loss)/float64(count))
+//从reader(标准输入、命名管道、socket等)流式训练，数据只读一遍，按conf.Checkpoint定期保存模型
+func (fft *FastFtrlTrainer) TrainStream(
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	reader io.Reader,
+	conf StreamConfig) error {
+
+	if !fft.Init {
+		fft.log.Error("[FastFtrlTrainer-TrainStream] Fast ftrl trainer initialize error.")
+		return errors.New("[FastFtrlTrainer-TrainStream] Fast ftrl trainer initialize error.")
+	}
+
+	if len(conf.LastModel) != 0 {
+		err := fft.ParamServer.Construct(conf.LastModel)
+		if err != nil {
+			fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Parameter server restore error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Parameter server restore error.%s", err.Error()))
+		}
+
+		err = fft.restore_dict(&fft.ParamServer.FtrlSolver)
+		if err != nil {
+			fft.log.Error("[FastFtrlTrainer-TrainStream] " + err.Error())
+			return errors.New("[FastFtrlTrainer-TrainStream] " + err.Error())
+		}
 
-		if test_file != "" {
-			eval_loss := evaluate_file(test_file, predict_func, fft.NumThreads)
-			fft.log4fft.Info(fmt.Sprintf("[%s] validation-loss=[%f]\n", fft.JobName, float64(eval_loss)))
+		if fft.grow_features(&fft.ParamServer.FtrlSolver, conf.FeatNum) {
+			fft.ParamServer.Regroup()
 		}
+	} else {
+		cross, feat_num, err := build_stream_features(fft.Preprocess, fft.Cross, conf.FeatNum)
+		if err != nil {
+			fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+		}
+
+		err = fft.ParamServer.Initialize(alpha, beta, l1, l2, feat_num, dropout)
+		if err != nil {
+			fft.log.Error(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Parameter server initializing error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FastFtrlTrainer-TrainStream] Parameter server initializing error.%s", err.Error()))
+		}
+		fft.ParamServer.Cross = cross
+		fft.ParamServer.Dict = fft.Dict
+	}
+
+	var solvers []solver.FtrlWorker = make([]solver.FtrlWorker, fft.NumThreads)
+	for i := 0; i < fft.NumThreads; i++ {
+		solvers[i].Initialize(&fft.ParamServer, fft.PusStep, fft.FetchStep)
+	}
+
+	save_func := func() error {
+		return save_model_atomic(fft.ParamServer.SaveModel, model_file)
+	}
+
+	_, err := fft.train_stream(reader, fft.NumThreads, &fft.ParamServer.FtrlSolver, conf,
+		func(i int, x util.Pvector, y float64) float64 {
+			return solvers[i].UpdateWithWeight(x, y, fft.SampleRates.Weight(y), &fft.ParamServer)
+		}, save_func)
+
+	for i := 0; i < fft.NumThreads; i++ {
+		solvers[i].PushParam(&fft.ParamServer)
+	}
+
+	if err != nil {
+		fft.log.Error("[FastFtrlTrainer-TrainStream] " + err.Error())
+		return err
 	}
 
-	return fft.ParamServer.SaveModel(model_file)
+	return save_func()
 }
@@ -17,6 +17,8 @@ type FileParser struct {
 	Fs      *os.File
 	Bufio   *bufio.Reader
 	Lock    sync.Mutex
+	Dict    *util.FeatureDict
+	Lines   int64
 }
 
 func (fp *FileParser) FileExists(filename string) error {
@@ -84,7 +86,7 @@ func (fp *FileParser) ReadSample() (error, float64, util.Pvector) {
 		return errors.New("[ReadSample] input value error"), 0., nil
 	}
 
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
 }
 
 func (fp *FileParser) ReadSampleMultiThread() (error, float64, util.Pvector) {
@@ -93,5 +95,34 @@ func (fp *FileParser) ReadSampleMultiThread() (error, float64, util.Pvector) {
 		return errors.New("[ReadSampleMultiThread] input value error"), 0., nil
 	}
 
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
+}
+
+//读取样本并返回行号，文件结束时行号为-1，解析失败时返回错误及行号
+func (fp *FileParser) ReadSampleWithSeq() (error, int64, float64, util.Pvector) {
+	if fp.Bufio == nil {
+		return errors.New("[ReadSampleWithSeq] bufio initialize error."), -1, 0., nil
+	}
+
+	fp.Lock.Lock()
+	line, err := fp.Bufio.ReadString('\n')
+	seq := fp.Lines
+	if err == nil || len(line) != 0 {
+		fp.Lines++
+	}
+
+	//字典可增长时在锁内解析，新特征名按行的顺序分配下标，与线程数和调度无关
+	if fp.Dict != nil && fp.Dict.Grow && (err == nil || (err == io.EOF && len(line) != 0)) {
+		res, y, x := util.ParseSampleWithDict(s.TrimSpace(line), fp.Dict)
+		fp.Lock.Unlock()
+		return res, seq, y, x
+	}
+	fp.Lock.Unlock()
+
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return err, -1, 0., nil
+	}
+
+	res, y, x := util.ParseSampleWithDict(s.TrimSpace(line), fp.Dict)
+	return res, seq, y, x
 }
@@ -3,18 +3,14 @@ package trainer
 import (
 	"errors"
 	"fmt"
-	"goline/deps/log4go"
 	"goline/solver"
 	"goline/util"
+	"io"
 )
 
 type FtrlTrainer struct {
-	Epoch           int
-	CacheFeatureNum bool
-	Solver          solver.FtrlSolver
-	Init            bool
-	JobName         string
-	log             log4go.Logger
+	trainer_base
+	Solver solver.FtrlSolver
 }
 
 func (ft *FtrlTrainer) SetJobName(name string) {
@@ -25,6 +21,10 @@ func (ft *FtrlTrainer) SetJobName(name string) {
 	}
 }
 
+func (ft *FtrlTrainer) Model() *solver.FtrlSolver {
+	return &ft.Solver
+}
+
 func (ft *FtrlTrainer) Initialize(epoch int, cache_feature_num bool) bool {
 	ft.Epoch = epoch
 	ft.CacheFeatureNum = cache_feature_num
@@ -48,16 +48,41 @@ func (ft *FtrlTrainer) Train(
 		return errors.New("[FtrlTrainer-Train] Fast ftrl trainer initialize error.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, ft.CacheFeatureNum, 0)
+	feat_num, line_cnt, _ := read_problem_info(ft.context(), train_file, ft.CacheFeatureNum, 0, ft.Dict)
+	if err := ft.check_canceled(0, 0); err != nil {
+		ft.log.Error("[FtrlTrainer-Train] " + err.Error())
+		return err
+	}
+
 	if feat_num == 0 {
 		ft.log.Error("[FtrlTrainer-Train] The number of features is zero.")
 		return errors.New("[FtrlTrainer-Train] The number of features is zero.")
 	}
 
+	preprocess, feat_num, err := build_feature_preprocess(ft.context(), ft.Preprocess, train_file, feat_num, 0, ft.Dict)
+	if cerr := ft.check_canceled(0, 0); cerr != nil {
+		ft.log.Error("[FtrlTrainer-Train] " + cerr.Error())
+		return cerr
+	}
+
+	if err != nil {
+		ft.log.Error(fmt.Sprintf("[FtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+	}
+
+	cross, feat_num, err := build_feature_cross(ft.Cross, feat_num)
+	if err != nil {
+		ft.log.Error(fmt.Sprintf("[FtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[FtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+	}
+
 	if !ft.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
 		ft.log.Error("[FtrlTrainer-Train] Solver initializing error.")
 		return errors.New("[FtrlTrainer-Train] Solver initializing error.")
 	}
+	ft.Solver.Preprocess = preprocess
+	ft.Solver.Cross = cross
+	ft.Solver.Dict = ft.Dict
 
 	return ft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
@@ -72,18 +97,38 @@ func (ft *FtrlTrainer) TrainRestore(
 		return errors.New("[FtrlTrainer-TrainRestore] Fast ftrl trainer restore error.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, ft.CacheFeatureNum, 0)
-	if feat_num == 0 {
-		ft.log.Error("[FtrlTrainer-TrainRestore] The number of features is zero.")
-		return errors.New("[FtrlTrainer-TrainRestore] The number of features is zero.")
-	}
-
 	err := ft.Solver.Construct(last_model)
 	if err != nil {
 		ft.log.Error(fmt.Sprintf("[FtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
 		return errors.New(fmt.Sprintf("[FtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
 	}
 
+	err = ft.restore_progress(&ft.Solver)
+	if err != nil {
+		ft.log.Error("[FtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[FtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	//优先使用模型中的特征字典，指定了不同的字典时按特征名重新映射
+	err = ft.restore_dict(&ft.Solver)
+	if err != nil {
+		ft.log.Error("[FtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[FtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(ft.context(), train_file, ft.CacheFeatureNum, 0, ft.Solver.Dict)
+	if err := ft.check_canceled(0, 0); err != nil {
+		ft.log.Error("[FtrlTrainer-TrainRestore] " + err.Error())
+		return err
+	}
+
+	if feat_num == 0 {
+		ft.log.Error("[FtrlTrainer-TrainRestore] The number of features is zero.")
+		return errors.New("[FtrlTrainer-TrainRestore] The number of features is zero.")
+	}
+
+	ft.grow_features(&ft.Solver, feat_num)
+
 	return ft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
 
@@ -97,65 +142,99 @@ func (ft *FtrlTrainer) TrainImpl(
 		return errors.New("[FtrlTrainer-TrainImpl] Fast ftrl trainer restore error.")
 	}
 
-	ft.log.Info(fmt.Sprintf("[%s] params={alpha:%.2f, beta:%.2f, l1:%.2f, l2:%.2f, dropout:%.2f, epoch:%d}\n",
-		ft.JobName,
-		ft.Solver.Alpha,
-		ft.Solver.Beta,
-		ft.Solver.L1,
-		ft.Solver.L2,
-		ft.Solver.Dropout,
-		ft.Epoch))
-
-	predict_func := func(x util.Pvector) float64 {
-		return ft.Solver.Predict(x)
-	}
-
-	var timer util.StopWatch
-	timer.StartTimer()
-	var last_time float64 = 0
-	for iter := 0; iter < ft.Epoch; iter++ {
-		var file_parser FileParser
-		file_parser.OpenFile(train_file)
-
-		cur_cnt := 0
-		last_cnt := 0
-		var loss float64 = 0
-		for {
-			flag, y, x := file_parser.ReadSample()
-			if flag != nil {
-				break
-			}
-
-			pred := ft.Solver.Update(x, y)
-			loss += calc_loss(y, pred)
-			cur_cnt++
-
-			if cur_cnt-last_cnt > 100000 && timer.StopTimer()-last_time > 0.5 {
-				ft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-					ft.JobName,
-					iter,
-					float64(cur_cnt*100)/float64(line_cnt),
-					timer.StopTimer(),
-					float64(loss)/float64(cur_cnt)))
-
-				last_cnt = cur_cnt
-				last_time = timer.StopTimer()
-			}
+	ops := train_ops{
+		workers:    1,
+		model_file: model_file,
+		model:      &ft.Solver,
+		open: func(epoch int) (SampleReader, error) {
+			return open_train_reader(train_file, 1, ft.Solver.Dict, read_mode(ft.CacheFeatureNum, ft.Mmap), ft.Shuffle, epoch)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			return ft.Solver.UpdateWithWeight(x, y, ft.SampleRates.Weight(y))
+		},
+		evaluate: ft.evaluator(test_file, &ft.Solver),
+		before_epoch: func(epoch int, reader SampleReader, resume bool) bool {
+			ft.Solver.SetRand(ft.dropout_rands(epoch, 1)[0])
+			return true
+		}}
+
+	err := ft.train_epochs(&ops, line_cnt)
+	if err != nil {
+		ft.log.Error("[FtrlTrainer-TrainImpl] " + err.Error())
+		if IsCanceled(err) {
+			return err
+		}
+
+		return errors.New("[FtrlTrainer-TrainImpl] " + err.Error())
+	}
+
+	err = ft.save_model(&ft.Solver, model_file)
+	if err != nil {
+		ft.log.Error("[FtrlTrainer-TrainImpl] Save model error." + err.Error())
+		return errors.New("[FtrlTrainer-TrainImpl] Save model error." + err.Error())
+	}
+
+	return nil
+}
+
+//从reader(标准输入、命名管道、socket等)单线程流式训练，数据只读一遍，按conf.Checkpoint定期保存模型
+func (ft *FtrlTrainer) TrainStream(
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	reader io.Reader,
+	conf StreamConfig) error {
+
+	if !ft.Init {
+		ft.log.Error("[FtrlTrainer-TrainStream] Ftrl trainer initialize error.")
+		return errors.New("[FtrlTrainer-TrainStream] Ftrl trainer initialize error.")
+	}
+
+	if len(conf.LastModel) != 0 {
+		err := ft.Solver.Construct(conf.LastModel)
+		if err != nil {
+			ft.log.Error(fmt.Sprintf("[FtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+		}
+
+		err = ft.restore_dict(&ft.Solver)
+		if err != nil {
+			ft.log.Error("[FtrlTrainer-TrainStream] " + err.Error())
+			return errors.New("[FtrlTrainer-TrainStream] " + err.Error())
 		}
-		ft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-			ft.JobName,
-			iter,
-			float64(cur_cnt*100)/float64(line_cnt),
-			timer.StopTimer(),
-			float64(loss)/float64(cur_cnt)))
-
-		file_parser.CloseFile()
-
-		if test_file != "" {
-			eval_loss := evaluate_file(test_file, predict_func, 0)
-			ft.log.Info(fmt.Sprintf("[%s] validation-loss=[%f]\n", float64(eval_loss)))
+
+		ft.grow_features(&ft.Solver, conf.FeatNum)
+	} else {
+		cross, feat_num, err := build_stream_features(ft.Preprocess, ft.Cross, conf.FeatNum)
+		if err != nil {
+			ft.log.Error(fmt.Sprintf("[FtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[FtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
 		}
+
+		if !ft.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
+			ft.log.Error("[FtrlTrainer-TrainStream] Solver initializing error.")
+			return errors.New("[FtrlTrainer-TrainStream] Solver initializing error.")
+		}
+		ft.Solver.Cross = cross
+		ft.Solver.Dict = ft.Dict
+	}
+
+	save_func := func() error {
+		return save_model_atomic(ft.Solver.SaveModel, model_file)
+	}
+
+	ft.Solver.SetRand(dropout_rand(ft.Seed, 0, 0))
+	_, err := ft.train_stream(reader, 1, &ft.Solver, conf,
+		func(i int, x util.Pvector, y float64) float64 {
+			return ft.Solver.UpdateWithWeight(x, y, ft.SampleRates.Weight(y))
+		}, save_func)
+	if err != nil {
+		ft.log.Error("[FtrlTrainer-TrainStream] " + err.Error())
+		return err
 	}
 
-	return ft.Solver.SaveModel(model_file)
+	return save_func()
 }
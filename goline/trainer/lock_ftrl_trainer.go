@@ -4,22 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"goline/deps/log4go"
 	"goline/solver"
 	"goline/util"
 	"io"
-	"math"
-	"sync"
+	"math/rand"
 )
 
 type LockFreeFtrlTrainer struct {
-	Epoch           int
-	CacheFeatureNum bool
-	Solver          solver.FtrlSolver
-	Init            bool
-	NumThreads      int
-	JobName         string
-	log             log4go.Logger
+	trainer_base
+	Solver solver.FtrlSolver
 }
 
 func (lft *LockFreeFtrlTrainer) SetJobName(name string) {
@@ -30,6 +23,10 @@ func (lft *LockFreeFtrlTrainer) SetJobName(name string) {
 	}
 }
 
+func (lft *LockFreeFtrlTrainer) Model() *solver.FtrlSolver {
+	return &lft.Solver
+}
+
 func (lft *LockFreeFtrlTrainer) Initialize(
 	epoch int,
 	num_threads int,
@@ -58,16 +55,41 @@ func (lft *LockFreeFtrlTrainer) Train(
 		return errors.New("[LockFreeFtrlTrainer-Train] Fast ftrl trainer initialize error.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, lft.CacheFeatureNum, lft.NumThreads)
+	feat_num, line_cnt, _ := read_problem_info(lft.context(), train_file, lft.CacheFeatureNum, lft.NumThreads, lft.Dict)
+	if err := lft.check_canceled(0, 0); err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-Train] " + err.Error())
+		return err
+	}
+
 	if feat_num == 0 {
 		lft.log.Error("[LockFreeFtrlTrainer-Train] The number of features is zero.")
 		return errors.New("[LockFreeFtrlTrainer-Train] The number of features is zero.")
 	}
 
+	preprocess, feat_num, err := build_feature_preprocess(lft.context(), lft.Preprocess, train_file, feat_num, lft.NumThreads, lft.Dict)
+	if cerr := lft.check_canceled(0, 0); cerr != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-Train] " + cerr.Error())
+		return cerr
+	}
+
+	if err != nil {
+		lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+	}
+
+	cross, feat_num, err := build_feature_cross(lft.Cross, feat_num)
+	if err != nil {
+		lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+	}
+
 	if !lft.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
 		lft.log.Info("[LockFreeFtrlTrainer-Train] Solver initializing error.")
 		return errors.New("[LockFreeFtrlTrainer-Train] Solver initializing error.")
 	}
+	lft.Solver.Preprocess = preprocess
+	lft.Solver.Cross = cross
+	lft.Solver.Dict = lft.Dict
 
 	return lft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
@@ -82,17 +104,37 @@ func (lft *LockFreeFtrlTrainer) TrainRestore(
 		return errors.New("[LockFreeFtrlTrainer-TrainRestore] Fast ftrl trainer restore error.")
 	}
 
-	feat_num, line_cnt, _ := read_problem_info(train_file, lft.CacheFeatureNum, lft.NumThreads)
+	err := lft.Solver.Construct(last_model)
+	if err != nil {
+		lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
+	}
+
+	err = lft.restore_progress(&lft.Solver)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[LockFreeFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	//优先使用模型中的特征字典，指定了不同的字典时按特征名重新映射
+	err = lft.restore_dict(&lft.Solver)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[LockFreeFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(lft.context(), train_file, lft.CacheFeatureNum, lft.NumThreads, lft.Solver.Dict)
+	if err := lft.check_canceled(0, 0); err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainRestore] " + err.Error())
+		return err
+	}
+
 	if feat_num == 0 {
 		lft.log.Error("[LockFreeFtrlTrainer-TrainRestore] The number of features is zero.")
 		return errors.New("[LockFreeFtrlTrainer-TrainRestore] The number of features is zero.")
 	}
 
-	err := lft.Solver.Construct(last_model)
-	if err != nil {
-		lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-TrainRestore] Solver restore error.", err.Error()))
-		return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-TrainRestore] Solver restore error.", err.Error()))
-	}
+	lft.grow_features(&lft.Solver, feat_num)
 
 	return lft.TrainImpl(model_file, train_file, line_cnt, test_file)
 }
@@ -107,79 +149,41 @@ func (lft *LockFreeFtrlTrainer) TrainImpl(
 		return errors.New("[LockFreeFtrlTrainer-TrainImpl] Fast ftrl trainer restore error.")
 	}
 
-	lft.log.Info(fmt.Sprintf("[%s] params={alpha:%.2f, beta:%.2f, l1:%.2f, l2:%.2f, dropout:%.2f, epoch:%d}\n",
-		lft.JobName,
-		lft.Solver.Alpha,
-		lft.Solver.Beta,
-		lft.Solver.L1,
-		lft.Solver.L2,
-		lft.Solver.Dropout,
-		lft.Epoch))
-
-	predict_func := func(x util.Pvector) float64 {
-		return lft.Solver.Predict(x)
-	}
-
-	var timer util.StopWatch
-	timer.StartTimer()
-	for iter := 0; iter < lft.Epoch; iter++ {
-		var file_parser FileParser
-		file_parser.OpenFile(train_file)
-
-		count := 0
-		var loss float64 = 0
-
-		var lock sync.Mutex
-
-		worker_func := func(i int, c *sync.WaitGroup) {
-			local_count := 0
-			var local_loss float64 = 0
-			for {
-				flag, y, x := file_parser.ReadSampleMultiThread()
-				if flag != nil {
-					break
-				}
-
-				pred := lft.Solver.Update(x, y)
-				local_loss += calc_loss(y, pred)
-				local_count++
-
-				if i == 0 && local_count%10000 == 0 {
-					tmp_cnt := math.Min(float64(local_count*lft.NumThreads), float64(line_cnt))
-					lft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-						lft.JobName,
-						iter,
-						float64(tmp_cnt*100)/float64(line_cnt),
-						timer.StopTimer(),
-						float64(local_loss)/float64(local_count)))
-				}
-			}
-
-			lock.Lock()
-			count += local_count
-			loss += local_loss
-			lock.Unlock()
-			defer c.Done()
+	//各线程共享模型，dropout使用各自的随机数
+	var rands []*rand.Rand
+	ops := train_ops{
+		workers:    lft.NumThreads,
+		model_file: model_file,
+		model:      &lft.Solver,
+		open: func(epoch int) (SampleReader, error) {
+			return open_train_reader(train_file, lft.NumThreads, lft.Solver.Dict, read_mode(lft.CacheFeatureNum, lft.Mmap), lft.Shuffle, epoch)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			return lft.Solver.UpdateWithRand(x, y, lft.SampleRates.Weight(y), rands[i])
+		},
+		evaluate: lft.evaluator(test_file, &lft.Solver),
+		before_epoch: func(epoch int, reader SampleReader, resume bool) bool {
+			rands = lft.dropout_rands(epoch, lft.NumThreads)
+			return true
+		}}
+
+	err := lft.train_epochs(&ops, line_cnt)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainImpl] " + err.Error())
+		if IsCanceled(err) {
+			return err
 		}
 
-		util.UtilParallelRun(worker_func, lft.NumThreads)
-
-		file_parser.CloseFile()
-
-		lft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-			lft.JobName,
-			iter,
-			float64(count*100)/float64(line_cnt),
-			timer.StopTimer(),
-			float64(loss)/float64(count)))
+		return errors.New("[LockFreeFtrlTrainer-TrainImpl] " + err.Error())
+	}
 
-		if test_file != "" {
-			eval_loss := evaluate_file(test_file, predict_func, 0)
-			lft.log.Info(fmt.Sprintf("[%s] validation-loss=[%f]\n", lft.JobName, float64(eval_loss)))
-		}
+	err = lft.save_model(&lft.Solver, model_file)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainImpl] Save model error." + err.Error())
+		return errors.New("[LockFreeFtrlTrainer-TrainImpl] Save model error." + err.Error())
 	}
 
-	return lft.Solver.SaveModel(model_file)
+	return nil
 }
 
 func (lft *LockFreeFtrlTrainer) TrainBatch(
@@ -201,74 +205,30 @@ func (lft *LockFreeFtrlTrainer) TrainBatch(
 
 	lft.Solver = fls
 
-	lft.log.Info(fmt.Sprintf("[%s] params={alpha:%.2f, beta:%.2f, l1:%.2f, l2:%.2f, dropout:%.2f, epoch:%d}\n",
-		lft.JobName,
-		lft.Solver.Alpha,
-		lft.Solver.Beta,
-		lft.Solver.L1,
-		lft.Solver.L2,
-		lft.Solver.Dropout,
-		lft.Epoch))
-
-	predict_func := func(x util.Pvector) float64 {
-		return lft.Solver.Predict(x)
-	}
-
-	var timer util.StopWatch
-	timer.StartTimer()
-	for iter := 0; iter < lft.Epoch; iter++ {
-		var stream_parser StreamParser
-		stream_parser.Open(instances)
-
-		count := 0
-		var loss float64 = 0
-
-		var lock sync.Mutex
-
-		worker_func := func(i int, c *sync.WaitGroup) {
-			local_count := 0
-			var local_loss float64 = 0
-			for {
-				flag, y, x := stream_parser.ReadSampleMultiThread()
-				if flag != nil {
-					break
-				}
-
-				pred := lft.Solver.Update(x, y)
-				local_loss += calc_loss(y, pred)
-				local_count++
-
-				if i == 0 && local_count%10000 == 0 {
-					tmp_cnt := math.Min(float64(local_count*lft.NumThreads), float64(line_cnt))
-					lft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-						lft.JobName,
-						iter,
-						float64(tmp_cnt*100)/float64(line_cnt),
-						timer.StopTimer(),
-						float64(local_loss)/float64(local_count)))
-				}
-			}
-
-			lock.Lock()
-			count += local_count
-			loss += local_loss
-			lock.Unlock()
-			defer c.Done()
-		}
-
-		util.UtilParallelRun(worker_func, lft.NumThreads)
-
-		stream_parser.Close()
-
-		lft.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f]\n",
-			lft.JobName,
-			iter,
-			float64(count*100)/float64(line_cnt),
-			timer.StopTimer(),
-			float64(loss)/float64(count)))
-
-		eval_loss := evaluate_stream(instances, predict_func, 0)
-		lft.log.Info(fmt.Sprintf("[%s] validation-loss=[%f]\n", lft.JobName, float64(eval_loss)))
+	var rands []*rand.Rand
+	ops := train_ops{
+		workers: lft.NumThreads,
+		model:   &lft.Solver,
+		open: func(epoch int) (SampleReader, error) {
+			var reader stream_reader
+			reader.Dict = lft.Solver.Dict
+			return &reader, reader.Open(instances)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			return lft.Solver.UpdateWithRand(x, y, lft.SampleRates.Weight(y), rands[i])
+		},
+		evaluate: func() EvalResult {
+			return evaluate_stream(lft.context(), instances, lft.Solver.Predict, 0, lft.Solver.Dict)
+		},
+		before_epoch: func(epoch int, reader SampleReader, resume bool) bool {
+			rands = lft.dropout_rands(epoch, lft.NumThreads)
+			return true
+		}}
+
+	err = lft.train_epochs(&ops, line_cnt)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainBatch] " + err.Error())
+		return errors.New("[LockFreeFtrlTrainer-TrainBatch] " + err.Error())
 	}
 
 	return nil
@@ -287,6 +247,24 @@ func (lft *LockFreeFtrlTrainer) TrainOnline(
 	return lft.Solver.SaveEncodeModel()
 }
 
+//在线学习并返回每轮先预测后更新的loss和AUC，第0轮的样本在更新前未被模型见过，即该批样本的progressive validation
+func (lft *LockFreeFtrlTrainer) TrainOnlineWithMetrics(
+	encodemodel string,
+	instances []string) (string, []ProgressiveResult, error) {
+
+	var results []ProgressiveResult
+	n := len(lft.Callbacks)
+	defer func() { lft.Callbacks = lft.Callbacks[:n] }()
+
+	lft.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+		results = append(results, progressive_result(state))
+		return nil
+	}})
+
+	model, err := lft.TrainOnline(encodemodel, instances)
+	return model, results, err
+}
+
 func (lft *LockFreeFtrlTrainer) TrainOnlineAndDump(
 	w io.Writer,
 	encodemodel string,
@@ -294,11 +272,91 @@ func (lft *LockFreeFtrlTrainer) TrainOnlineAndDump(
 	path string,
 	f func(w io.Writer, format string, a ...interface{}) (int, error)) error {
 
+	//每轮训练结果通过f写入w，如fmt.Fprintf
+	if w != nil && f != nil {
+		n := len(lft.Callbacks)
+		defer func() { lft.Callbacks = lft.Callbacks[:n] }()
+
+		lft.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+			f(w, "[%s] epoch=%d processed=[%d] time=[%.2f] train-loss=[%.6f] train-auc=[%.6f] validation-loss=[%.6f]\n",
+				state.JobName,
+				state.Epoch,
+				state.Processed,
+				state.Time,
+				state.TrainLoss,
+				state.TrainAUC,
+				state.EvalLoss)
+			return nil
+		}})
+	}
+
 	err := lft.TrainBatch(encodemodel, instances)
 	if err != nil {
-		lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-TrainOnlineAndDump] Online learning failed.", err.Error()))
-		return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-TrainOnlineAndDump] Online learning failed.", err.Error()))
+		lft.log.Error("[LockFreeFtrlTrainer-TrainOnlineAndDump] Online learning failed." + err.Error())
+		return errors.New("[LockFreeFtrlTrainer-TrainOnlineAndDump] Online learning failed." + err.Error())
 	}
 
 	return lft.Solver.SaveModel(path)
 }
+
+//从reader(标准输入、命名管道、socket等)流式训练，数据只读一遍，按conf.Checkpoint定期保存模型
+func (lft *LockFreeFtrlTrainer) TrainStream(
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	reader io.Reader,
+	conf StreamConfig) error {
+
+	if !lft.Init {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainStream] Lock free ftrl trainer initialize error.")
+		return errors.New("[LockFreeFtrlTrainer-TrainStream] Lock free ftrl trainer initialize error.")
+	}
+
+	if len(conf.LastModel) != 0 {
+		err := lft.Solver.Construct(conf.LastModel)
+		if err != nil {
+			lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+		}
+
+		err = lft.restore_dict(&lft.Solver)
+		if err != nil {
+			lft.log.Error("[LockFreeFtrlTrainer-TrainStream] " + err.Error())
+			return errors.New("[LockFreeFtrlTrainer-TrainStream] " + err.Error())
+		}
+
+		lft.grow_features(&lft.Solver, conf.FeatNum)
+	} else {
+		cross, feat_num, err := build_stream_features(lft.Preprocess, lft.Cross, conf.FeatNum)
+		if err != nil {
+			lft.log.Error(fmt.Sprintf("[LockFreeFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[LockFreeFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+		}
+
+		if !lft.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
+			lft.log.Error("[LockFreeFtrlTrainer-TrainStream] Solver initializing error.")
+			return errors.New("[LockFreeFtrlTrainer-TrainStream] Solver initializing error.")
+		}
+		lft.Solver.Cross = cross
+		lft.Solver.Dict = lft.Dict
+	}
+
+	save_func := func() error {
+		return save_model_atomic(lft.Solver.SaveModel, model_file)
+	}
+
+	rands := lft.dropout_rands(0, lft.NumThreads)
+	_, err := lft.train_stream(reader, lft.NumThreads, &lft.Solver, conf,
+		func(i int, x util.Pvector, y float64) float64 {
+			return lft.Solver.UpdateWithRand(x, y, lft.SampleRates.Weight(y), rands[i])
+		}, save_func)
+	if err != nil {
+		lft.log.Error("[LockFreeFtrlTrainer-TrainStream] " + err.Error())
+		return err
+	}
+
+	return save_func()
+}
@@ -12,6 +12,7 @@ type MemoryFileParser struct {
 	mindex []int
 	lock   []sync.Mutex
 	length int
+	Dict   *util.FeatureDict
 }
 
 func (fp *MemoryFileParser) OpenFile(filename string, threadnum int) error {
@@ -78,7 +79,7 @@ func (fp *MemoryFileParser) ReadSample(i int) (error, float64, util.Pvector) {
 	}
 	fp.mindex[i]++
 	fp.lock[i].Unlock()
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
 }
 
 func (fp *MemoryFileParser) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
@@ -92,5 +93,5 @@ func (fp *MemoryFileParser) ReadSampleMultiThread(i int) (error, float64, util.P
 	//	fmt.Print("time is:")
 	//	fmt.Println(timer.StopTimer())
 
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
 }
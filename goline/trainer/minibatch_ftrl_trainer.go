@@ -0,0 +1,310 @@
+package trainer
+
+import (
+	"errors"
+	"fmt"
+	"goline/solver"
+	"goline/util"
+	"io"
+	"runtime"
+)
+
+const (
+	DefaultBatchSize = 1000
+)
+
+//同步小批量ftrl：各线程每读BatchSize个样本，用批开始时的参数预估并累加梯度，全部线程到达屏障后
+//参数服务器按线程顺序汇总梯度，对每个维度做一次ftrl更新。与FastFtrlTrainer按参数分组异步交换不同，
+//每批内所有线程看到相同的参数，相同的数据、线程数和Seed得到相同的模型
+type MiniBatchFtrlTrainer struct {
+	trainer_base
+	BatchSize int //每个线程每批的样本数
+
+	Solver solver.FtrlSolver
+}
+
+func (mbt *MiniBatchFtrlTrainer) SetJobName(name string) {
+
+	mbt.JobName = "minibatchftrljob"
+	if name != "" {
+		mbt.JobName = name
+	}
+}
+
+func (mbt *MiniBatchFtrlTrainer) Model() *solver.FtrlSolver {
+	return &mbt.Solver
+}
+
+//batch_size为0时使用默认值
+func (mbt *MiniBatchFtrlTrainer) Initialize(
+	epoch int,
+	num_threads int,
+	cache_feature_num bool,
+	batch_size int) bool {
+	mbt.Epoch = epoch
+	mbt.CacheFeatureNum = cache_feature_num
+	mbt.NumThreads = num_threads
+	if num_threads == 0 {
+		mbt.NumThreads = runtime.NumCPU()
+	}
+
+	mbt.BatchSize = batch_size
+	if batch_size <= 0 {
+		mbt.BatchSize = DefaultBatchSize
+	}
+
+	mbt.log = util.GetLogger()
+	mbt.Init = true
+	return mbt.Init
+}
+
+//预扫描单线程时特征字典的下标分配和预处理的拟合与线程调度无关
+func (mbt *MiniBatchFtrlTrainer) scan_threads() int {
+	if mbt.Dict != nil || mbt.Solver.Dict != nil || mbt.Preprocess != nil {
+		return 1
+	}
+
+	return mbt.NumThreads
+}
+
+func (mbt *MiniBatchFtrlTrainer) Train(
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	train_file string,
+	test_file string) error {
+
+	if !mbt.Init {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] Mini-batch ftrl trainer initialize error.")
+		return errors.New("[MiniBatchFtrlTrainer-Train] Mini-batch ftrl trainer initialize error.")
+	}
+
+	if !util.FileExists(train_file) || (len(test_file) != 0 && !util.FileExists(test_file)) {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] Train file or test file is not exist.")
+		return errors.New("[MiniBatchFtrlTrainer-Train] Train file or test file is not exist.")
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(mbt.context(), train_file, mbt.CacheFeatureNum, mbt.scan_threads(), mbt.Dict)
+	if err := mbt.check_canceled(0, 0); err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] " + err.Error())
+		return err
+	}
+
+	if feat_num == 0 {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] The number of features is zero.")
+		return errors.New("[MiniBatchFtrlTrainer-Train] The number of features is zero.")
+	}
+
+	preprocess, feat_num, err := build_feature_preprocess(mbt.context(), mbt.Preprocess, train_file, feat_num, mbt.scan_threads(), mbt.Dict)
+	if cerr := mbt.check_canceled(0, 0); cerr != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] " + cerr.Error())
+		return cerr
+	}
+
+	if err != nil {
+		mbt.log.Error(fmt.Sprintf("[MiniBatchFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[MiniBatchFtrlTrainer-Train] Feature preprocess initializing error.%s", err.Error()))
+	}
+
+	cross, feat_num, err := build_feature_cross(mbt.Cross, feat_num)
+	if err != nil {
+		mbt.log.Error(fmt.Sprintf("[MiniBatchFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[MiniBatchFtrlTrainer-Train] Feature cross initializing error.%s", err.Error()))
+	}
+
+	if !mbt.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
+		mbt.log.Error("[MiniBatchFtrlTrainer-Train] Solver initializing error.")
+		return errors.New("[MiniBatchFtrlTrainer-Train] Solver initializing error.")
+	}
+	mbt.Solver.Preprocess = preprocess
+	mbt.Solver.Cross = cross
+	mbt.Solver.Dict = mbt.Dict
+
+	return mbt.TrainImpl(model_file, train_file, line_cnt, test_file)
+}
+
+func (mbt *MiniBatchFtrlTrainer) TrainRestore(
+	last_model string,
+	model_file string,
+	train_file string,
+	test_file string) error {
+
+	if !mbt.Init {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainRestore] Mini-batch ftrl trainer restore error.")
+		return errors.New("[MiniBatchFtrlTrainer-TrainRestore] Mini-batch ftrl trainer restore error.")
+	}
+
+	err := mbt.Solver.Construct(last_model)
+	if err != nil {
+		mbt.log.Error(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
+		return errors.New(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainRestore] Solver restore error.%s", err.Error()))
+	}
+
+	err = mbt.restore_progress(&mbt.Solver)
+	if err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[MiniBatchFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	//优先使用模型中的特征字典，指定了不同的字典时按特征名重新映射
+	err = mbt.restore_dict(&mbt.Solver)
+	if err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainRestore] " + err.Error())
+		return errors.New("[MiniBatchFtrlTrainer-TrainRestore] " + err.Error())
+	}
+
+	feat_num, line_cnt, _ := read_problem_info(mbt.context(), train_file, mbt.CacheFeatureNum, mbt.scan_threads(), mbt.Solver.Dict)
+	if err := mbt.check_canceled(0, 0); err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainRestore] " + err.Error())
+		return err
+	}
+
+	if feat_num == 0 {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainRestore] The number of features is zero.")
+		return errors.New("[MiniBatchFtrlTrainer-TrainRestore] The number of features is zero.")
+	}
+
+	mbt.grow_features(&mbt.Solver, feat_num)
+
+	return mbt.TrainImpl(model_file, train_file, line_cnt, test_file)
+}
+
+func (mbt *MiniBatchFtrlTrainer) new_workers(n int) []solver.MiniBatchWorker {
+	workers := make([]solver.MiniBatchWorker, n)
+	for i := 0; i < n; i++ {
+		workers[i].Initialize(&mbt.Solver)
+	}
+
+	return workers
+}
+
+func (mbt *MiniBatchFtrlTrainer) TrainImpl(
+	model_file string,
+	train_file string,
+	line_cnt int,
+	test_file string) error {
+
+	if !mbt.Init {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainImpl] Mini-batch ftrl trainer restore error.")
+		return errors.New("[MiniBatchFtrlTrainer-TrainImpl] Mini-batch ftrl trainer restore error.")
+	}
+
+	workers := mbt.new_workers(mbt.NumThreads)
+	ops := train_ops{
+		workers:    mbt.NumThreads,
+		model_file: model_file,
+		model:      &mbt.Solver,
+		open: func(epoch int) (SampleReader, error) {
+			return open_partitioned_reader(train_file, mbt.NumThreads, mbt.Solver.Dict, mbt.CacheFeatureNum, mbt.Mmap, mbt.Shuffle, epoch)
+		},
+		update: func(i int, x util.Pvector, y float64) float64 {
+			return workers[i].UpdateWithWeight(x, y, mbt.SampleRates.Weight(y), &mbt.Solver)
+		},
+		evaluate: mbt.evaluator(test_file, &mbt.Solver),
+		before_epoch: func(epoch int, reader SampleReader, resume bool) bool {
+			for i, rd := range mbt.dropout_rands(epoch, mbt.NumThreads) {
+				workers[i].SetRand(rd)
+			}
+
+			return true
+		},
+		sync_step: mbt.BatchSize,
+		sync: func() {
+			mbt.Solver.ApplyGradients(workers)
+		}}
+
+	err := mbt.train_epochs(&ops, line_cnt)
+	if err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainImpl] " + err.Error())
+		if IsCanceled(err) {
+			return err
+		}
+
+		return errors.New("[MiniBatchFtrlTrainer-TrainImpl] " + err.Error())
+	}
+
+	mbt.Solver.SetMeta("BatchSize", fmt.Sprintf("%d", mbt.BatchSize))
+	err = mbt.save_model(&mbt.Solver, model_file)
+	if err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainImpl] Save model error." + err.Error())
+		return errors.New("[MiniBatchFtrlTrainer-TrainImpl] Save model error." + err.Error())
+	}
+
+	return nil
+}
+
+//从reader(标准输入、命名管道、socket等)流式训练，数据只读一遍，单线程每读BatchSize个样本更新一次参数
+func (mbt *MiniBatchFtrlTrainer) TrainStream(
+	alpha float64,
+	beta float64,
+	l1 float64,
+	l2 float64,
+	dropout float64,
+	model_file string,
+	reader io.Reader,
+	conf StreamConfig) error {
+
+	if !mbt.Init {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainStream] Mini-batch ftrl trainer initialize error.")
+		return errors.New("[MiniBatchFtrlTrainer-TrainStream] Mini-batch ftrl trainer initialize error.")
+	}
+
+	if len(conf.LastModel) != 0 {
+		err := mbt.Solver.Construct(conf.LastModel)
+		if err != nil {
+			mbt.log.Error(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainStream] Solver restore error.%s", err.Error()))
+		}
+
+		err = mbt.restore_dict(&mbt.Solver)
+		if err != nil {
+			mbt.log.Error("[MiniBatchFtrlTrainer-TrainStream] " + err.Error())
+			return errors.New("[MiniBatchFtrlTrainer-TrainStream] " + err.Error())
+		}
+
+		mbt.grow_features(&mbt.Solver, conf.FeatNum)
+	} else {
+		cross, feat_num, err := build_stream_features(mbt.Preprocess, mbt.Cross, conf.FeatNum)
+		if err != nil {
+			mbt.log.Error(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+			return errors.New(fmt.Sprintf("[MiniBatchFtrlTrainer-TrainStream] Feature initializing error.%s", err.Error()))
+		}
+
+		if !mbt.Solver.Initialize(alpha, beta, l1, l2, feat_num, dropout) {
+			mbt.log.Error("[MiniBatchFtrlTrainer-TrainStream] Solver initializing error.")
+			return errors.New("[MiniBatchFtrlTrainer-TrainStream] Solver initializing error.")
+		}
+		mbt.Solver.Cross = cross
+		mbt.Solver.Dict = mbt.Dict
+	}
+
+	workers := mbt.new_workers(1)
+	workers[0].SetRand(dropout_rand(mbt.Seed, 0, 0))
+
+	//检查点与训练在同一线程中调用，保存前先应用未满一批的梯度
+	save_func := func() error {
+		mbt.Solver.ApplyGradients(workers)
+		return save_model_atomic(mbt.Solver.SaveModel, model_file)
+	}
+
+	count := 0
+	_, err := mbt.train_stream(reader, 1, &mbt.Solver, conf,
+		func(i int, x util.Pvector, y float64) float64 {
+			pred := workers[0].UpdateWithWeight(x, y, mbt.SampleRates.Weight(y), &mbt.Solver)
+			count++
+			if count%mbt.BatchSize == 0 {
+				mbt.Solver.ApplyGradients(workers)
+			}
+
+			return pred
+		}, save_func)
+	if err != nil {
+		mbt.log.Error("[MiniBatchFtrlTrainer-TrainStream] " + err.Error())
+		return err
+	}
+
+	return save_func()
+}
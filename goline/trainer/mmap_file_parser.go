@@ -0,0 +1,224 @@
+package trainer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"goline/util"
+	"math/rand"
+	"unsafe"
+)
+
+const (
+	MmapBlockBytes = 1 << 22 //打乱顺序时文本数据块的大小
+)
+
+//文本数据块为字节区间[start, end)，缓存数据块为起始偏移及样本数
+type mmap_block struct {
+	start int
+	end   int
+	count int
+}
+
+//基于内存映射的样本读取，支持libsvm文本和二进制缓存，按线程划分数据，行数据不做拷贝
+//Rand不为nil时打乱数据块顺序
+type MmapFileParser struct {
+	Dict *util.FeatureDict
+	Rand *rand.Rand
+
+	data    []byte
+	cache   bool
+	blocks  []mmap_block
+	assign  [][]int
+	cur     []int
+	pos     []int
+	end     []int
+	left    []int
+	readers []*bytes.Reader
+}
+
+func (mp *MmapFileParser) open(threadnum int) {
+	mp.assign = assign_blocks(len(mp.blocks), threadnum, mp.Rand)
+	mp.cur = make([]int, threadnum)
+	mp.pos = make([]int, threadnum)
+	mp.end = make([]int, threadnum)
+	mp.left = make([]int, threadnum)
+	mp.readers = make([]*bytes.Reader, threadnum)
+	for i := 0; i < threadnum; i++ {
+		mp.cur[i] = -1
+		mp.next_block(i)
+	}
+}
+
+//映射文本文件，按字节划分数据块后对齐到行首，不打乱顺序时每个线程一个数据块
+func (mp *MmapFileParser) OpenFile(filename string, threadnum int) error {
+	data, err := mmap_file(filename)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[MmapFileParser-OpenFile] Map file failed.%s", err.Error()))
+	}
+
+	nblocks := threadnum
+	if mp.Rand != nil {
+		nblocks = util.MaxInt(threadnum, len(data)/MmapBlockBytes)
+	}
+
+	bound := func(k int) int {
+		if k == 0 {
+			return 0
+		}
+
+		if k >= nblocks {
+			return len(data)
+		}
+
+		p := k * len(data) / nblocks
+		n := bytes.IndexByte(data[p:], '\n')
+		if n < 0 {
+			return len(data)
+		}
+
+		return p + n + 1
+	}
+
+	mp.data = data
+	mp.cache = false
+	mp.blocks = make([]mmap_block, nblocks)
+	for k := 0; k < nblocks; k++ {
+		mp.blocks[k].start = bound(k)
+		mp.blocks[k].end = util.MaxInt(mp.blocks[k].start, bound(k+1))
+	}
+
+	mp.open(threadnum)
+	return nil
+}
+
+//映射二进制缓存，按数据块划分给各线程
+func (mp *MmapFileParser) OpenCache(cache *SampleCache, threadnum int) error {
+	data, err := mmap_file(cache.Path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[MmapFileParser-OpenCache] Map file failed.%s", err.Error()))
+	}
+
+	mp.data = data
+	mp.cache = true
+	mp.blocks = make([]mmap_block, len(cache.Blocks))
+	for k := 0; k < len(cache.Blocks); k++ {
+		if cache.Blocks[k].Offset >= int64(len(data)) {
+			mp.CloseFile(threadnum)
+			return errors.New("[MmapFileParser-OpenCache] Cache file format error.")
+		}
+
+		mp.blocks[k] = mmap_block{start: int(cache.Blocks[k].Offset), count: cache.Blocks[k].Count}
+	}
+
+	mp.open(threadnum)
+	return nil
+}
+
+//切换到线程i的下一个数据块，没有更多数据块时返回false
+func (mp *MmapFileParser) next_block(i int) bool {
+	mp.cur[i]++
+	if mp.cur[i] >= len(mp.assign[i]) {
+		mp.pos[i], mp.end[i], mp.left[i] = 0, 0, 0
+		return false
+	}
+
+	blk := mp.blocks[mp.assign[i][mp.cur[i]]]
+	if mp.cache {
+		mp.readers[i] = bytes.NewReader(mp.data[blk.start:])
+		mp.left[i] = blk.count
+	} else {
+		mp.pos[i] = blk.start
+		mp.end[i] = blk.end
+	}
+
+	return true
+}
+
+func (mp *MmapFileParser) CloseFile(threadnum int) bool {
+	err := munmap_file(mp.data)
+	mp.data = nil
+	mp.blocks = nil
+	mp.assign = nil
+	mp.readers = nil
+	mp.cur = nil
+	return err == nil
+}
+
+//返回线程i的下一行(去除首尾空白)，切片直接引用映射内存，关闭后不可再使用
+func (mp *MmapFileParser) ReadLine(i int) []byte {
+	if i >= len(mp.cur) || mp.cache {
+		return nil
+	}
+
+	for {
+		for mp.pos[i] < mp.end[i] {
+			line := mp.data[mp.pos[i]:mp.end[i]]
+			k := bytes.IndexByte(line, '\n')
+			if k >= 0 {
+				line = line[:k]
+				mp.pos[i] += k + 1
+			} else {
+				mp.pos[i] = mp.end[i]
+			}
+
+			line = bytes.TrimSpace(line)
+			if len(line) != 0 {
+				return line
+			}
+		}
+
+		if !mp.next_block(i) {
+			return nil
+		}
+	}
+}
+
+//解析失败的行直接跳过，读完时返回错误
+func (mp *MmapFileParser) ReadSample(i int) (error, float64, util.Pvector) {
+	if i >= len(mp.cur) {
+		return errors.New("[MmapFileParser-ReadSample] end of file."), 0., nil
+	}
+
+	if mp.cache {
+		for mp.left[i] <= 0 {
+			if !mp.next_block(i) {
+				return errors.New("[MmapFileParser-ReadSample] end of cache."), 0., nil
+			}
+		}
+
+		y, x, err := decode_sample(mp.readers[i])
+		if err != nil {
+			mp.cur[i] = len(mp.assign[i])
+			mp.left[i] = 0
+			return errors.New("[MmapFileParser-ReadSample] read cache failed." + err.Error()), 0., nil
+		}
+
+		mp.left[i]--
+		return nil, y, x
+	}
+
+	for {
+		line := mp.ReadLine(i)
+		if line == nil {
+			return errors.New("[MmapFileParser-ReadSample] end of file."), 0., nil
+		}
+
+		//字典可增长时特征名会被保存，需要拷贝
+		var buf string
+		if mp.Dict != nil && mp.Dict.Grow {
+			buf = string(line)
+		} else {
+			buf = *(*string)(unsafe.Pointer(&line))
+		}
+
+		res, y, x := util.ParseSampleWithDict(buf, mp.Dict)
+		if res == nil {
+			return nil, y, x
+		}
+	}
+}
+
+func (mp *MmapFileParser) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	return mp.ReadSample(i)
+}
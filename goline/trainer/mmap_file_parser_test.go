@@ -0,0 +1,111 @@
+package trainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//第i行为 label 1:1 (1000+i):1，用第二个特征的下标标识行号
+func write_numbered_file(t *testing.T, path string, lines int) {
+	var text strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&text, "%d 1:1 %d:1\n\n", i%2, 1000+i)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(text.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//依次读完各线程的数据，返回每个线程读到的行号
+func read_line_ids(t *testing.T, reader SampleReader, threads int) [][]int {
+	ids := make([][]int, threads)
+	for i := 0; i < threads; i++ {
+		for {
+			err, y, x := reader.ReadSampleMultiThread(i)
+			if err != nil {
+				break
+			}
+
+			if len(x) != 3 || y != float64((x[2].Index-1000)%2) {
+				t.Fatalf("thread %d: y=%g x=%v", i, y, x)
+			}
+			ids[i] = append(ids[i], x[2].Index-1000)
+		}
+	}
+
+	return ids
+}
+
+func check_all_lines(t *testing.T, ids [][]int, lines int) {
+	var all []int
+	for i := 0; i < len(ids); i++ {
+		all = append(all, ids[i]...)
+	}
+
+	sort.Ints(all)
+	if len(all) != lines {
+		t.Fatalf("read %d lines, want %d", len(all), lines)
+	}
+
+	for i := 0; i < lines; i++ {
+		if all[i] != i {
+			t.Fatalf("line %d missing or read twice", i)
+		}
+	}
+}
+
+func TestMmapFileParserText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.dat")
+	write_numbered_file(t, path, 1000)
+
+	var mp MmapFileParser
+	if err := mp.OpenFile(path, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := read_line_ids(t, &mp, 4)
+	mp.CloseFile(4)
+	check_all_lines(t, ids, 1000)
+
+	//不打乱时每个线程读取连续的一段
+	for i := 0; i < 4; i++ {
+		if !sort.IntsAreSorted(ids[i]) || len(ids[i]) == 0 {
+			t.Fatalf("thread %d read %v", i, ids[i])
+		}
+	}
+}
+
+func TestMmapFileParserCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.dat")
+	write_numbered_file(t, path, 3*CacheBlockSize)
+
+	reader, err := OpenSampleReader(path, 2, nil, CacheBuild|ReadMmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mp, ok := reader.(*MmapFileParser)
+	if !ok || !mp.cache {
+		t.Fatalf("reader %T does not map the cache", reader)
+	}
+
+	ids := read_line_ids(t, reader, 2)
+	reader.CloseFile(2)
+	check_all_lines(t, ids, 3*CacheBlockSize)
+
+	//打乱数据块顺序后仍然每行只读一次
+	shuffled, err := open_sample_reader(path, 3, nil, CacheReuse|ReadMmap, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids = read_line_ids(t, shuffled, 3)
+	shuffled.CloseFile(3)
+	check_all_lines(t, ids, 3*CacheBlockSize)
+}
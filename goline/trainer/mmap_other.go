@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package trainer
+
+import (
+	"io/ioutil"
+)
+
+//不支持mmap的平台将文件整体读入内存
+func mmap_file(filename string) ([]byte, error) {
+	return ioutil.ReadFile(filename)
+}
+
+func munmap_file(data []byte) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package trainer
+
+import (
+	"os"
+	"syscall"
+)
+
+//只读映射整个文件，空文件返回空切片
+func mmap_file(filename string) ([]byte, error) {
+	fs, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fs.Close()
+
+	st, err := fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Size() == 0 {
+		return []byte{}, nil
+	}
+
+	return syscall.Mmap(int(fs.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap_file(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return syscall.Munmap(data)
+}
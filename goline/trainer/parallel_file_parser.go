@@ -16,6 +16,7 @@ type ParallelFileParser struct {
 	Fs    []*os.File
 	Bufio []*bufio.Reader
 	Lock  sync.Mutex
+	Dict  *util.FeatureDict
 }
 
 func (fp *ParallelFileParser) OpenFile(filename string, threadnum int) error {
@@ -82,7 +83,7 @@ func (fp *ParallelFileParser) ReadSample(i int) (error, float64, util.Pvector) {
 		return errors.New("[ParallelFileParser-ReadSample] input value error."), 0., nil
 	}
 
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
 }
 
 func (fp *ParallelFileParser) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
@@ -91,5 +92,5 @@ func (fp *ParallelFileParser) ReadSampleMultiThread(i int) (error, float64, util
 		return errors.New("[ParallelFileParser-ReadSampleMultiThread] input value error."), 0., nil
 	}
 
-	return util.ParseSample(buf)
+	return util.ParseSampleWithDict(buf, fp.Dict)
 }
@@ -0,0 +1,204 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"goline/util"
+	"math"
+	"sort"
+	"strconv"
+)
+
+type FeatureProfile struct {
+	Index    int     `json:"Index"`
+	Name     string  `json:"Name"`
+	Count    int64   `json:"Count"`
+	Coverage float64 `json:"Coverage"`
+	Min      float64 `json:"Min"`
+	Max      float64 `json:"Max"`
+}
+
+type fpvector []FeatureProfile
+
+func (fv fpvector) Less(i, j int) bool {
+	if fv[i].Count != fv[j].Count {
+		return fv[i].Count > fv[j].Count
+	}
+
+	return fv[i].Index < fv[j].Index
+}
+
+func (fv fpvector) Len() int {
+	return len(fv)
+}
+
+func (fv fpvector) Swap(i, j int) {
+	fv[i], fv[j] = fv[j], fv[i]
+}
+
+//样本文件统计信息
+type DataProfile struct {
+	File         string           `json:"File"`
+	Instances    int64            `json:"Instances"`
+	Positives    int64            `json:"Positives"`
+	PositiveRate float64          `json:"PositiveRate"`
+	AvgNonZeros  float64          `json:"AvgNonZeros"`
+	MaxIndex     int              `json:"MaxIndex"`
+	Features     int              `json:"Features"`
+	Frequency    map[string]int64 `json:"Frequency"` //按出现次数分桶的特征数
+	TopFeatures  []FeatureProfile `json:"TopFeatures"`
+	Test         *DataProfile     `json:"Test,omitempty"`
+	TrainOnly    int              `json:"TrainOnly"`
+	TrainOnlyTop []FeatureProfile `json:"TrainOnlyTop"`
+
+	stats map[int]*FeatureProfile
+}
+
+type profile_state struct {
+	count     int64
+	positives int64
+	nonzeros  int64
+	stats     map[int]*FeatureProfile
+}
+
+func frequency_bucket(count int64) string {
+	if count <= 1 {
+		return "1"
+	}
+
+	//按10的幂分桶: 2-10, 11-100, ...
+	high := int64(10)
+	for count > high {
+		high *= 10
+	}
+
+	return strconv.FormatInt(high/10+1, 10) + "-" + strconv.FormatInt(high, 10)
+}
+
+func top_features(features fpvector, topn int) []FeatureProfile {
+	sort.Sort(features)
+	if topn > 0 && len(features) > topn {
+		return features[:topn]
+	}
+
+	return features
+}
+
+func profile_file(path string, num_threads int, dict *util.FeatureDict, topn int) (*DataProfile, error) {
+	num_threads = thread_num(num_threads)
+	states := make([]profile_state, num_threads)
+	for i := 0; i < num_threads; i++ {
+		states[i].stats = make(map[int]*FeatureProfile)
+	}
+
+	err := scan_file(context.Background(), path, num_threads, dict, nil, func(i int, y float64, x util.Pvector) {
+		st := &states[i]
+		st.count++
+		if y > 0 {
+			st.positives++
+		}
+
+		for k := 0; k < len(x); k++ {
+			//跳过偏置
+			if x[k].Index == 0 {
+				continue
+			}
+
+			st.nonzeros++
+			fp, ok := st.stats[x[k].Index]
+			if !ok {
+				fp = &FeatureProfile{Index: x[k].Index, Min: x[k].Value, Max: x[k].Value}
+				st.stats[x[k].Index] = fp
+			}
+
+			fp.Count++
+			fp.Min = math.Min(fp.Min, x[k].Value)
+			fp.Max = math.Max(fp.Max, x[k].Value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &DataProfile{
+		File:      path,
+		Frequency: make(map[string]int64),
+		stats:     make(map[int]*FeatureProfile)}
+
+	var nonzeros int64 = 0
+	for i := 0; i < num_threads; i++ {
+		profile.Instances += states[i].count
+		profile.Positives += states[i].positives
+		nonzeros += states[i].nonzeros
+		for idx, fp := range states[i].stats {
+			total, ok := profile.stats[idx]
+			if !ok {
+				profile.stats[idx] = fp
+				continue
+			}
+
+			total.Count += fp.Count
+			total.Min = math.Min(total.Min, fp.Min)
+			total.Max = math.Max(total.Max, fp.Max)
+		}
+	}
+
+	if profile.Instances == 0 {
+		return profile, errors.New("[profile_file] No instance in file " + path)
+	}
+
+	profile.PositiveRate = float64(profile.Positives) / float64(profile.Instances)
+	profile.AvgNonZeros = float64(nonzeros) / float64(profile.Instances)
+	profile.Features = len(profile.stats)
+
+	var features fpvector = make([]FeatureProfile, 0, len(profile.stats))
+	for idx, fp := range profile.stats {
+		fp.Coverage = float64(fp.Count) / float64(profile.Instances)
+		fp.Name = dict.Name(idx)
+		profile.MaxIndex = util.MaxInt(profile.MaxIndex, idx)
+		profile.Frequency[frequency_bucket(fp.Count)]++
+		features = append(features, *fp)
+	}
+
+	profile.TopFeatures = top_features(features, topn)
+	return profile, nil
+}
+
+//统计训练文件(及测试文件)的样本数、正样本率、特征频次分布、特征覆盖率和取值范围、
+//平均非零特征数以及只在训练集中出现的特征，topn限制输出的特征明细条数(0为全部)
+func ProfileFile(train_file string, test_file string, num_threads int, topn int, dict *util.FeatureDict) (*DataProfile, error) {
+	log := util.GetLogger()
+	if !util.FileExists(train_file) {
+		log.Error("[ProfileFile] Train file is not exist.")
+		return nil, errors.New("[ProfileFile] Train file is not exist.")
+	}
+
+	train, err := profile_file(train_file, num_threads, dict, topn)
+	if err != nil {
+		log.Error("[ProfileFile] Profile train file error." + err.Error())
+		return nil, errors.New("[ProfileFile] Profile train file error." + err.Error())
+	}
+
+	if len(test_file) == 0 {
+		return train, nil
+	}
+
+	test, err := profile_file(test_file, num_threads, dict, topn)
+	if err != nil {
+		log.Error("[ProfileFile] Profile test file error." + err.Error())
+		return nil, errors.New("[ProfileFile] Profile test file error." + err.Error())
+	}
+
+	var train_only fpvector
+	for idx, fp := range train.stats {
+		if _, ok := test.stats[idx]; !ok {
+			train_only = append(train_only, *fp)
+		}
+	}
+
+	train.Test = test
+	train.TrainOnly = len(train_only)
+	train.TrainOnlyTop = top_features(train_only, topn)
+
+	return train, nil
+}
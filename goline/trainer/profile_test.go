@@ -0,0 +1,52 @@
+package trainer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfileFile(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	train := "1 1:1 2:3\n" +
+		"0 1:2 3:-1\n" +
+		"0 1:0.5\n" +
+		"1 1:1 2:5 4:1\n"
+	if err := ioutil.WriteFile(train_file, []byte(train), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(test_file, []byte("1 1:1 2:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := ProfileFile(train_file, test_file, 2, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile.Instances != 4 || profile.Positives != 2 || profile.PositiveRate != 0.5 {
+		t.Fatalf("instances=%d positives=%d rate=%g", profile.Instances, profile.Positives, profile.PositiveRate)
+	}
+
+	if profile.Features != 4 || profile.MaxIndex != 4 || profile.AvgNonZeros != 2 {
+		t.Fatalf("features=%d max_index=%d avg_nnz=%g", profile.Features, profile.MaxIndex, profile.AvgNonZeros)
+	}
+
+	if profile.Frequency["1"] != 2 || profile.Frequency["2-10"] != 2 {
+		t.Fatalf("frequency=%v", profile.Frequency)
+	}
+
+	//按出现次数排序，只输出前2个
+	top := profile.TopFeatures
+	if len(top) != 2 || top[0].Index != 1 || top[0].Coverage != 1 || top[0].Min != 0.5 || top[0].Max != 2 ||
+		top[1].Index != 2 || top[1].Max != 5 {
+		t.Fatalf("top=%+v", top)
+	}
+
+	if profile.Test == nil || profile.Test.Instances != 1 || profile.TrainOnly != 2 {
+		t.Fatalf("test=%+v train_only=%d", profile.Test, profile.TrainOnly)
+	}
+}
@@ -0,0 +1,52 @@
+package trainer
+
+import (
+	"goline/solver"
+	"goline/util"
+	"math"
+	"strings"
+	"testing"
+)
+
+//第0轮的指标是每个样本先预测后更新得到的progressive validation
+func TestTrainOnlineProgressive(t *testing.T) {
+	instances := strings.Split(strings.TrimSpace(synthetic_data(3000, 4)), "\n")
+
+	var init solver.FtrlSolver
+	init.Initialize(0.1, 1, 0, 1, synthetic_features, 0)
+	encoded, err := init.SaveEncodeModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lft LockFreeFtrlTrainer
+	lft.Initialize(2, 1, false)
+	model, results, err := lft.TrainOnlineWithMetrics(encoded, instances)
+	if err != nil || len(model) == 0 {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Count != 3000 || results[1].Epoch != 1 {
+		t.Fatalf("results=%+v", results)
+	}
+
+	var fs solver.FtrlSolver
+	fs.Initialize(0.1, 1, 0, 1, synthetic_features, 0)
+	loss := 0.
+	auc := util.NewStreamingAUC()
+	for _, line := range instances {
+		_, y, x := util.ParseSample(line)
+		pred := fs.Update(x, y)
+		loss += calc_loss(y, pred)
+		auc.Add(pred, y)
+	}
+
+	if math.Abs(results[0].LogLoss-loss/3000) > 1e-9 || results[0].AUC != auc.AUC() {
+		t.Fatalf("epoch 0 loss=%g auc=%g, expected %g %g", results[0].LogLoss, results[0].AUC, loss/3000, auc.AUC())
+	}
+
+	//第二轮样本已被模型见过，loss更低
+	if results[1].LogLoss >= results[0].LogLoss {
+		t.Fatalf("second epoch loss %g", results[1].LogLoss)
+	}
+}
@@ -0,0 +1,603 @@
+package trainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goline/util"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+const (
+	CacheMagic     = "GLSC"
+	CacheVersion   = 2
+	CacheSuffix    = ".cache"
+	CacheBlockSize = 4096 //每个数据块的样本数
+
+	//样本读取方式，可组合使用
+	CacheNone  = 0 //直接读取文本
+	CacheReuse = 1 //缓存有效时读取缓存，否则读取文本
+	CacheBuild = 2 //缓存无效时先扫描文本生成缓存
+	ReadMmap   = 4 //通过内存映射读取文本或缓存
+)
+
+//多线程样本读取接口，i为线程号
+type SampleReader interface {
+	ReadSample(i int) (error, float64, util.Pvector)
+	ReadSampleMultiThread(i int) (error, float64, util.Pvector)
+	CloseFile(threadnum int) bool
+}
+
+//二进制样本缓存文件格式:
+//  header: magic(4) version(4)
+//  blocks: 每个样本为 label(float32) nnz(uvarint) [index增量(varint) value(float32)]*nnz
+//  footer: feat_num line_cnt dict_sign source_size source_mtime block_num [offset count]*block_num
+//  trailer: footer偏移(8) magic(4)
+type CacheBlock struct {
+	Offset int64
+	Count  int
+}
+
+type SampleCache struct {
+	Path       string
+	FeatNum    int
+	LineCnt    int
+	DictSign   uint64
+	SourceSize int64 //生成缓存时源文件的大小和修改时间(纳秒)，任一不一致时缓存失效
+	SourceTime int64
+	Blocks     []CacheBlock
+}
+
+func cache_path(path string) string {
+	return path + CacheSuffix
+}
+
+func append_uint32(buf []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func append_uint64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func append_uvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func append_varint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func encode_sample(buf []byte, y float64, x util.Pvector) []byte {
+	buf = buf[:0]
+	buf = append_uint32(buf, math.Float32bits(float32(y)))
+	buf = append_uvarint(buf, uint64(len(x)))
+
+	last := 0
+	for i := 0; i < len(x); i++ {
+		buf = append_varint(buf, int64(x[i].Index-last))
+		buf = append_uint32(buf, math.Float32bits(float32(x[i].Value)))
+		last = x[i].Index
+	}
+
+	return buf
+}
+
+type sample_reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func decode_sample(rd sample_reader) (float64, util.Pvector, error) {
+	var word [4]byte
+	if _, err := io.ReadFull(rd, word[:]); err != nil {
+		return 0., nil, err
+	}
+	y := float64(math.Float32frombits(binary.LittleEndian.Uint32(word[:])))
+
+	nnz, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return 0., nil, err
+	}
+
+	var x util.Pvector = make(util.Pvector, nnz)
+	last := 0
+	for i := 0; i < int(nnz); i++ {
+		delta, err := binary.ReadVarint(rd)
+		if err != nil {
+			return 0., nil, err
+		}
+
+		if _, err := io.ReadFull(rd, word[:]); err != nil {
+			return 0., nil, err
+		}
+
+		last += int(delta)
+		x[i] = util.Pair{Index: last, Value: float64(math.Float32frombits(binary.LittleEndian.Uint32(word[:])))}
+	}
+
+	return y, x, nil
+}
+
+//样本缓存写入，先写临时文件，Close时改名，多线程写入时按行号保持原文件顺序
+type CacheWriter struct {
+	path    string
+	source  os.FileInfo
+	fs      *os.File
+	w       *bufio.Writer
+	offset  int64
+	blocks  []CacheBlock
+	count   int
+	next    int64
+	pending map[int64][]byte
+	err     error
+	lock    sync.Mutex
+}
+
+//source为缓存对应的文本文件，在扫描前记录其大小和修改时间，扫描期间被改写时缓存在下次打开时失效
+func CreateSampleCache(path string, source string) (*CacheWriter, error) {
+	sst, err := os.Stat(source)
+	if err != nil {
+		return nil, errors.New("[CreateSampleCache] Source file is not exist." + err.Error())
+	}
+
+	fs, err := os.OpenFile(path+".tmp", os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.New("[CreateSampleCache] Open file failed." + err.Error())
+	}
+
+	cw := &CacheWriter{path: path, source: sst, fs: fs, w: bufio.NewWriter(fs), pending: make(map[int64][]byte)}
+	cw.write([]byte(CacheMagic))
+	cw.write(append_uint32(nil, CacheVersion))
+	return cw, cw.err
+}
+
+func (cw *CacheWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+
+	n, err := cw.w.Write(b)
+	cw.offset += int64(n)
+	cw.err = err
+}
+
+func (cw *CacheWriter) append(b []byte) {
+	if len(cw.blocks) == 0 || cw.blocks[len(cw.blocks)-1].Count >= CacheBlockSize {
+		cw.blocks = append(cw.blocks, CacheBlock{Offset: cw.offset})
+	}
+
+	cw.write(b)
+	cw.blocks[len(cw.blocks)-1].Count++
+	cw.count++
+}
+
+//写入文件第seq行的样本，x为nil表示该行解析失败需跳过
+func (cw *CacheWriter) WriteSample(seq int64, y float64, x util.Pvector) {
+	var b []byte
+	if x != nil {
+		b = encode_sample(nil, y, x)
+	}
+
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	cw.pending[seq] = b
+	for {
+		b, ok := cw.pending[cw.next]
+		if !ok {
+			break
+		}
+
+		delete(cw.pending, cw.next)
+		cw.next++
+		if b != nil {
+			cw.append(b)
+		}
+	}
+}
+
+//扫描失败或被取消时丢弃写了一半的缓存，不生成缓存文件
+func (cw *CacheWriter) Abort() {
+	cw.fs.Close()
+	os.Remove(cw.path + ".tmp")
+}
+
+//写入索引并生成缓存文件
+func (cw *CacheWriter) Close(feat_num int, dict *util.FeatureDict) (*SampleCache, error) {
+	defer os.Remove(cw.path + ".tmp")
+
+	cache := &SampleCache{
+		Path:       cw.path,
+		FeatNum:    feat_num,
+		LineCnt:    cw.count,
+		DictSign:   dict.Sign(),
+		SourceSize: cw.source.Size(),
+		SourceTime: cw.source.ModTime().UnixNano(),
+		Blocks:     cw.blocks}
+
+	if len(cw.pending) != 0 {
+		cw.err = errors.New(fmt.Sprintf("%d samples are not written.", len(cw.pending)))
+	}
+
+	footer := cw.offset
+	var buf []byte
+	buf = append_uvarint(buf, uint64(cache.FeatNum))
+	buf = append_uvarint(buf, uint64(cache.LineCnt))
+	buf = append_uint64(buf, cache.DictSign)
+	buf = append_uvarint(buf, uint64(cache.SourceSize))
+	buf = append_uint64(buf, uint64(cache.SourceTime))
+	buf = append_uvarint(buf, uint64(len(cache.Blocks)))
+	for i := 0; i < len(cache.Blocks); i++ {
+		buf = append_uvarint(buf, uint64(cache.Blocks[i].Offset))
+		buf = append_uvarint(buf, uint64(cache.Blocks[i].Count))
+	}
+	buf = append_uint64(buf, uint64(footer))
+	buf = append(buf, CacheMagic...)
+	cw.write(buf)
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	if err := cw.fs.Close(); cw.err == nil {
+		cw.err = err
+	}
+
+	if cw.err == nil {
+		cw.err = os.Rename(cw.path+".tmp", cw.path)
+	}
+
+	if cw.err != nil {
+		return nil, errors.New("[CacheWriter-Close] Write cache failed." + cw.err.Error())
+	}
+
+	return cache, nil
+}
+
+//打开缓存并读取索引，源文件大小或修改时间与生成缓存时不同、或与字典不一致时返回错误
+func OpenSampleCache(path string, source string, dict *util.FeatureDict) (*SampleCache, error) {
+	cst, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New("[OpenSampleCache] Cache file is not exist.")
+	}
+
+	fs, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("[OpenSampleCache] Open file failed." + err.Error())
+	}
+
+	defer fs.Close()
+
+	format_error := errors.New("[OpenSampleCache] Cache file format error.")
+	header := make([]byte, 8)
+	trailer := make([]byte, 12)
+	if cst.Size() < 20 {
+		return nil, format_error
+	}
+
+	if _, err := fs.ReadAt(header, 0); err != nil || string(header[:4]) != CacheMagic ||
+		binary.LittleEndian.Uint32(header[4:]) != CacheVersion {
+		return nil, format_error
+	}
+
+	if _, err := fs.ReadAt(trailer, cst.Size()-12); err != nil || string(trailer[8:]) != CacheMagic {
+		return nil, format_error
+	}
+
+	footer := int64(binary.LittleEndian.Uint64(trailer))
+	if footer < 8 || footer > cst.Size()-12 {
+		return nil, format_error
+	}
+
+	rd := bufio.NewReader(io.NewSectionReader(fs, footer, cst.Size()-12-footer))
+	var vals [6]uint64
+	for i := 0; i < len(vals); i++ {
+		if i == 2 || i == 4 {
+			var word [8]byte
+			if _, err := io.ReadFull(rd, word[:]); err != nil {
+				return nil, format_error
+			}
+			vals[i] = binary.LittleEndian.Uint64(word[:])
+			continue
+		}
+
+		if vals[i], err = binary.ReadUvarint(rd); err != nil {
+			return nil, format_error
+		}
+	}
+
+	cache := &SampleCache{
+		Path:       path,
+		FeatNum:    int(vals[0]),
+		LineCnt:    int(vals[1]),
+		DictSign:   vals[2],
+		SourceSize: int64(vals[3]),
+		SourceTime: int64(vals[4]),
+		Blocks:     make([]CacheBlock, int(vals[5]))}
+
+	for i := 0; i < len(cache.Blocks); i++ {
+		offset, err1 := binary.ReadUvarint(rd)
+		count, err2 := binary.ReadUvarint(rd)
+		if err1 != nil || err2 != nil {
+			return nil, format_error
+		}
+
+		cache.Blocks[i] = CacheBlock{Offset: int64(offset), Count: int(count)}
+	}
+
+	if sst, err := os.Stat(source); err == nil &&
+		(sst.Size() != cache.SourceSize || sst.ModTime().UnixNano() != cache.SourceTime) {
+		return nil, errors.New("[OpenSampleCache] Cache file is out of date with " + source)
+	}
+
+	if cache.DictSign != dict.Sign() {
+		return nil, errors.New("[OpenSampleCache] Cache file is built with another feature dict.")
+	}
+
+	return cache, nil
+}
+
+//扫描文本文件生成缓存
+func BuildSampleCache(path string, num_threads int, dict *util.FeatureDict) (*SampleCache, error) {
+	writer, err := CreateSampleCache(cache_path(path), path)
+	if err != nil {
+		return nil, err
+	}
+
+	feat_num := 0
+	var lock sync.Mutex
+	err = scan_file(context.Background(), path, num_threads, dict, writer, func(i int, y float64, x util.Pvector) {
+		max_feat := 0
+		for k := 0; k < len(x); k++ {
+			max_feat = util.MaxInt(max_feat, x[k].Index+1)
+		}
+
+		lock.Lock()
+		feat_num = util.MaxInt(feat_num, max_feat)
+		lock.Unlock()
+	})
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	return writer.Close(feat_num, dict)
+}
+
+//将数据块划分给各线程，rd为nil时按原顺序连续划分，否则先打乱数据块顺序
+func assign_blocks(nblocks int, threadnum int, rd *rand.Rand) [][]int {
+	order := make([]int, nblocks)
+	for k := 0; k < nblocks; k++ {
+		order[k] = k
+	}
+
+	if rd != nil {
+		rd.Shuffle(nblocks, func(a, b int) { order[a], order[b] = order[b], order[a] })
+	}
+
+	assign := make([][]int, threadnum)
+	for i := 0; i < threadnum; i++ {
+		assign[i] = order[i*nblocks/threadnum : (i+1)*nblocks/threadnum]
+	}
+
+	return assign
+}
+
+//按数据块划分给各线程读取缓存，Rand为nil时线程内顺序与原文件一致
+type CacheFileParser struct {
+	Fs    []*os.File
+	Bufio []*bufio.Reader
+	Rand  *rand.Rand
+
+	cache  *SampleCache
+	assign [][]int
+	cur    []int
+	left   []int
+}
+
+func (cp *CacheFileParser) OpenCache(cache *SampleCache, threadnum int) error {
+	cp.Fs = make([]*os.File, threadnum)
+	cp.Bufio = make([]*bufio.Reader, threadnum)
+	cp.cache = cache
+	cp.assign = assign_blocks(len(cache.Blocks), threadnum, cp.Rand)
+	cp.cur = make([]int, threadnum)
+	cp.left = make([]int, threadnum)
+
+	for i := 0; i < threadnum; i++ {
+		if len(cp.assign[i]) == 0 {
+			continue
+		}
+
+		fs, err := os.Open(cache.Path)
+		if err != nil {
+			cp.CloseFile(threadnum)
+			return errors.New(fmt.Sprintf("[CacheFileParser-OpenCache] Open file failed.%s", err.Error()))
+		}
+
+		cp.Fs[i] = fs
+		cp.Bufio[i] = bufio.NewReader(fs)
+		cp.cur[i] = -1
+		if err = cp.next_block(i); err != nil {
+			cp.CloseFile(threadnum)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//切换到线程i的下一个数据块，连续的数据块不需要重新定位
+func (cp *CacheFileParser) next_block(i int) error {
+	last := -1
+	if cp.cur[i] >= 0 {
+		last = cp.assign[i][cp.cur[i]]
+	}
+
+	cp.cur[i]++
+	if cp.cur[i] >= len(cp.assign[i]) {
+		return nil
+	}
+
+	b := cp.assign[i][cp.cur[i]]
+	cp.left[i] = cp.cache.Blocks[b].Count
+	if last >= 0 && b == last+1 {
+		return nil
+	}
+
+	_, err := cp.Fs[i].Seek(cp.cache.Blocks[b].Offset, 0)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[CacheFileParser-next_block] Seek file failed.%s", err.Error()))
+	}
+
+	cp.Bufio[i].Reset(cp.Fs[i])
+	return nil
+}
+
+func (cp *CacheFileParser) CloseFile(threadnum int) bool {
+	for i := 0; i < threadnum && i < len(cp.Fs); i++ {
+		if cp.Fs[i] != nil {
+			cp.Fs[i].Close()
+			cp.Fs[i] = nil
+		}
+	}
+
+	return true
+}
+
+func (cp *CacheFileParser) ReadSample(i int) (error, float64, util.Pvector) {
+	if i >= len(cp.left) || cp.Bufio[i] == nil {
+		return errors.New("[CacheFileParser-ReadSample] end of cache."), 0., nil
+	}
+
+	for cp.left[i] <= 0 {
+		if cp.cur[i] >= len(cp.assign[i]) {
+			return errors.New("[CacheFileParser-ReadSample] end of cache."), 0., nil
+		}
+
+		if err := cp.next_block(i); err != nil {
+			cp.cur[i] = len(cp.assign[i])
+			return err, 0., nil
+		}
+	}
+
+	y, x, err := decode_sample(cp.Bufio[i])
+	if err != nil {
+		cp.left[i] = 0
+		cp.cur[i] = len(cp.assign[i])
+		return errors.New("[CacheFileParser-ReadSample] read cache failed." + err.Error()), 0., nil
+	}
+
+	cp.left[i]--
+	return nil, y, x
+}
+
+func (cp *CacheFileParser) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	return cp.ReadSample(i)
+}
+
+//文本文件共享读取，所有线程从同一文件读取
+type TextFileParser struct {
+	FileParser
+}
+
+func (tp *TextFileParser) ReadSample(i int) (error, float64, util.Pvector) {
+	return tp.FileParser.ReadSample()
+}
+
+func (tp *TextFileParser) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	return tp.FileParser.ReadSampleMultiThread()
+}
+
+func (tp *TextFileParser) CloseFile(threadnum int) bool {
+	return tp.FileParser.CloseFile()
+}
+
+//打开样本文件，根据read_mode决定是否读取(或生成)二进制缓存以及是否使用内存映射
+func OpenSampleReader(
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	read_mode int) (SampleReader, error) {
+
+	return open_sample_reader(path, num_threads, dict, read_mode, nil)
+}
+
+//rd不为nil时打乱数据块顺序，文本文件只能通过内存映射按数据块读取
+func open_sample_reader(
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	read_mode int,
+	rd *rand.Rand) (SampleReader, error) {
+
+	num_threads = thread_num(num_threads)
+	if read_mode&(CacheReuse|CacheBuild) != 0 {
+		cache, err := OpenSampleCache(cache_path(path), path, dict)
+		if err != nil && read_mode&CacheBuild != 0 {
+			util.GetLogger().Info("[OpenSampleReader] Build sample cache for " + path)
+			cache, err = BuildSampleCache(path, num_threads, dict)
+		}
+
+		if err == nil {
+			if read_mode&ReadMmap != 0 {
+				var mp MmapFileParser
+				mp.Dict = dict
+				mp.Rand = rd
+				err = mp.OpenCache(cache, num_threads)
+				if err == nil {
+					return &mp, nil
+				}
+			} else {
+				var cp CacheFileParser
+				cp.Rand = rd
+				err = cp.OpenCache(cache, num_threads)
+				if err == nil {
+					return &cp, nil
+				}
+			}
+		}
+
+		util.GetLogger().Warn("[OpenSampleReader] Sample cache unavailable, read text file." + err.Error())
+	}
+
+	if read_mode&ReadMmap != 0 || rd != nil {
+		var mp MmapFileParser
+		mp.Dict = dict
+		mp.Rand = rd
+		err := mp.OpenFile(path, num_threads)
+		if err == nil {
+			return &mp, nil
+		}
+
+		//打乱顺序依赖数据块划分，不能退回到顺序读取
+		if rd != nil {
+			return nil, err
+		}
+
+		util.GetLogger().Warn("[OpenSampleReader] Memory map unavailable, read text file." + err.Error())
+	}
+
+	var tp TextFileParser
+	tp.Dict = dict
+	err := tp.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tp, nil
+}
@@ -0,0 +1,191 @@
+package trainer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"goline/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSampleRoundTrip(t *testing.T) {
+	samples := []util.Pvector{
+		{{Index: 0, Value: 1}, {Index: 3, Value: 0.5}, {Index: 1 << 20, Value: -2.25}},
+		{{Index: 0, Value: 1}},
+		{{Index: 0, Value: 1}, {Index: 7, Value: 3}, {Index: 5, Value: 1}}}
+	labels := []float64{1, 0, 1}
+
+	var data []byte
+	var buf []byte
+	for k := 0; k < len(samples); k++ {
+		buf = encode_sample(buf, labels[k], samples[k])
+		data = append(data, buf...)
+	}
+
+	rd := bufio.NewReader(bytes.NewReader(data))
+	for k := 0; k < len(samples); k++ {
+		y, x, err := decode_sample(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if y != labels[k] || len(x) != len(samples[k]) {
+			t.Fatalf("sample %d: y=%g x=%v", k, y, x)
+		}
+
+		for i := 0; i < len(x); i++ {
+			if x[i] != samples[k][i] {
+				t.Fatalf("sample %d: got %v, want %v", k, x, samples[k])
+			}
+		}
+	}
+
+	if _, _, err := decode_sample(rd); err == nil {
+		t.Fatal("read past the end of the encoded samples")
+	}
+}
+
+func write_cache_source(t *testing.T, path string, lines int) {
+	var text strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&text, "%d %d:1 %d:0.5\n", i%2, i%13+1, i%7+20)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(text.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampleCacheBuildAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.dat")
+	write_cache_source(t, path, CacheBlockSize+100)
+
+	cache, err := BuildSampleCache(path, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.LineCnt != CacheBlockSize+100 || cache.FeatNum != 27 || len(cache.Blocks) != 2 {
+		t.Fatalf("lines=%d feat_num=%d blocks=%d", cache.LineCnt, cache.FeatNum, len(cache.Blocks))
+	}
+
+	opened, err := OpenSampleCache(cache_path(path), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened.LineCnt != cache.LineCnt || opened.SourceSize != cache.SourceSize || opened.SourceTime != cache.SourceTime {
+		t.Fatalf("footer mismatch: %+v vs %+v", opened, cache)
+	}
+
+	var cp CacheFileParser
+	if err := cp.OpenCache(opened, 1); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.CloseFile(1)
+
+	for i := 0; i < opened.LineCnt; i++ {
+		err, y, x := cp.ReadSample(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if y != float64(i%2) || len(x) != 3 || x[1].Index != i%13+1 || x[2].Index != i%7+20 || x[2].Value != 0.5 {
+			t.Fatalf("line %d: y=%g x=%v", i, y, x)
+		}
+	}
+
+	if err, _, _ := cp.ReadSample(0); err == nil {
+		t.Fatal("read past the end of the cache")
+	}
+}
+
+func TestSampleCacheStaleSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.dat")
+	write_cache_source(t, path, 10)
+	if _, err := BuildSampleCache(path, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sst, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//改写后保留原修改时间，大小不同
+	write_cache_source(t, path, 11)
+	if err := os.Chtimes(path, sst.ModTime(), sst.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSampleCache(cache_path(path), path, nil); err == nil {
+		t.Fatal("cache accepted after source size changed")
+	}
+
+	//大小相同，修改时间不同(可能早于缓存)
+	if _, err := BuildSampleCache(path, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	earlier := sst.ModTime().Add(-time.Hour)
+	if err := os.Chtimes(path, earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSampleCache(cache_path(path), path, nil); err == nil {
+		t.Fatal("cache accepted after source mtime changed")
+	}
+}
+
+//扫描被取消时不生成缓存，下次扫描读全部数据，不会把不完整的缓存当作有效
+func TestSampleCacheCanceledScan(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "train.dat")
+	write_cache_source(t, path, 200000)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := read_problem_info(canceled, path, true, 4, nil); err == nil {
+		t.Fatal("canceled scan succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(5*time.Millisecond, cancel)
+	_, _, err := read_problem_info(ctx, path, true, 4, nil)
+	timer.Stop()
+	if _, serr := os.Stat(cache_path(path)); err != nil && serr == nil {
+		t.Fatalf("cache written for a scan that failed with %v", err)
+	}
+
+	if _, serr := os.Stat(cache_path(path) + ".tmp"); serr == nil {
+		t.Fatal("temporary cache left behind")
+	}
+
+	feat_num, lines, err := read_problem_info(context.Background(), path, true, 4, nil)
+	if err != nil || lines != 200000 || feat_num != 27 {
+		t.Fatalf("features=%d lines=%d err=%v", feat_num, lines, err)
+	}
+
+	if cache, err := OpenSampleCache(cache_path(path), path, nil); err != nil || cache.LineCnt != 200000 {
+		t.Fatalf("cache=%+v err=%v", cache, err)
+	}
+}
+
+//读文件出错时扫描返回错误，不当作文件结束
+func TestScanFileReadError(t *testing.T) {
+	dir := t.TempDir()
+	err := scan_file(context.Background(), dir, 2, nil, nil, func(i int, y float64, x util.Pvector) {})
+	if err == nil {
+		t.Fatal("reading a directory succeeded")
+	}
+
+	if _, err := BuildSampleCache(dir, 2, nil); err == nil {
+		t.Fatal("cache built from a directory")
+	}
+}
@@ -0,0 +1,449 @@
+package trainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goline/util"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	s "strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SearchGrid    = "grid"
+	SearchRandom  = "random"
+	SearchHalving = "halving"
+
+	SearchLeaderboard = "leaderboard.json"
+)
+
+//超参数搜索空间，每个参数为候选值列表。grid取各列表的笛卡尔积；random在各列表的[最小值,最大值]内均匀采样，
+//最小值大于0且最大值不小于其10倍时按对数均匀采样，列表只有一个值时固定为该值
+type SearchSpace struct {
+	Alpha   []float64 `json:"Alpha"`
+	Beta    []float64 `json:"Beta"`
+	L1      []float64 `json:"L1"`
+	L2      []float64 `json:"L2"`
+	Dropout []float64 `json:"Dropout"`
+}
+
+//解析搜索空间，格式为"参数:值,值;参数:值"，如"alpha:0.01,0.05,0.1;l1:0,1,10;dropout:0,0.1"
+func ParseSearchSpace(spec string) (SearchSpace, error) {
+	var space SearchSpace
+	for _, item := range s.Split(spec, ";") {
+		item = s.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		kv := s.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return space, errors.New("[ParseSearchSpace] Search space format error: " + item)
+		}
+
+		var values []float64
+		for _, v := range s.Split(kv[1], ",") {
+			f, err := strconv.ParseFloat(s.TrimSpace(v), 64)
+			if err != nil {
+				return space, errors.New("[ParseSearchSpace] Search value error: " + item)
+			}
+			values = append(values, f)
+		}
+
+		switch s.ToLower(s.TrimSpace(kv[0])) {
+		case "alpha":
+			space.Alpha = values
+		case "beta":
+			space.Beta = values
+		case "l1":
+			space.L1 = values
+		case "l2":
+			space.L2 = values
+		case "dropout":
+			space.Dropout = values
+		default:
+			return space, errors.New("[ParseSearchSpace] Unknown parameter " + kv[0])
+		}
+	}
+
+	return space, nil
+}
+
+//未给出候选值的参数固定为默认值
+func (ss *SearchSpace) Default(alpha float64, beta float64, l1 float64, l2 float64, dropout float64) {
+	fill := func(values *[]float64, v float64) {
+		if len(*values) == 0 {
+			*values = []float64{v}
+		}
+	}
+
+	fill(&ss.Alpha, alpha)
+	fill(&ss.Beta, beta)
+	fill(&ss.L1, l1)
+	fill(&ss.L2, l2)
+	fill(&ss.Dropout, dropout)
+}
+
+func (ss *SearchSpace) lists() [][]float64 {
+	return [][]float64{ss.Alpha, ss.Beta, ss.L1, ss.L2, ss.Dropout}
+}
+
+func (ss *SearchSpace) grid() [][]float64 {
+	points := [][]float64{{}}
+	for _, values := range ss.lists() {
+		var next [][]float64
+		for _, p := range points {
+			for _, v := range values {
+				next = append(next, append(append([]float64(nil), p...), v))
+			}
+		}
+		points = next
+	}
+
+	return points
+}
+
+func (ss *SearchSpace) sample(randoms *rand.Rand) []float64 {
+	var point []float64
+	for _, values := range ss.lists() {
+		lo, hi := values[0], values[0]
+		for _, v := range values {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+
+		v := lo
+		if hi > lo && lo > 0 && hi >= 10*lo {
+			v = math.Exp(math.Log(lo) + randoms.Float64()*(math.Log(hi)-math.Log(lo)))
+		} else if hi > lo {
+			v = lo + randoms.Float64()*(hi-lo)
+		}
+		point = append(point, v)
+	}
+
+	return point
+}
+
+//搜索配置，每组参数按TrainerConfig用NewTrainer创建FastFtrlTrainer训练，Epoch为每组最多训练轮数，
+//Parallel组同时训练，每组使用NumThreads个线程。第i组的dropout随机种子(确定性训练时也是其种子)为Seed+i+1，记录在排行榜中
+type SearchConfig struct {
+	TrainerConfig
+
+	Method   string //grid、random或halving
+	Metric   string //排序指标，logloss或auc
+	Trials   int    //random和halving的参数组数，halving为0时使用全部grid
+	Parallel int
+	Seed     int64 //random采样和各组训练的随机种子，为0时使用当前时间
+	//halving每轮保留前1/Eta的参数组，存活的参数组训练轮数乘以Eta，从MinEpoch轮开始
+	Eta        int
+	MinEpoch   int
+	KeepModels bool //保留全部参数组的模型，默认只保留最好的一组
+
+	Preprocess  *util.PreprocessConfig
+	Cross       *util.CrossConfig
+	SampleRates *util.SampleRates
+}
+
+//一组参数的训练结果，指标为测试集最后一轮的评估
+type SearchTrial struct {
+	Rank    int     `json:"Rank"`
+	Id      int     `json:"Id"`
+	Alpha   float64 `json:"Alpha"`
+	Beta    float64 `json:"Beta"`
+	L1      float64 `json:"L1"`
+	L2      float64 `json:"L2"`
+	Dropout float64 `json:"Dropout"`
+	Seed    int64   `json:"Seed"`
+	Epoch   int     `json:"Epoch"`
+	LogLoss float64 `json:"LogLoss"`
+	AUC     float64 `json:"AUC"`
+	Time    float64 `json:"Time"`
+	Model   string  `json:"Model,omitempty"`
+	Error   string  `json:"Error,omitempty"`
+}
+
+//按指标排序的搜索结果，Trials[0]为最好的一组
+type SearchReport struct {
+	Method    string        `json:"Method"`
+	Metric    string        `json:"Metric"`
+	Trials    []SearchTrial `json:"Trials"`
+	BestModel string        `json:"BestModel"`
+}
+
+func (sc *SearchConfig) check() error {
+	switch sc.Method {
+	case SearchGrid, SearchRandom, SearchHalving:
+	default:
+		return errors.New("[HyperSearch] Unknown search method " + sc.Method)
+	}
+
+	if len(sc.Metric) == 0 {
+		sc.Metric = MetricLogLoss
+	}
+
+	if sc.Metric != MetricLogLoss && sc.Metric != MetricAUC {
+		return errors.New("[HyperSearch] Unknown metric " + sc.Metric)
+	}
+
+	if sc.Method == SearchRandom && sc.Trials <= 0 {
+		return errors.New("[HyperSearch] Random search needs the number of trials.")
+	}
+
+	if sc.Epoch <= 0 {
+		sc.Epoch = 1
+	}
+
+	if sc.NumThreads <= 0 {
+		sc.NumThreads = 1
+	}
+
+	if sc.Parallel <= 0 {
+		sc.Parallel = util.MaxInt(1, runtime.NumCPU()/sc.NumThreads)
+	}
+
+	if sc.Seed == 0 {
+		sc.Seed = time.Now().UnixNano()
+	}
+
+	if sc.Eta < 2 {
+		sc.Eta = 3
+	}
+
+	if sc.MinEpoch <= 0 {
+		sc.MinEpoch = 1
+	}
+
+	return nil
+}
+
+//a是否好于b，训练失败的参数组排在最后
+func (sc *SearchConfig) better(a *SearchTrial, b *SearchTrial) bool {
+	if (len(a.Error) == 0) != (len(b.Error) == 0) {
+		return len(a.Error) == 0
+	}
+
+	//halving中训练轮数多的参数组是更晚被淘汰的
+	if a.Epoch != b.Epoch {
+		return a.Epoch > b.Epoch
+	}
+
+	if sc.Metric == MetricAUC {
+		return a.AUC > b.AUC
+	}
+
+	return a.LogLoss < b.LogLoss
+}
+
+//训练一组参数epochs轮，trial.Epoch大于0时在其模型上继续训练
+func (sc *SearchConfig) train_trial(ctx context.Context, trial *SearchTrial, epochs int, train_file string, test_file string) error {
+	conf := sc.TrainerConfig
+	conf.Epoch = epochs
+	conf.JobName = fmt.Sprintf("%s trial %d", sc.JobName, trial.Id)
+	conf.Seed = trial.Seed
+	if conf.Deterministic != nil {
+		conf.Deterministic = NewDeterministicConfig(trial.Seed, conf.Deterministic.SyncStep)
+	}
+
+	tr, err := NewTrainer(TrainerFast, conf)
+	if err != nil {
+		trial.Error = err.Error()
+		return err
+	}
+
+	tr.SetContext(ctx)
+	fs := tr.(feature_setter)
+	fs.SetPreprocess(sc.Preprocess)
+	fs.SetFeatureCross(sc.Cross)
+	fs.SetSampleRates(sc.SampleRates)
+	tr.AddCallback(Callback{OnEval: func(state *TrainState) error {
+		trial.LogLoss = state.EvalLoss
+		trial.AUC = state.EvalAUC
+		return nil
+	}})
+
+	var timer util.StopWatch
+	timer.StartTimer()
+
+	if trial.Epoch > 0 {
+		err = tr.TrainRestore(trial.Model, trial.Model, train_file, test_file)
+	} else {
+		err = tr.Train(trial.Alpha, trial.Beta, trial.L1, trial.L2, trial.Dropout, trial.Model, train_file, test_file)
+	}
+
+	trial.Time += timer.StopTimer()
+	if err != nil {
+		trial.Error = err.Error()
+		return err
+	}
+
+	trial.Epoch += epochs
+	return nil
+}
+
+//Parallel组参数同时训练，每组训练到epochs轮
+func (sc *SearchConfig) run_trials(ctx context.Context, trials []*SearchTrial, epochs int, train_file string, test_file string) {
+	log := util.GetLogger()
+	var next int32 = -1
+	worker := func(i int, c *sync.WaitGroup) {
+		defer c.Done()
+
+		for ctx.Err() == nil {
+			k := int(atomic.AddInt32(&next, 1))
+			if k >= len(trials) {
+				return
+			}
+
+			trial := trials[k]
+			err := sc.train_trial(ctx, trial, epochs-trial.Epoch, train_file, test_file)
+			if err != nil {
+				log.Warn(fmt.Sprintf("[%s] trial %d failed.%s", sc.JobName, trial.Id, err.Error()))
+				continue
+			}
+
+			log.Info(fmt.Sprintf("[%s] trial %d alpha=%g beta=%g l1=%g l2=%g dropout=%g seed=%d epoch=%d logloss=[%f] auc=[%f]\n",
+				sc.JobName, trial.Id, trial.Alpha, trial.Beta, trial.L1, trial.L2, trial.Dropout,
+				trial.Seed, trial.Epoch, trial.LogLoss, trial.AUC))
+		}
+	}
+
+	util.UtilParallelRunContext(ctx, worker, util.MinInt(sc.Parallel, len(trials)))
+}
+
+//启用缓存时先生成训练、测试数据的缓存，避免多组训练同时写同一缓存文件
+func (sc *SearchConfig) prepare_cache(ctx context.Context, train_file string, test_file string) {
+	if !sc.CacheFeatureNum {
+		return
+	}
+
+	read_problem_info(ctx, train_file, true, sc.NumThreads*sc.Parallel, nil)
+	reader, err := OpenSampleReader(test_file, 1, nil, CacheBuild)
+	if err == nil {
+		reader.CloseFile(1)
+	}
+}
+
+//超参数搜索，各组参数的模型写入out_dir，测试集上最好的一组为BestModel，排行榜写入out_dir/leaderboard.json。
+//halving(successive halving)每轮淘汰指标较差的参数组，把训练轮数留给较好的参数组
+func HyperSearch(
+	ctx context.Context,
+	conf SearchConfig,
+	space SearchSpace,
+	train_file string,
+	test_file string,
+	out_dir string) (*SearchReport, error) {
+
+	log := util.GetLogger()
+	if err := conf.check(); err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	if !util.FileExists(train_file) || !util.FileExists(test_file) {
+		log.Error("[HyperSearch] Train file or test file is not exist.")
+		return nil, errors.New("[HyperSearch] Train file or test file is not exist.")
+	}
+
+	if err := util.Mkdir(out_dir); err != nil {
+		log.Error("[HyperSearch] Make output directory error." + err.Error())
+		return nil, errors.New("[HyperSearch] Make output directory error." + err.Error())
+	}
+
+	space.Default(0.1, 1, 0, 0, 0)
+	var points [][]float64
+	if conf.Method == SearchGrid || (conf.Method == SearchHalving && conf.Trials <= 0) {
+		points = space.grid()
+	} else {
+		randoms := rand.New(rand.NewSource(conf.Seed))
+		for i := 0; i < conf.Trials; i++ {
+			points = append(points, space.sample(randoms))
+		}
+	}
+
+	trials := make([]*SearchTrial, len(points))
+	for i, p := range points {
+		trials[i] = &SearchTrial{
+			Id:      i,
+			Alpha:   p[0],
+			Beta:    p[1],
+			L1:      p[2],
+			L2:      p[3],
+			Dropout: p[4],
+			Seed:    conf.Seed + int64(i) + 1,
+			Model:   filepath.Join(out_dir, fmt.Sprintf("trial_%03d.dat", i))}
+	}
+
+	log.Info(fmt.Sprintf("[%s] %s search over %d configurations, metric=%s parallel=%d threads=%d\n",
+		conf.JobName, conf.Method, len(trials), conf.Metric, conf.Parallel, conf.NumThreads))
+
+	conf.prepare_cache(ctx, train_file, test_file)
+
+	report := &SearchReport{Method: conf.Method, Metric: conf.Metric}
+	rank := func(list []*SearchTrial) {
+		sort.SliceStable(list, func(i, j int) bool { return conf.better(list[i], list[j]) })
+	}
+
+	alive := append([]*SearchTrial(nil), trials...)
+	epochs := conf.Epoch
+	if conf.Method == SearchHalving {
+		epochs = util.MinInt(conf.MinEpoch, conf.Epoch)
+	}
+
+	for ctx.Err() == nil {
+		conf.run_trials(ctx, alive, epochs, train_file, test_file)
+		rank(alive)
+		if conf.Method != SearchHalving || len(alive) <= 1 || epochs >= conf.Epoch {
+			break
+		}
+
+		keep := util.MaxInt(1, len(alive)/conf.Eta)
+		log.Info(fmt.Sprintf("[%s] halving at epoch %d, keep %d of %d configurations\n", conf.JobName, epochs, keep, len(alive)))
+		alive = alive[:keep]
+		epochs = util.MinInt(epochs*conf.Eta, conf.Epoch)
+	}
+
+	rank(trials)
+	for i, trial := range trials {
+		trial.Rank = i + 1
+		if i > 0 && !conf.KeepModels {
+			os.Remove(trial.Model)
+			trial.Model = ""
+		}
+		report.Trials = append(report.Trials, *trial)
+	}
+
+	if err := ctx.Err(); err != nil {
+		log.Warn(fmt.Sprintf("[%s] Search interrupted.%s", conf.JobName, err.Error()))
+		return report, &PartialProgressError{JobName: conf.JobName, Err: err}
+	}
+
+	if len(trials) == 0 || len(trials[0].Error) != 0 {
+		log.Error("[HyperSearch] All trials failed.")
+		return report, errors.New("[HyperSearch] All trials failed.")
+	}
+
+	report.BestModel = trials[0].Model
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(out_dir, SearchLeaderboard), b, 0644)
+	}
+	if err != nil {
+		log.Warn("[HyperSearch] Write leaderboard error." + err.Error())
+	}
+
+	best := trials[0]
+	log.Info(fmt.Sprintf("[%s] best trial %d alpha=%g beta=%g l1=%g l2=%g dropout=%g seed=%d epoch=%d logloss=[%f] auc=[%f]\n",
+		conf.JobName, best.Id, best.Alpha, best.Beta, best.L1, best.L2, best.Dropout, best.Seed, best.Epoch, best.LogLoss, best.AUC))
+
+	return report, nil
+}
@@ -0,0 +1,70 @@
+package trainer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestHyperSearchTrialSeeds(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, train_file, 4000, 1)
+	write_synthetic_file(t, test_file, 1000, 2)
+
+	space, err := ParseSearchSpace("alpha:0.05,0.1;dropout:0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	new_conf := func(lock_mode string) SearchConfig {
+		conf := SearchConfig{Method: SearchGrid, Parallel: 2, Seed: 100, KeepModels: true}
+		conf.Epoch = 2
+		conf.NumThreads = 2
+		conf.LockMode = lock_mode
+		conf.Deterministic = NewDeterministicConfig(0, 200)
+		conf.Shuffle = NewShuffleConfig(9, 0)
+		return conf
+	}
+
+	search := func(out string) *SearchReport {
+		report, err := HyperSearch(context.Background(), new_conf("atomic"), space, train_file, test_file, filepath.Join(dir, out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	first := search("a")
+	second := search("b")
+	if len(first.Trials) != 2 {
+		t.Fatalf("trials=%+v", first.Trials)
+	}
+
+	for i, trial := range first.Trials {
+		if trial.Seed != 100+int64(trial.Id)+1 {
+			t.Fatalf("trial %d seed %d", trial.Id, trial.Seed)
+		}
+
+		//每组的确定性训练使用本组的种子，相同配置的搜索结果逐位相同
+		if trial.LogLoss != second.Trials[i].LogLoss || trial.Id != second.Trials[i].Id {
+			t.Fatalf("trial %d: %g vs %g", trial.Id, trial.LogLoss, second.Trials[i].LogLoss)
+		}
+
+		var fft FastFtrlTrainer
+		if err := fft.ParamServer.Construct(trial.Model); err != nil {
+			t.Fatal(err)
+		}
+
+		meta := fft.ParamServer.Meta
+		if meta["DeterministicSeed"] != meta["DropoutSeed"] || meta["ShuffleSeed"] != "9" {
+			t.Fatalf("trial %d meta=%v", trial.Id, meta)
+		}
+	}
+
+	//参数服务器的配置传给每组训练
+	if _, err := HyperSearch(context.Background(), new_conf("spin"), space, train_file, test_file, filepath.Join(dir, "c")); err == nil {
+		t.Fatal("unknown lock mode accepted")
+	}
+}
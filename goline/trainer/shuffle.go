@@ -0,0 +1,188 @@
+package trainer
+
+import (
+	"errors"
+	"goline/solver"
+	"goline/util"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultShuffleBuffer = 10000
+)
+
+//打乱训练数据顺序的配置，每轮迭代使用不同的数据块顺序
+type ShuffleConfig struct {
+	Seed       int64 //随机种子，记录在模型元数据中用于复现
+	BufferSize int   //每个线程shuffle缓冲区的样本数，0为只打乱数据块顺序
+}
+
+//seed为0时使用当前时间，buffer_size小于0时使用默认缓冲区大小
+func NewShuffleConfig(seed int64, buffer_size int) *ShuffleConfig {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	if buffer_size < 0 {
+		buffer_size = DefaultShuffleBuffer
+	}
+
+	return &ShuffleConfig{Seed: seed, BufferSize: buffer_size}
+}
+
+//随机种子写入模型元数据，conf为nil时不记录
+func (sc *ShuffleConfig) record(fs *solver.FtrlSolver) {
+	if sc == nil {
+		return
+	}
+
+	fs.SetMeta("ShuffleSeed", strconv.FormatInt(sc.Seed, 10))
+	fs.SetMeta("ShuffleBuffer", strconv.Itoa(sc.BufferSize))
+}
+
+//每轮迭代的随机种子
+func (sc *ShuffleConfig) epoch_seed(epoch int) int64 {
+	return sc.Seed + int64(epoch)*1000003
+}
+
+type shuffle_item struct {
+	y float64
+	x util.Pvector
+}
+
+//shuffle缓冲区，每个线程缓存一定数量的样本，每次随机取出一个
+type ShuffleBuffer struct {
+	Reader SampleReader
+	Size   int
+
+	buf  [][]shuffle_item
+	rd   []*rand.Rand
+	done []bool
+}
+
+func NewShuffleBuffer(reader SampleReader, threadnum int, size int, seed int64) *ShuffleBuffer {
+	sb := &ShuffleBuffer{
+		Reader: reader,
+		Size:   size,
+		buf:    make([][]shuffle_item, threadnum),
+		rd:     make([]*rand.Rand, threadnum),
+		done:   make([]bool, threadnum)}
+
+	for i := 0; i < threadnum; i++ {
+		sb.buf[i] = make([]shuffle_item, 0, size)
+		sb.rd[i] = rand.New(rand.NewSource(seed + int64(i)))
+	}
+
+	return sb
+}
+
+func (sb *ShuffleBuffer) ReadSample(i int) (error, float64, util.Pvector) {
+	if i >= len(sb.buf) {
+		return errors.New("[ShuffleBuffer-ReadSample] thread index error."), 0., nil
+	}
+
+	for !sb.done[i] && len(sb.buf[i]) < sb.Size {
+		err, y, x := sb.Reader.ReadSampleMultiThread(i)
+		if err != nil {
+			sb.done[i] = true
+			break
+		}
+
+		sb.buf[i] = append(sb.buf[i], shuffle_item{y, x})
+	}
+
+	n := len(sb.buf[i])
+	if n == 0 {
+		return errors.New("[ShuffleBuffer-ReadSample] end of file."), 0., nil
+	}
+
+	k := sb.rd[i].Intn(n)
+	item := sb.buf[i][k]
+	sb.buf[i][k] = sb.buf[i][n-1]
+	sb.buf[i] = sb.buf[i][:n-1]
+	return nil, item.y, item.x
+}
+
+func (sb *ShuffleBuffer) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	return sb.ReadSample(i)
+}
+
+func (sb *ShuffleBuffer) CloseFile(threadnum int) bool {
+	return sb.Reader.CloseFile(threadnum)
+}
+
+//打开第epoch轮迭代的训练数据，打乱数据块顺序并经过shuffle缓冲区
+func OpenShuffledReader(
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	read_mode int,
+	conf *ShuffleConfig,
+	epoch int) (SampleReader, error) {
+
+	num_threads = thread_num(num_threads)
+	seed := conf.epoch_seed(epoch)
+	reader, err := open_sample_reader(path, num_threads, dict, read_mode, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.BufferSize <= 0 {
+		return reader, nil
+	}
+
+	return NewShuffleBuffer(reader, num_threads, conf.BufferSize, seed), nil
+}
+
+//conf为nil时按原顺序读取
+func open_train_reader(
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	read_mode int,
+	conf *ShuffleConfig,
+	epoch int) (SampleReader, error) {
+
+	if conf == nil {
+		return OpenSampleReader(path, num_threads, dict, read_mode)
+	}
+
+	return OpenShuffledReader(path, num_threads, dict, read_mode, conf, epoch)
+}
+
+//启用缓存或内存映射时各线程读取各自的数据分区，否则将训练文件切分为多个文件，各线程读取的样本固定
+func open_partitioned_reader(
+	path string,
+	num_threads int,
+	dict *util.FeatureDict,
+	cache bool,
+	mmap bool,
+	conf *ShuffleConfig,
+	epoch int) (SampleReader, error) {
+
+	if conf != nil {
+		return OpenShuffledReader(path, num_threads, dict, read_mode(cache, mmap), conf, epoch)
+	}
+
+	if cache || mmap {
+		reader, err := OpenSampleReader(path, num_threads, dict, read_mode(cache, mmap))
+		if err == nil {
+			if _, ok := reader.(*TextFileParser); !ok {
+				return reader, nil
+			}
+
+			reader.CloseFile(num_threads)
+		}
+	}
+
+	var file_parser ParallelFileParser
+	file_parser.Dict = dict
+	err := file_parser.OpenFile(path, num_threads)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file_parser, nil
+}
@@ -0,0 +1,66 @@
+package trainer
+
+import (
+	"goline/solver"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func shuffled_line_ids(t *testing.T, path string, threads int, read_mode int, conf *ShuffleConfig, epoch int) [][]int {
+	reader, err := OpenShuffledReader(path, threads, nil, read_mode, conf, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.CloseFile(threads)
+	return read_line_ids(t, reader, threads)
+}
+
+func TestShuffledReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.dat")
+	lines := 4 * CacheBlockSize
+	write_numbered_file(t, path, lines)
+
+	for _, mode := range []int{ReadMmap, CacheBuild} {
+		conf := NewShuffleConfig(42, 100)
+		first := shuffled_line_ids(t, path, 2, mode, conf, 0)
+		check_all_lines(t, first, lines)
+
+		again := shuffled_line_ids(t, path, 2, mode, conf, 0)
+		if !reflect.DeepEqual(first, again) {
+			t.Fatalf("mode %d: same seed and epoch gave a different order", mode)
+		}
+
+		next := shuffled_line_ids(t, path, 2, mode, conf, 1)
+		check_all_lines(t, next, lines)
+		if reflect.DeepEqual(first, next) {
+			t.Fatalf("mode %d: epoch 1 has the same order as epoch 0", mode)
+		}
+
+		//缓冲区打乱了数据块内的顺序
+		for i := 0; i < len(first); i++ {
+			if len(first[i]) > 2 && first[i][1] == first[i][0]+1 && first[i][2] == first[i][1]+1 {
+				t.Fatalf("mode %d: thread %d reads in file order %v", mode, i, first[i][:3])
+			}
+		}
+	}
+}
+
+func TestShuffleConfigRecord(t *testing.T) {
+	conf := NewShuffleConfig(0, -1)
+	if conf.Seed == 0 || conf.BufferSize != DefaultShuffleBuffer {
+		t.Fatalf("conf=%+v", conf)
+	}
+
+	if conf.epoch_seed(0) == conf.epoch_seed(1) {
+		t.Fatal("epochs share a seed")
+	}
+
+	var fs solver.FtrlSolver
+	conf.record(&fs)
+	if fs.Meta["ShuffleSeed"] != strconv.FormatInt(conf.Seed, 10) || fs.Meta["ShuffleBuffer"] != "10000" {
+		t.Fatalf("meta=%v", fs.Meta)
+	}
+}
@@ -11,6 +11,7 @@ type StreamParser struct {
 	Buf  []string
 	Idx  int
 	Lock sync.Mutex
+	Dict *util.FeatureDict
 }
 
 func (sp *StreamParser) Open(instances []string) error {
@@ -46,7 +47,7 @@ func (sp *StreamParser) ReadSampleMultiThread() (error, float64, util.Pvector) {
 		return errors.New("[StreamParser-ReadSampleMultiThread] input value length error."), 0., nil
 	}
 
-	return util.ParseSample(instance)
+	return util.ParseSampleWithDict(instance, sp.Dict)
 }
 
 func (sp *StreamParser) ReadLine() error {
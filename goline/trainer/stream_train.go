@@ -0,0 +1,212 @@
+package trainer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"goline/solver"
+	"goline/util"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	StreamBufferSize = 1 << 20
+	StreamMergeStep  = 1000 //各线程每训练多少样本合并一次统计
+)
+
+//流式训练配置，数据只读一遍，不做read_problem_info预扫描
+type StreamConfig struct {
+	FeatNum    int    //特征空间大小，下标超出的特征被忽略
+	Checkpoint int64  //每训练多少样本保存一次模型，0为只在结束时保存
+	LogStep    int64  //每训练多少样本输出一次progressive validation loss，0为默认值
+	LastModel  string //不为空时在已有模型基础上继续训练
+}
+
+//流式训练结果，loss、AUC为先预测后更新得到的progressive validation指标，AUC按预估值分桶近似
+type StreamStat struct {
+	Count int64
+	Loss  float64
+	AUC   float64
+}
+
+//先写临时文件再改名，避免读到写了一半的模型
+func save_model_atomic(save func(path string) error, path string) error {
+	tmp := path + ".tmp"
+	err := save(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+//流式训练时特征交叉不需要拟合，数值特征预处理需要预扫描数据，不支持
+func build_stream_features(
+	preprocess *util.PreprocessConfig,
+	cross *util.CrossConfig,
+	feat_num int) (*util.FeatureCross, int, error) {
+
+	if preprocess != nil {
+		return nil, feat_num, errors.New("[build_stream_features] Feature preprocess needs fitting on training data, not supported in stream mode.")
+	}
+
+	if feat_num <= 0 {
+		return nil, feat_num, errors.New("[build_stream_features] The number of features must be given in stream mode.")
+	}
+
+	return build_feature_cross(cross, feat_num)
+}
+
+//从reader多线程读取样本训练，update返回更新前的预估值，checkpoint为nil时不保存中间模型。
+//每训练TrainBatchSize个样本调用一次OnBatch，回调返回错误时停止读取。
+//ctx取消时各线程读完当前样本后退出，返回PartialProgressError，不保存最终模型
+func (tb *trainer_base) train_stream(
+	reader io.Reader,
+	num_threads int,
+	model *solver.FtrlSolver,
+	conf StreamConfig,
+	update func(i int, x util.Pvector, y float64) float64,
+	checkpoint func() error) (StreamStat, error) {
+
+	job_name := tb.JobName
+	dict := model.Dict
+	log := util.GetLogger()
+	if conf.LogStep <= 0 {
+		conf.LogStep = 100000
+	}
+
+	//流式训练中遇到的新特征名直接分配下标
+	if dict != nil {
+		dict.Grow = true
+		defer func() { dict.Grow = false }()
+	}
+
+	var parser FileParser
+	parser.Dict = dict
+	parser.Bufio = bufio.NewReaderSize(reader, StreamBufferSize)
+
+	var stat StreamStat
+	var window StreamStat
+	auc := util.NewStreamingAUC()
+	var read_err error
+	var cb_err error
+	var stop int32 = 0
+	var lock sync.Mutex
+	var ckpt_lock sync.Mutex
+
+	var timer util.StopWatch
+	timer.StartTimer()
+
+	merge := func(local *StreamStat, local_auc *util.StreamingAUC) {
+		lock.Lock()
+		last := stat.Count
+		stat.Count += local.Count
+		stat.Loss += local.Loss
+		window.Count += local.Count
+		window.Loss += local.Loss
+		auc.Merge(local_auc)
+
+		if stat.Count/conf.LogStep != last/conf.LogStep && window.Count > 0 {
+			log.Info(fmt.Sprintf("[%s] processed=[%d] time=[%.2f] progressive-loss=[%.6f] window-loss=[%.6f] progressive-auc=[%.6f]\n",
+				job_name,
+				stat.Count,
+				timer.StopTimer(),
+				stat.Loss/float64(stat.Count),
+				window.Loss/float64(window.Count),
+				auc.AUC()))
+			window = StreamStat{}
+		}
+
+		if cb_err == nil && stat.Count/TrainBatchSize != last/TrainBatchSize {
+			state := TrainState{
+				JobName:   job_name,
+				Processed: stat.Count,
+				TrainLoss: stat.Loss / float64(stat.Count),
+				TrainAUC:  auc.AUC(),
+				EvalLoss:  -1,
+				EvalAUC:   -1,
+				Time:      timer.StopTimer(),
+				Model:     model}
+			if cb_err = tb.on_batch(&state); cb_err != nil {
+				atomic.StoreInt32(&stop, 1)
+			}
+		}
+
+		do_checkpoint := checkpoint != nil && conf.Checkpoint > 0 && stat.Count/conf.Checkpoint != last/conf.Checkpoint
+		lock.Unlock()
+
+		*local = StreamStat{}
+		if do_checkpoint {
+			ckpt_lock.Lock()
+			err := checkpoint()
+			ckpt_lock.Unlock()
+			if err != nil {
+				log.Warn(fmt.Sprintf("[%s] Save checkpoint error.%s", job_name, err.Error()))
+			}
+		}
+	}
+
+	worker_func := func(i int, c *sync.WaitGroup) {
+		var local StreamStat
+		local_auc := util.NewStreamingAUC()
+		for atomic.LoadInt32(&stop) == 0 {
+			flag, seq, y, x := parser.ReadSampleWithSeq()
+			if seq < 0 {
+				if flag != io.EOF {
+					lock.Lock()
+					read_err = flag
+					lock.Unlock()
+				}
+				break
+			}
+
+			if flag != nil {
+				continue
+			}
+
+			pred := update(i, x, y)
+			local.Loss += calc_loss(y, pred)
+			local_auc.Add(pred, y)
+			local.Count++
+			if local.Count >= StreamMergeStep {
+				merge(&local, local_auc)
+			}
+		}
+
+		merge(&local, local_auc)
+		defer c.Done()
+	}
+
+	unwatch := util.WatchContext(tb.context(), &stop)
+	err := util.UtilParallelRunContext(tb.context(), worker_func, thread_num(num_threads))
+	unwatch()
+
+	if stat.Count > 0 {
+		stat.Loss /= float64(stat.Count)
+		stat.AUC = auc.AUC()
+		log.Info(fmt.Sprintf("[%s] processed=[%d] time=[%.2f] progressive-loss=[%.6f] progressive-auc=[%.6f]\n",
+			job_name,
+			stat.Count,
+			timer.StopTimer(),
+			stat.Loss,
+			stat.AUC))
+	}
+
+	if err != nil {
+		return stat, &PartialProgressError{JobName: job_name, Processed: stat.Count, Err: err}
+	}
+
+	if read_err != nil {
+		return stat, errors.New("[train_stream] Read stream error." + read_err.Error())
+	}
+
+	if cb_err != nil && cb_err != ErrStopTraining {
+		return stat, errors.New("[train_stream] Training callback error." + cb_err.Error())
+	}
+
+	return stat, nil
+}
@@ -0,0 +1,126 @@
+package trainer
+
+import (
+	"fmt"
+	"goline/solver"
+	"goline/util"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const synthetic_features = 50
+
+//逻辑回归生成的样本，特征k的真实权重为(k%5-2)*0.8，每行5个特征
+func synthetic_data(lines int, seed int64) string {
+	rd := rand.New(rand.NewSource(seed))
+	var text strings.Builder
+	for i := 0; i < lines; i++ {
+		var line strings.Builder
+		wx := 0.
+		for k := 0; k < 5; k++ {
+			idx := rd.Intn(synthetic_features-1) + 1
+			wx += float64(idx%5-2) * 0.8
+			fmt.Fprintf(&line, " %d:1", idx)
+		}
+
+		label := 0
+		if rd.Float64() < util.Sigmoid(wx) {
+			label = 1
+		}
+		fmt.Fprintf(&text, "%d%s\n", label, line.String())
+	}
+
+	return text.String()
+}
+
+func write_synthetic_file(t *testing.T, path string, lines int, seed int64) {
+	if err := ioutil.WriteFile(path, []byte(synthetic_data(lines, seed)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//模型在样本文件上的平均log loss
+func model_loss(t *testing.T, model *solver.FtrlSolver, path string) float64 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loss := 0.
+	count := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		err, y, x := util.ParseSampleWithDict(line, model.Dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loss += calc_loss(y, model.Predict(x))
+		count++
+	}
+
+	return loss / float64(count)
+}
+
+func TestTrainStream(t *testing.T) {
+	dir := t.TempDir()
+	model_file := filepath.Join(dir, "model.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, test_file, 2000, 2)
+
+	var ft FtrlTrainer
+	ft.Initialize(1, false)
+	ft.SetJobName("stream")
+
+	checkpoints := 0
+	batches := 0
+	ft.AddCallback(Callback{OnBatch: func(state *TrainState) error {
+		batches++
+		if _, err := os.Stat(model_file); err == nil {
+			checkpoints++
+		}
+		return nil
+	}})
+
+	conf := StreamConfig{FeatNum: synthetic_features, Checkpoint: 5000}
+	err := ft.TrainStream(0.1, 1, 0, 1, 0, model_file, strings.NewReader(synthetic_data(30000, 1)), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//每TrainBatchSize个样本调用一次OnBatch，第一次之前已经保存过检查点
+	if batches != 3 || checkpoints != 3 {
+		t.Fatalf("batches=%d checkpoints=%d", batches, checkpoints)
+	}
+
+	var model solver.FtrlSolver
+	if err := model.Construct(model_file); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(model_file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary model left behind")
+	}
+
+	if loss := model_loss(t, &model, test_file); loss > 0.6 {
+		t.Fatalf("stream model loss %g", loss)
+	}
+
+	//在已有模型上继续流式训练
+	var more FtrlTrainer
+	more.Initialize(1, false)
+	conf.LastModel = model_file
+	conf.Checkpoint = 0
+	next_file := filepath.Join(dir, "model2.dat")
+	err = more.TrainStream(0, 0, 0, 0, 0, next_file, strings.NewReader(synthetic_data(1000, 3)), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if more.Solver.Alpha != 0.1 || more.Solver.Featnum != synthetic_features {
+		t.Fatalf("restored alpha=%g featnum=%d", more.Solver.Alpha, more.Solver.Featnum)
+	}
+}
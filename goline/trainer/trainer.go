@@ -0,0 +1,681 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goline/deps/log4go"
+	"goline/solver"
+	"goline/util"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TrainerFtrl      = "ftrl"
+	TrainerLockFree  = "lockfree"
+	TrainerFast      = "fast"
+	TrainerMiniBatch = "minibatch"
+
+	TrainBatchSize = 10000  //各线程每训练多少样本合并一次统计并调用OnBatch
+	TrainLogStep   = 100000 //每训练多少样本输出一次训练进度
+
+	DefaultPushStep  = 10
+	DefaultFetchStep = 10
+)
+
+//回调返回ErrStopTraining时提前结束训练并正常保存模型
+var ErrStopTraining = errors.New("stop training")
+
+//训练器公共接口，FtrlTrainer、LockFreeFtrlTrainer、FastFtrlTrainer和MiniBatchFtrlTrainer都实现该接口
+type Trainer interface {
+	Train(alpha float64, beta float64, l1 float64, l2 float64, dropout float64,
+		model_file string, train_file string, test_file string) error
+	TrainRestore(last_model string, model_file string, train_file string, test_file string) error
+	TrainStream(alpha float64, beta float64, l1 float64, l2 float64, dropout float64,
+		model_file string, reader io.Reader, conf StreamConfig) error
+	//当前模型，训练结束后即为保存的模型
+	Model() *solver.FtrlSolver
+	AddCallback(cb Callback)
+	//ctx取消或超时时训练返回PartialProgressError，不写出model_file
+	SetContext(ctx context.Context)
+}
+
+//训练器通用配置，NumThreads为0时使用全部CPU，BurnIn、PushStep、FetchStep、Deterministic和参数服务器的ParamGroupSize、
+//LockMode、LockCount只对FastFtrlTrainer有效，BatchSize只对MiniBatchFtrlTrainer有效，Seed为dropout随机种子
+type TrainerConfig struct {
+	Epoch           int
+	NumThreads      int
+	CacheFeatureNum bool
+	BurnIn          float64
+	PushStep        int
+	FetchStep       int
+	ParamGroupSize  int
+	LockMode        string
+	LockCount       int
+	BatchSize       int
+	JobName         string
+	Seed            int64
+	Shuffle         *ShuffleConfig       //每轮打乱训练数据顺序，nil时不打乱
+	Deterministic   *DeterministicConfig //确定性训练，nil时各线程异步交换参数
+}
+
+//训练进度，回调中Model为训练中的模型，只可读取
+type TrainState struct {
+	JobName   string
+	Epoch     int
+	Processed int64   //本轮已训练样本数
+	Total     int     //训练数据行数，流式训练时为0
+	TrainLoss float64 //本轮先预测后更新的平均loss，即progressive validation loss
+	TrainAUC  float64 //本轮先预测后更新的AUC，按预估值分桶近似
+	EvalLoss  float64 //测试集loss，未评估时为-1
+	EvalAUC   float64 //测试集AUC，未评估时为-1
+	Time      float64 //训练开始至今的秒数
+	Model     *solver.FtrlSolver
+}
+
+//一轮训练中先预测后更新的评估结果
+type ProgressiveResult struct {
+	Epoch   int     `json:"Epoch"`
+	Count   int64   `json:"Count"`
+	LogLoss float64 `json:"LogLoss"`
+	AUC     float64 `json:"AUC"`
+}
+
+func progressive_result(state *TrainState) ProgressiveResult {
+	return ProgressiveResult{Epoch: state.Epoch, Count: state.Processed, LogLoss: state.TrainLoss, AUC: state.TrainAUC}
+}
+
+//训练回调，未设置的hook不调用。OnBatch在各线程每训练TrainBatchSize个样本后串行调用，
+//OnEval在每轮测试集评估后调用，OnEpochEnd在每轮结束(评估之后)调用。
+//返回ErrStopTraining时提前结束训练，返回其它错误时训练失败
+type Callback struct {
+	OnBatch    func(state *TrainState) error
+	OnEpochEnd func(state *TrainState) error
+	OnEval     func(state *TrainState) error
+}
+
+//按类型创建训练器，kind为ftrl、lockfree、fast或minibatch
+func NewTrainer(kind string, conf TrainerConfig) (Trainer, error) {
+	if conf.PushStep <= 0 {
+		conf.PushStep = DefaultPushStep
+	}
+
+	if conf.FetchStep <= 0 {
+		conf.FetchStep = DefaultFetchStep
+	}
+
+	var tr Trainer
+	var tb *trainer_base
+	switch kind {
+	case TrainerFtrl:
+		var ft FtrlTrainer
+		ft.Initialize(conf.Epoch, conf.CacheFeatureNum)
+		ft.SetJobName(conf.JobName)
+		tr, tb = &ft, &ft.trainer_base
+	case TrainerLockFree:
+		var lft LockFreeFtrlTrainer
+		lft.Initialize(conf.Epoch, thread_num(conf.NumThreads), conf.CacheFeatureNum)
+		lft.SetJobName(conf.JobName)
+		tr, tb = &lft, &lft.trainer_base
+	case TrainerFast:
+		var fft FastFtrlTrainer
+		fft.Initialize(conf.Epoch, conf.NumThreads, conf.CacheFeatureNum, conf.BurnIn, conf.PushStep, conf.FetchStep)
+		fft.SetJobName(conf.JobName)
+		if err := fft.SetParamServer(conf.ParamGroupSize, conf.LockMode, conf.LockCount); err != nil {
+			return nil, err
+		}
+		fft.Deterministic = conf.Deterministic
+		tr, tb = &fft, &fft.trainer_base
+	case TrainerMiniBatch:
+		var mbt MiniBatchFtrlTrainer
+		mbt.Initialize(conf.Epoch, conf.NumThreads, conf.CacheFeatureNum, conf.BatchSize)
+		mbt.SetJobName(conf.JobName)
+		tr, tb = &mbt, &mbt.trainer_base
+	default:
+		return nil, errors.New("[NewTrainer] Unknown trainer type " + kind)
+	}
+
+	tb.SetSeed(conf.Seed)
+	tb.Shuffle = conf.Shuffle
+	return tr, nil
+}
+
+//各训练器共用的配置和训练流程
+type trainer_base struct {
+	Epoch           int
+	CacheFeatureNum bool
+	NumThreads      int
+	JobName         string
+	Preprocess      *util.PreprocessConfig
+	Cross           *util.CrossConfig
+	Dict            *util.FeatureDict
+	Mmap            bool
+	Shuffle         *ShuffleConfig
+	SampleRates     *util.SampleRates
+	EarlyStop       *EarlyStopping
+	Checkpoint      *CheckpointConfig
+	Callbacks       []Callback
+	Seed            int64 //dropout随机种子，各线程每轮的随机数由Seed、轮次和线程号派生
+
+	resume  *TrainProgress    //TrainRestore从检查点恢复的进度
+	dropout []*counted_source //本轮各线程的dropout随机数源
+	draws   []int64           //从检查点中途继续时各线程需跳过的dropout取值次数
+	ctx     context.Context
+
+	Init bool
+	log  log4go.Logger
+}
+
+func (tb *trainer_base) SetFeatureDict(dict *util.FeatureDict) {
+	tb.Dict = dict
+}
+
+func (tb *trainer_base) SetPreprocess(conf *util.PreprocessConfig) {
+	tb.Preprocess = conf
+}
+
+//每轮迭代打乱训练数据顺序，seed为0时随机生成并记录在模型中
+func (tb *trainer_base) SetShuffle(seed int64, buffer_size int) {
+	tb.Shuffle = NewShuffleConfig(seed, buffer_size)
+}
+
+//dropout的随机种子，各线程使用各自的随机数，相同种子、数据顺序和线程调度时dropout可复现
+func (tb *trainer_base) SetSeed(seed int64) {
+	tb.Seed = seed
+}
+
+//第epoch轮各线程的dropout随机数，从检查点中途继续时跳过检查点前各线程已取值的次数
+func (tb *trainer_base) dropout_rands(epoch int, workers int) []*rand.Rand {
+	rands := make([]*rand.Rand, workers)
+	tb.dropout = make([]*counted_source, workers)
+	for i := 0; i < workers; i++ {
+		tb.dropout[i] = dropout_source(tb.Seed, epoch, i)
+		if len(tb.draws) == workers {
+			tb.dropout[i].skip(tb.draws[i])
+		}
+		rands[i] = rand.New(tb.dropout[i])
+	}
+
+	tb.draws = nil
+	return rands
+}
+
+//本轮各线程dropout随机数已取值的次数，只在各线程暂停或退出后调用
+func (tb *trainer_base) dropout_draws() []int64 {
+	draws := make([]int64, len(tb.dropout))
+	for i, src := range tb.dropout {
+		draws[i] = src.draws
+	}
+
+	return draws
+}
+
+//使用内存映射读取训练和测试数据
+func (tb *trainer_base) SetMmap(enable bool) {
+	tb.Mmap = enable
+}
+
+//训练数据经过抽样时按各类别实际抽样比例的倒数对样本加权，rates一般由[训练文件].rates读入
+func (tb *trainer_base) SetSampleRates(rates *util.SampleRates) {
+	tb.SampleRates = rates
+}
+
+func (tb *trainer_base) SetFeatureCross(conf *util.CrossConfig) {
+	tb.Cross = conf
+}
+
+//按测试集指标提前结束训练，保存效果最好一轮的模型，需要指定测试文件
+func (tb *trainer_base) SetEarlyStop(metric string, patience int, min_delta float64) error {
+	es, err := NewEarlyStopping(metric, patience, min_delta)
+	if err != nil {
+		return err
+	}
+
+	tb.EarlyStop = es
+	return nil
+}
+
+//定期保存检查点，every为0时只在每轮结束时保存，path为空时为model_file+".ckpt"。
+//训练中断后用TrainRestore(检查点, ...)从中断处继续，训练完成后检查点被删除
+func (tb *trainer_base) SetCheckpoint(path string, every int64) {
+	tb.Checkpoint = &CheckpointConfig{Path: path, Every: every}
+}
+
+func (tb *trainer_base) SetContext(ctx context.Context) {
+	tb.ctx = ctx
+}
+
+func (tb *trainer_base) AddCallback(cb Callback) {
+	tb.Callbacks = append(tb.Callbacks, cb)
+}
+
+func (tb *trainer_base) on_batch(state *TrainState) error {
+	for _, cb := range tb.Callbacks {
+		if cb.OnBatch != nil {
+			if err := cb.OnBatch(state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (tb *trainer_base) on_epoch_end(state *TrainState) error {
+	for _, cb := range tb.Callbacks {
+		if cb.OnEpochEnd != nil {
+			if err := cb.OnEpochEnd(state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (tb *trainer_base) on_eval(state *TrainState) error {
+	for _, cb := range tb.Callbacks {
+		if cb.OnEval != nil {
+			if err := cb.OnEval(state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//last_model为检查点时读出训练进度，之后的训练从中断处继续
+func (tb *trainer_base) restore_progress(model *solver.FtrlSolver) error {
+	progress, err := load_progress(model)
+	if err != nil || progress == nil {
+		return err
+	}
+
+	delete(model.Meta, ProgressMetaKey)
+	if progress.ShuffleSeed != 0 {
+		tb.Shuffle = &ShuffleConfig{Seed: progress.ShuffleSeed, BufferSize: progress.ShuffleBuffer}
+	}
+
+	tb.Seed = progress.DropoutSeed
+
+	tb.resume = progress
+	return nil
+}
+
+//记录元数据后保存最终模型，先写临时文件再改名，成功后删除检查点
+func (tb *trainer_base) save_model(model *solver.FtrlSolver, model_file string) error {
+	tb.Shuffle.record(model)
+	record_sample_rates(tb.SampleRates, model)
+	if util.UtilGreater(model.Dropout, 0.0) {
+		model.SetMeta("DropoutSeed", strconv.FormatInt(tb.Seed, 10))
+	}
+
+	err := save_model_atomic(model.SaveModel, model_file)
+	if err != nil {
+		return err
+	}
+
+	remove_checkpoint(tb.Checkpoint, model_file)
+	return nil
+}
+
+func (tb *trainer_base) save_checkpoint(model *solver.FtrlSolver, model_file string, progress *TrainProgress) {
+	if tb.Shuffle != nil {
+		progress.ShuffleSeed = tb.Shuffle.Seed
+		progress.ShuffleBuffer = tb.Shuffle.BufferSize
+	}
+
+	//轮次中途保存时记录各线程dropout随机数的位置，轮次开始时随机数重新派生，不需要记录
+	progress.DropoutSeed = tb.Seed
+	if len(progress.Offsets) != 0 {
+		progress.DropoutDraws = tb.dropout_draws()
+	}
+
+	path := tb.Checkpoint.path(model_file)
+	err := save_checkpoint(model, progress, path)
+	if err != nil {
+		tb.log.Warn(fmt.Sprintf("[%s] Save checkpoint error.%s", tb.JobName, err.Error()))
+		return
+	}
+
+	tb.log.Info(fmt.Sprintf("[%s] checkpoint saved to %s, epoch=%d processed=%d\n", tb.JobName, path, progress.Epoch, progress.Processed))
+}
+
+//测试数据的读取方式与训练数据一致
+func (tb *trainer_base) evaluator(test_file string, model *solver.FtrlSolver) func() EvalResult {
+	if test_file == "" {
+		return nil
+	}
+
+	return func() EvalResult {
+		return evaluate_file(tb.context(), test_file, model.Predict, tb.NumThreads, model.Dict, read_mode(tb.CacheFeatureNum, tb.Mmap))
+	}
+}
+
+//一轮训练中随训练器变化的部分
+type train_ops struct {
+	workers    int
+	model      *solver.FtrlSolver
+	model_file string
+	open       func(epoch int) (SampleReader, error)
+	//线程i用样本更新模型，返回更新前的预估值
+	update func(i int, x util.Pvector, y float64) float64
+	//评估测试集，为nil时不评估
+	evaluate func() EvalResult
+	//每轮并行训练前调用，resume为true时本轮从检查点中途继续，返回false时跳过本轮的并行训练
+	before_epoch func(epoch int, reader SampleReader, resume bool) bool
+	//线程i读完本轮数据后以及保存检查点前调用，将线程内的更新同步到model
+	after_worker func(i int)
+	//大于0时各线程每训练sync_step个样本在屏障处等待，全部线程到达后调用sync同步参数，
+	//训练结果与线程调度无关。此时不在轮次中途保存检查点
+	sync_step int
+	sync      func()
+	//有界延迟的时钟，每轮开始时清零，线程读完数据后退出时钟，每轮结束输出延迟统计。设置时不在轮次中途保存检查点
+	clock *solver.SSPClock
+}
+
+//按轮次多线程训练，每轮结束输出训练loss并评估测试集，各阶段调用回调。
+//设置了提前结束时，结束后模型恢复为测试集指标最好的一轮；设置了检查点时定期保存进度，
+//由TrainRestore读入检查点时从中断的轮次和位置继续。ctx取消时在中断处保存检查点并返回PartialProgressError
+func (tb *trainer_base) train_epochs(ops *train_ops, line_cnt int) error {
+	ctx := tb.context()
+	resume := tb.resume
+	tb.resume = nil
+	start := 0
+	if resume != nil {
+		start = resume.Epoch
+	}
+
+	//没有模型文件(在线学习)时不保存检查点
+	checkpoint := tb.Checkpoint != nil && len(ops.model_file) != 0
+
+	if tb.EarlyStop != nil {
+		if ops.evaluate == nil {
+			tb.log.Warn(fmt.Sprintf("[%s] early stopping needs a test file, disabled.\n", tb.JobName))
+		} else {
+			tb.EarlyStop.reset()
+			defer func() {
+				if tb.EarlyStop.BestEpoch >= 0 {
+					ops.model.SetMeta("BestEpoch", fmt.Sprintf("%d", tb.EarlyStop.BestEpoch))
+				}
+
+				if tb.EarlyStop.restore(ops.model) {
+					tb.log.Info(fmt.Sprintf("[%s] restore model of best epoch %d, %s=[%f]\n",
+						tb.JobName, tb.EarlyStop.BestEpoch, tb.EarlyStop.Metric, tb.EarlyStop.BestScore))
+				}
+			}()
+		}
+	}
+
+	tb.log.Info(fmt.Sprintf("[%s] params={alpha:%.2f, beta:%.2f, l1:%.2f, l2:%.2f, dropout:%.2f, epoch:%d}\n",
+		tb.JobName,
+		ops.model.Alpha,
+		ops.model.Beta,
+		ops.model.L1,
+		ops.model.L2,
+		ops.model.Dropout,
+		tb.Epoch))
+
+	var timer util.StopWatch
+	timer.StartTimer()
+	//中断时保存检查点，返回已完成的进度
+	interrupted := func(progress *TrainProgress) error {
+		err := &PartialProgressError{JobName: tb.JobName, Epoch: progress.Epoch, Processed: progress.Processed, Err: ctx.Err()}
+		if checkpoint {
+			tb.save_checkpoint(ops.model, ops.model_file, progress)
+			err.Checkpoint = tb.Checkpoint.path(ops.model_file)
+		}
+
+		tb.log.Warn(err.Error())
+		return err
+	}
+
+	for iter := start; iter < tb.Epoch; iter++ {
+		if ctx.Err() != nil {
+			return interrupted(&TrainProgress{Epoch: iter})
+		}
+
+		raw, err := ops.open(iter)
+		if err != nil {
+			return errors.New("Open train file error." + err.Error())
+		}
+		reader := new_counted_reader(raw, ops.workers)
+
+		state := TrainState{
+			JobName:  tb.JobName,
+			Epoch:    iter,
+			Total:    line_cnt,
+			EvalLoss: -1,
+			EvalAUC:  -1,
+			Model:    ops.model}
+
+		var loss float64 = 0
+		auc := util.NewStreamingAUC()
+		var stop int32 = 0
+		var cb_err error
+		var lock sync.Mutex
+
+		resumed := false
+		if iter == start && resume != nil && len(resume.Offsets) != 0 {
+			if reader.skip(resume) {
+				resumed = true
+				tb.draws = resume.DropoutDraws
+				state.Processed = resume.Processed
+				loss = resume.Loss
+				if resume.AUC != nil {
+					auc = resume.AUC
+				}
+				tb.log.Info(fmt.Sprintf("[%s] resume epoch %d from sample %d\n", tb.JobName, iter, resume.Processed))
+			} else {
+				tb.log.Warn(fmt.Sprintf("[%s] thread number changed, restart epoch %d from the beginning.\n", tb.JobName, iter))
+			}
+		}
+
+		barrier := new_pause_barrier(ops.workers, func() {
+			lock.Lock()
+			defer lock.Unlock()
+
+			progress := &TrainProgress{
+				Epoch:     iter,
+				Processed: state.Processed,
+				Loss:      loss,
+				AUC:       auc,
+				Offsets:   append([]int64(nil), reader.counts...)}
+			tb.save_checkpoint(ops.model, ops.model_file, progress)
+		})
+		every := int64(0)
+		if checkpoint && ops.sync_step <= 0 && ops.clock == nil {
+			every = tb.Checkpoint.Every
+		}
+
+		var rounds *round_barrier
+		if ops.sync_step > 0 {
+			rounds = new_round_barrier(ops.workers, ops.sync)
+		}
+
+		merge := func(local_count *int64, local_loss *float64, local_auc *util.StreamingAUC) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			last := state.Processed
+			state.Processed += *local_count
+			loss += *local_loss
+			auc.Merge(local_auc)
+			*local_count, *local_loss = 0, 0
+			if state.Processed == 0 {
+				return
+			}
+
+			state.TrainLoss = loss / float64(state.Processed)
+			state.TrainAUC = auc.AUC()
+			state.Time = timer.StopTimer()
+			if state.Processed/TrainLogStep != last/TrainLogStep && line_cnt > 0 {
+				tb.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f] train-auc=[%.6f]\n",
+					tb.JobName,
+					iter,
+					float64(state.Processed*100)/float64(line_cnt),
+					state.Time,
+					state.TrainLoss,
+					state.TrainAUC))
+			}
+
+			if cb_err == nil {
+				if cb_err = tb.on_batch(&state); cb_err != nil {
+					atomic.StoreInt32(&stop, 1)
+				}
+			}
+
+			if every > 0 && state.Processed/every != last/every {
+				barrier.request()
+			}
+		}
+
+		worker_func := func(i int, c *sync.WaitGroup) {
+			defer c.Done()
+
+			var local_count int64 = 0
+			var local_loss float64 = 0
+			local_auc := util.NewStreamingAUC()
+			var local_total int64 = 0
+			for atomic.LoadInt32(&stop) == 0 {
+				if barrier.requested() {
+					merge(&local_count, &local_loss, local_auc)
+					if ops.after_worker != nil {
+						ops.after_worker(i)
+					}
+					barrier.wait()
+				}
+
+				flag, y, x := reader.ReadSampleMultiThread(i)
+				if flag != nil {
+					break
+				}
+
+				pred := ops.update(i, x, y)
+				local_loss += calc_loss(y, pred)
+				local_auc.Add(pred, y)
+				local_count++
+				if local_count >= TrainBatchSize {
+					merge(&local_count, &local_loss, local_auc)
+				}
+
+				local_total++
+				if rounds != nil && local_total%int64(ops.sync_step) == 0 {
+					rounds.wait()
+				}
+			}
+
+			merge(&local_count, &local_loss, local_auc)
+			if ops.after_worker != nil {
+				ops.after_worker(i)
+			}
+			barrier.leave()
+			if rounds != nil {
+				rounds.leave()
+			}
+			if ops.clock != nil {
+				ops.clock.Leave(i)
+			}
+		}
+
+		if ops.clock != nil {
+			ops.clock.Reset()
+		}
+
+		unwatch := util.WatchContext(ctx, &stop)
+		if ops.before_epoch == nil || ops.before_epoch(iter, &context_reader{SampleReader: reader, ctx: ctx, stop: &stop}, resumed) {
+			util.UtilParallelRunContext(ctx, worker_func, ops.workers)
+		}
+
+		unwatch()
+		reader.CloseFile(ops.workers)
+		if ctx.Err() != nil {
+			return interrupted(&TrainProgress{
+				Epoch:     iter,
+				Processed: state.Processed,
+				Loss:      loss,
+				AUC:       auc,
+				Offsets:   reader.counts})
+		}
+
+		state.Time = timer.StopTimer()
+		if line_cnt > 0 {
+			tb.log.Info(fmt.Sprintf("[%s] epoch=%d processed=[%.2f%%] time=[%.2f] train-loss=[%.6f] train-auc=[%.6f]\n",
+				tb.JobName,
+				iter,
+				float64(state.Processed*100)/float64(line_cnt),
+				state.Time,
+				state.TrainLoss,
+				state.TrainAUC))
+		}
+
+		if ops.clock != nil {
+			stats := ops.clock.Stats()
+			tb.log.Info(fmt.Sprintf("[%s] epoch=%d staleness=%d clock-ticks=[%d] waits=[%d] wait-time=[%.2f] max-gap=[%d] mean-gap=[%.2f]\n",
+				tb.JobName,
+				iter,
+				ops.clock.Staleness,
+				stats.Ticks,
+				stats.Waits,
+				stats.WaitTime,
+				stats.MaxGap,
+				stats.MeanGap()))
+		}
+
+		if cb_err == nil && ops.evaluate != nil {
+			res := ops.evaluate()
+			if ctx.Err() != nil {
+				return interrupted(&TrainProgress{Epoch: iter + 1})
+			}
+
+			state.EvalLoss = res.Loss
+			state.EvalAUC = res.AUC
+			tb.log.Info(fmt.Sprintf("[%s] validation-loss=[%f] validation-auc=[%f]\n", tb.JobName, state.EvalLoss, state.EvalAUC))
+			cb_err = tb.on_eval(&state)
+			if cb_err == nil && tb.EarlyStop != nil {
+				cb_err = tb.EarlyStop.update(&state)
+			}
+		}
+
+		if cb_err == nil {
+			cb_err = tb.on_epoch_end(&state)
+		}
+
+		if cb_err == nil && checkpoint && iter+1 < tb.Epoch {
+			tb.save_checkpoint(ops.model, ops.model_file, &TrainProgress{Epoch: iter + 1})
+		}
+
+		if cb_err == ErrStopTraining {
+			tb.log.Info(fmt.Sprintf("[%s] training stopped by callback at epoch %d.\n", tb.JobName, iter))
+			return nil
+		}
+
+		if cb_err != nil {
+			return errors.New("Training callback error." + cb_err.Error())
+		}
+	}
+
+	return nil
+}
+
+//在线学习的样本缓存适配为SampleReader
+type stream_reader struct {
+	StreamParser
+}
+
+func (sr *stream_reader) ReadSample(i int) (error, float64, util.Pvector) {
+	return sr.StreamParser.ReadSampleMultiThread()
+}
+
+func (sr *stream_reader) ReadSampleMultiThread(i int) (error, float64, util.Pvector) {
+	return sr.StreamParser.ReadSampleMultiThread()
+}
+
+func (sr *stream_reader) CloseFile(threadnum int) bool {
+	return sr.StreamParser.Close() == nil
+}
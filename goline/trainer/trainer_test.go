@@ -0,0 +1,115 @@
+package trainer
+
+import (
+	"errors"
+	"goline/solver"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var trainer_kinds = []string{TrainerFtrl, TrainerLockFree, TrainerFast, TrainerMiniBatch}
+
+func TestNewTrainerCallbacks(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	test_file := filepath.Join(dir, "test.dat")
+	write_synthetic_file(t, train_file, 20000, 1)
+	write_synthetic_file(t, test_file, 2000, 2)
+
+	for _, kind := range trainer_kinds {
+		tr, err := NewTrainer(kind, TrainerConfig{Epoch: 3, NumThreads: 2, BatchSize: 16, JobName: kind})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var epochs, evals, batches int
+		tr.AddCallback(Callback{
+			OnBatch: func(state *TrainState) error {
+				batches++
+				if state.Model != tr.Model() || state.Total != 20000 {
+					return errors.New("wrong batch state")
+				}
+				return nil
+			},
+			OnEval: func(state *TrainState) error {
+				evals++
+				if state.EvalLoss < 0 || state.EvalAUC < 0 {
+					return errors.New("eval metrics missing")
+				}
+				return nil
+			},
+			OnEpochEnd: func(state *TrainState) error {
+				if state.Epoch != epochs || state.Processed != 20000 {
+					return errors.New("wrong epoch state")
+				}
+				epochs++
+				return nil
+			}})
+
+		model_file := filepath.Join(dir, kind+".dat")
+		if err := tr.Train(0.1, 1, 0, 1, 0, model_file, train_file, test_file); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		if epochs != 3 || evals != 3 || batches < 3 {
+			t.Fatalf("%s: epochs=%d evals=%d batches=%d", kind, epochs, evals, batches)
+		}
+
+		var model solver.FtrlSolver
+		if err := model.Construct(model_file); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		if loss := model_loss(t, &model, test_file); loss > 0.6 {
+			t.Fatalf("%s: test loss %g", kind, loss)
+		}
+	}
+}
+
+func TestCallbackStopTraining(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	write_synthetic_file(t, train_file, 5000, 1)
+
+	for _, kind := range trainer_kinds {
+		tr, err := NewTrainer(kind, TrainerConfig{Epoch: 5, NumThreads: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		epochs := 0
+		tr.AddCallback(Callback{OnEpochEnd: func(state *TrainState) error {
+			epochs++
+			if state.Epoch == 1 {
+				return ErrStopTraining
+			}
+			return nil
+		}})
+
+		model_file := filepath.Join(dir, kind+".dat")
+		if err := tr.Train(0.1, 1, 0, 1, 0, model_file, train_file, ""); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		if _, err := os.Stat(model_file); err != nil || epochs != 2 {
+			t.Fatalf("%s: epochs=%d model %v", kind, epochs, err)
+		}
+
+		//其它错误使训练失败，不保存模型
+		tr, _ = NewTrainer(kind, TrainerConfig{Epoch: 2, NumThreads: 2})
+		tr.AddCallback(Callback{OnBatch: func(state *TrainState) error { return errors.New("abort") }})
+		failed_file := filepath.Join(dir, kind+".failed")
+		if err := tr.Train(0.1, 1, 0, 1, 0, failed_file, train_file, ""); err == nil {
+			t.Fatalf("%s: callback error ignored", kind)
+		}
+
+		if _, err := os.Stat(failed_file); !os.IsNotExist(err) {
+			t.Fatalf("%s: model saved after failure", kind)
+		}
+	}
+
+	if _, err := NewTrainer("sgd", TrainerConfig{}); err == nil {
+		t.Fatal("unknown trainer kind accepted")
+	}
+}
@@ -0,0 +1,58 @@
+package trainer
+
+import (
+	"fmt"
+	"goline/solver"
+)
+
+//热启动：last的参数按特征名(都有特征字典时)或下标对齐后按特征块复制到fs，返回复制的特征数。
+//fs的原始特征数不小于last，last的原始特征都能复制
+func warm_start(fs *solver.FtrlSolver, last *solver.FtrlSolver) (int, error) {
+	if last.Dict != nil && fs.Dict != nil && !fs.Dict.Extends(last.Dict) {
+		_, _, err := last.Remap(fs.Dict)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return fs.CopyParamBlocks(last), nil
+}
+
+//TrainRestore时模型的特征字典：训练器指定了非空且与模型不同的字典时按特征名把参数映射到该字典，
+//否则沿用模型中的字典，模型没有字典时使用训练器的字典
+func (tb *trainer_base) restore_dict(model *solver.FtrlSolver) error {
+	if model.Dict == nil {
+		model.Dict = tb.Dict
+		return nil
+	}
+
+	if tb.Dict == nil || tb.Dict.Len() == 0 || tb.Dict.Sign() == model.Dict.Sign() {
+		return nil
+	}
+
+	//训练器的字典由模型的字典增加新特征得到时下标不变，直接使用
+	if tb.Dict.Extends(model.Dict) {
+		model.Dict = tb.Dict
+		return nil
+	}
+
+	kept, dropped, err := model.Remap(tb.Dict)
+	if err != nil {
+		return err
+	}
+
+	tb.log.Info(fmt.Sprintf("[%s] remap model to the feature dictionary, kept=[%d] dropped=[%d]\n", tb.JobName, kept, dropped))
+	return nil
+}
+
+//训练数据的原始特征数超出模型时扩展特征空间，已有特征沿用模型的N、Z，新特征从0开始训练。返回是否扩展
+func (tb *trainer_base) grow_features(model *solver.FtrlSolver, feat_num int) bool {
+	raw := model.RawFeatnum()
+	if !model.Grow(feat_num) {
+		return false
+	}
+
+	tb.log.Info(fmt.Sprintf("[%s] feature space grows from %d to %d, features=[%d]\n", tb.JobName, raw, feat_num, model.Featnum))
+	model.SetMeta("WarmStartFeatnum", fmt.Sprintf("%d", raw))
+	return true
+}
@@ -0,0 +1,100 @@
+package trainer
+
+import (
+	"goline/solver"
+	"goline/util"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//从模型继续训练时新训练数据中的新特征扩展特征空间，已有特征沿用模型的参数
+func TestTrainRestoreGrow(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	grown_file := filepath.Join(dir, "grown.dat")
+	write_synthetic_file(t, train_file, 2000, 1)
+	if err := ioutil.WriteFile(grown_file, []byte(synthetic_data(2000, 2)+"1 70:1 80:1\n0 75:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range trainer_kinds {
+		conf := TrainerConfig{Epoch: 1, NumThreads: 2, BatchSize: 16, JobName: kind}
+		tr, err := NewTrainer(kind, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last := filepath.Join(dir, kind+".last")
+		if err := tr.Train(0.1, 1, 0, 2, 0, last, train_file, ""); err != nil {
+			t.Fatal(err)
+		}
+		old := tr.Model()
+		raw := old.Featnum
+
+		tr, err = NewTrainer(kind, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		model_file := filepath.Join(dir, kind+".dat")
+		if err := tr.TrainRestore(last, model_file, grown_file, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var model solver.FtrlSolver
+		if err := model.Construct(model_file); err != nil {
+			t.Fatal(err)
+		}
+
+		if model.Featnum != 81 || model.L2 != 2 || model.Meta["WarmStartFeatnum"] == "" {
+			t.Fatalf("%s: featnum=%d (was %d) l2=%g meta=%v", kind, model.Featnum, raw, model.L2, model.Meta)
+		}
+
+		if model.Z[80] == 0 || model.Z[75] == 0 {
+			t.Fatalf("%s: new features not trained", kind)
+		}
+	}
+}
+
+//热启动模型的原始特征多于训练数据时特征空间按模型扩展，原始特征和交叉特征按各自的偏移复制
+func TestWarmStartLargerModel(t *testing.T) {
+	dir := t.TempDir()
+	train_file := filepath.Join(dir, "train.dat")
+	large_file := filepath.Join(dir, "large.dat")
+	write_synthetic_file(t, train_file, 2000, 1)
+	if err := ioutil.WriteFile(large_file, []byte(synthetic_data(2000, 2)+"1 70:1 80:1\n0 75:1 80:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cross := &util.CrossConfig{Fields: []util.FieldRange{{Name: "all", Start: 1, End: 100}}, Pairs: [][]string{{"all", "all"}}, HashBits: 18}
+	train := func(name string, model string, train_file string) *solver.FtrlSolver {
+		var fft FastFtrlTrainer
+		fft.Initialize(1, 1, false, 0, DefaultPushStep, DefaultFetchStep)
+		fft.SetJobName(name)
+		fft.SetFeatureCross(cross)
+		if len(model) != 0 {
+			if err := fft.SetBurnIn("", model, ScheduleConstant, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return train_model(t, &fft, 0, filepath.Join(dir, name+".dat"), train_file)
+	}
+
+	last := train("last", "", large_file)
+	if last.Cross.Offset != 81 {
+		t.Fatalf("cross offset %d", last.Cross.Offset)
+	}
+
+	warm := train("warm", filepath.Join(dir, "last.dat"), train_file)
+	if warm.RawFeatnum() != 81 || warm.Featnum != last.Featnum || warm.Cross.Offset != 81 {
+		t.Fatalf("featnum=%d raw=%d offset=%d", warm.Featnum, warm.RawFeatnum(), warm.Cross.Offset)
+	}
+
+	//训练数据中没有的原始特征和交叉特征保留模型的参数
+	for _, idx := range []int{70, 75, 80, last.Cross.CrossIndex(70, 80), last.Cross.CrossIndex(75, 80)} {
+		if last.Z[idx] == 0 || warm.Z[idx] != last.Z[idx] || warm.N[idx] != last.N[idx] {
+			t.Fatalf("param %d: z=%g, model z=%g", idx, warm.Z[idx], last.Z[idx])
+		}
+	}
+}
@@ -17,4 +17,7 @@ type Config struct {
 	NameNodes     string      `json:"NameNodes"`
 	LogModule     string      `json:"LogModule"`
 	Redis         RedisConfig `json:"Redis"`
+
+	Preprocess map[string]PreprocessConfig `json:"Preprocess"` //按biz配置数值特征预处理
+	Cross      map[string]CrossConfig      `json:"Cross"`      //按biz配置特征交叉
 }
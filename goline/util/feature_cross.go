@@ -0,0 +1,200 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	s "strings"
+)
+
+const (
+	DefaultCrossHashBits = 18
+	MaxCrossHashBits     = 30
+)
+
+//特征字段(命名空间)，由下标区间[Start, End)定义
+type FieldRange struct {
+	Name  string `json:"Name"`
+	Start int    `json:"Start"`
+	End   int    `json:"End"`
+}
+
+func (fr *FieldRange) Contains(idx int) bool {
+	return idx >= fr.Start && idx < fr.End
+}
+
+//特征交叉配置
+type CrossConfig struct {
+	Fields   []FieldRange `json:"Fields"`
+	Pairs    [][]string   `json:"Pairs"`    //显式指定交叉的字段对(字段名或"start-end"下标区间)，为空时所有字段两两交叉
+	HashBits int          `json:"HashBits"` //交叉特征哈希空间大小为2^HashBits
+	Offset   int          `json:"Offset"`   //交叉特征起始下标，为0时取训练数据原始特征数
+}
+
+type CrossPair struct {
+	A FieldRange `json:"A"`
+	B FieldRange `json:"B"`
+}
+
+//特征交叉，训练和预测时对样本做相同的变换，随模型一起保存
+type FeatureCross struct {
+	Crosses  []CrossPair `json:"Crosses"`
+	HashBits int         `json:"HashBits"`
+	Offset   int         `json:"Offset"`
+	Init     bool        `json:"Init"`
+}
+
+func parse_field_range(name string, fields []FieldRange) (FieldRange, error) {
+	for i := 0; i < len(fields); i++ {
+		if fields[i].Name == name {
+			return fields[i], nil
+		}
+	}
+
+	sp := s.Split(name, "-")
+	if len(sp) != 2 {
+		return FieldRange{}, errors.New("[FeatureCross] Unknown field " + name)
+	}
+
+	start, err := strconv.Atoi(sp[0])
+	if err != nil {
+		return FieldRange{}, errors.New("[FeatureCross] Field range format error." + err.Error())
+	}
+
+	end, err := strconv.Atoi(sp[1])
+	if err != nil {
+		return FieldRange{}, errors.New("[FeatureCross] Field range format error." + err.Error())
+	}
+
+	return FieldRange{Name: name, Start: start, End: end + 1}, nil
+}
+
+//根据配置生成交叉字段对，返回加入交叉特征后的特征总数
+func (fc *FeatureCross) Initialize(conf CrossConfig, feat_num int) (int, error) {
+	fc.HashBits = conf.HashBits
+	if fc.HashBits == 0 {
+		fc.HashBits = DefaultCrossHashBits
+	}
+
+	if fc.HashBits < 0 || fc.HashBits > MaxCrossHashBits {
+		return feat_num, errors.New(fmt.Sprintf("[FeatureCross-Initialize] Hash bits must be in [1,%d].", MaxCrossHashBits))
+	}
+
+	for i := 0; i < len(conf.Fields); i++ {
+		if conf.Fields[i].Start >= conf.Fields[i].End {
+			return feat_num, errors.New("[FeatureCross-Initialize] Field range error." + conf.Fields[i].Name)
+		}
+	}
+
+	fc.Crosses = nil
+	if len(conf.Pairs) == 0 {
+		for i := 0; i < len(conf.Fields); i++ {
+			for j := i + 1; j < len(conf.Fields); j++ {
+				fc.Crosses = append(fc.Crosses, CrossPair{conf.Fields[i], conf.Fields[j]})
+			}
+		}
+	} else {
+		for i := 0; i < len(conf.Pairs); i++ {
+			if len(conf.Pairs[i]) != 2 {
+				return feat_num, errors.New("[FeatureCross-Initialize] Cross pair must have two fields.")
+			}
+
+			a, err := parse_field_range(conf.Pairs[i][0], conf.Fields)
+			if err != nil {
+				return feat_num, err
+			}
+
+			b, err := parse_field_range(conf.Pairs[i][1], conf.Fields)
+			if err != nil {
+				return feat_num, err
+			}
+
+			fc.Crosses = append(fc.Crosses, CrossPair{a, b})
+		}
+	}
+
+	if len(fc.Crosses) == 0 {
+		return feat_num, errors.New("[FeatureCross-Initialize] No cross pair configured.")
+	}
+
+	//交叉特征不能占用原始特征的下标，否则会覆盖原始特征的权重
+	if conf.Offset < 0 || (conf.Offset > 0 && conf.Offset < feat_num) {
+		return feat_num, errors.New(fmt.Sprintf("[FeatureCross-Initialize] Offset %d overlaps raw features [0,%d).", conf.Offset, feat_num))
+	}
+
+	fc.Offset = conf.Offset
+	if fc.Offset == 0 {
+		fc.Offset = feat_num
+	}
+
+	fc.Init = true
+	return MaxInt(feat_num, fc.Offset+(1<<uint(fc.HashBits))), nil
+}
+
+func hash_cross(a int, b int) uint64 {
+	//splitmix64
+	h := uint64(a)*0x9E3779B97F4A7C15 ^ uint64(b)
+	h ^= h >> 30
+	h *= 0xBF58476D1CE4E5B9
+	h ^= h >> 27
+	h *= 0x94D049BB133111EB
+	h ^= h >> 31
+	return h
+}
+
+func (fc *FeatureCross) CrossIndex(a int, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+
+	return fc.Offset + int(hash_cross(a, b)&(uint64(1)<<uint(fc.HashBits)-1))
+}
+
+//生成交叉特征并追加到样本末尾，fc为nil时原样返回。
+//字段区间有重叠时同一对原始特征可能被多个交叉字段对(或同一字段对正反两次)匹配，只生成一次
+func (fc *FeatureCross) Transform(x Pvector) Pvector {
+	if fc == nil || !fc.Init {
+		return x
+	}
+
+	var res Pvector = make(Pvector, len(x), 2*len(x))
+	copy(res, x)
+
+	seen := make(map[[2]int]bool)
+
+	for k := 0; k < len(fc.Crosses); k++ {
+		cp := &fc.Crosses[k]
+		same := cp.A == cp.B
+		for i := 0; i < len(x); i++ {
+			//跳过偏置
+			if x[i].Index == 0 || !cp.A.Contains(x[i].Index) {
+				continue
+			}
+
+			start := 0
+			if same {
+				start = i + 1
+			}
+
+			for j := start; j < len(x); j++ {
+				if x[j].Index == 0 || j == i || !cp.B.Contains(x[j].Index) {
+					continue
+				}
+
+				a, b := x[i].Index, x[j].Index
+				if a > b {
+					a, b = b, a
+				}
+
+				if seen[[2]int{a, b}] {
+					continue
+				}
+
+				seen[[2]int{a, b}] = true
+				res = append(res, Pair{fc.CrossIndex(a, b), x[i].Value * x[j].Value})
+			}
+		}
+	}
+
+	return res
+}
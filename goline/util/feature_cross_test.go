@@ -0,0 +1,103 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestCrossIndexBounds(t *testing.T) {
+	var fc FeatureCross
+	conf := CrossConfig{
+		Fields:   []FieldRange{{Name: "user", Start: 1, End: 100}, {Name: "item", Start: 100, End: 200}},
+		HashBits: 6}
+
+	feat_num, err := fc.Initialize(conf, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fc.Offset != 200 || feat_num != 200+64 {
+		t.Fatalf("offset=%d feat_num=%d, want 200 and 264", fc.Offset, feat_num)
+	}
+
+	for a := 1; a < 200; a++ {
+		for b := 1; b < 200; b += 7 {
+			idx := fc.CrossIndex(a, b)
+			if idx < fc.Offset || idx >= feat_num {
+				t.Fatalf("CrossIndex(%d,%d)=%d out of [%d,%d)", a, b, idx, fc.Offset, feat_num)
+			}
+
+			if idx != fc.CrossIndex(b, a) {
+				t.Fatalf("CrossIndex(%d,%d) is not symmetric", a, b)
+			}
+		}
+	}
+}
+
+func TestCrossOffsetOverlap(t *testing.T) {
+	conf := CrossConfig{Fields: []FieldRange{{Name: "a", Start: 1, End: 5}, {Name: "b", Start: 5, End: 10}}}
+
+	var fc FeatureCross
+	conf.Offset = 5
+	if _, err := fc.Initialize(conf, 10); err == nil {
+		t.Fatal("offset inside raw features accepted")
+	}
+
+	conf.Offset = -1
+	if _, err := fc.Initialize(conf, 10); err == nil {
+		t.Fatal("negative offset accepted")
+	}
+
+	conf.Offset = 10
+	feat_num, err := fc.Initialize(conf, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if feat_num != 10+(1<<DefaultCrossHashBits) {
+		t.Fatalf("feat_num=%d", feat_num)
+	}
+}
+
+func TestCrossTransformDedup(t *testing.T) {
+	var fc FeatureCross
+	//两个字段对区间重叠，且第二对字段自身区间重叠
+	conf := CrossConfig{
+		Fields: []FieldRange{{Name: "a", Start: 1, End: 4}, {Name: "b", Start: 2, End: 6}},
+		Pairs:  [][]string{{"a", "b"}, {"1-3", "2-5"}}}
+
+	if _, err := fc.Initialize(conf, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	x := Pvector{{Index: 0, Value: 1}, {Index: 1, Value: 1}, {Index: 2, Value: 2}, {Index: 3, Value: 3}, {Index: 5, Value: 5}}
+	res := fc.Transform(x)
+
+	want := map[[2]int]float64{
+		{1, 2}: 2, {1, 3}: 3, {1, 5}: 5,
+		{2, 3}: 6, {2, 5}: 10, {3, 5}: 15}
+	if len(res) != len(x)+len(want) {
+		t.Fatalf("got %d cross features, want %d: %v", len(res)-len(x), len(want), res[len(x):])
+	}
+
+	seen := make(map[int]bool)
+	for i := len(x); i < len(res); i++ {
+		if seen[res[i].Index] {
+			t.Fatalf("cross index %d emitted twice", res[i].Index)
+		}
+		seen[res[i].Index] = true
+	}
+
+	for k, v := range want {
+		idx := fc.CrossIndex(k[0], k[1])
+		found := false
+		for i := len(x); i < len(res); i++ {
+			if res[i].Index == idx && UtilFloat64Equal(res[i].Value, v) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("cross feature %v=%g missing", k, v)
+		}
+	}
+}
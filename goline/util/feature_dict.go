@@ -0,0 +1,302 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	s "strings"
+	"sync"
+)
+
+const (
+	FieldSpliter = "^" //字符串特征格式为 field^name:val 或 name:val
+)
+
+type FeatureEntry struct {
+	Index int    `json:"Index"`
+	Name  string `json:"Name"`
+	Field string `json:"Field"`
+}
+
+func (fe *FeatureEntry) Key() string {
+	if len(fe.Field) == 0 {
+		return fe.Name
+	}
+
+	return fe.Field + FieldSpliter + fe.Name
+}
+
+type evector []FeatureEntry
+
+func (ev evector) Less(i, j int) bool {
+	return ev[i].Index < ev[j].Index
+}
+
+func (ev evector) Len() int {
+	return len(ev)
+}
+
+func (ev evector) Swap(i, j int) {
+	ev[i], ev[j] = ev[j], ev[i]
+}
+
+//特征字典，维护特征名与特征下标的映射，随模型一起保存
+type FeatureDict struct {
+	Entries []FeatureEntry `json:"Entries"`
+	Grow    bool           `json:"-"` //为true时遇到新特征名自动分配下标
+
+	keys  map[string]int
+	names map[int]int
+	next  int
+	lock  sync.RWMutex
+}
+
+func NewFeatureDict() *FeatureDict {
+	fd := &FeatureDict{}
+	fd.rebuild()
+	return fd
+}
+
+func (fd *FeatureDict) rebuild() {
+	fd.keys = make(map[string]int)
+	fd.names = make(map[int]int)
+	//下标0为偏置
+	fd.next = 1
+	for i := 0; i < len(fd.Entries); i++ {
+		fd.keys[fd.Entries[i].Key()] = i
+		fd.names[fd.Entries[i].Index] = i
+		if fd.Entries[i].Index >= fd.next {
+			fd.next = fd.Entries[i].Index + 1
+		}
+	}
+}
+
+func (fd *FeatureDict) UnmarshalJSON(b []byte) error {
+	type entries struct {
+		Entries []FeatureEntry `json:"Entries"`
+	}
+
+	var e entries
+	err := json.Unmarshal(b, &e)
+	if err != nil {
+		return err
+	}
+
+	fd.Entries = e.Entries
+	fd.rebuild()
+	return nil
+}
+
+//流式训练时字典会边训练边增长，保存模型时加锁
+func (fd *FeatureDict) MarshalJSON() ([]byte, error) {
+	type entries struct {
+		Entries []FeatureEntry `json:"Entries"`
+	}
+
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+	return json.Marshal(entries{fd.Entries})
+}
+
+//字典文件每行格式为: index\tname\tfield
+func LoadFeatureDict(path string) (*FeatureDict, error) {
+	fs, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("[LoadFeatureDict] Open file failed." + err.Error())
+	}
+
+	defer fs.Close()
+
+	fd := &FeatureDict{}
+	buf := bufio.NewReader(fs)
+	line_num := 0
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.New("[LoadFeatureDict] Read file failed." + err.Error())
+		}
+
+		line_num++
+		if len(s.TrimSpace(line)) != 0 {
+			sp := s.Split(s.TrimRight(line, "\r\n"), "\t")
+			if len(sp) < 2 {
+				return nil, errors.New(fmt.Sprintf("[LoadFeatureDict] Line %d format error.", line_num))
+			}
+
+			idx, err2 := strconv.Atoi(sp[0])
+			if err2 != nil || idx <= 0 {
+				return nil, errors.New(fmt.Sprintf("[LoadFeatureDict] Line %d index error.", line_num))
+			}
+
+			entry := FeatureEntry{Index: idx, Name: sp[1]}
+			if len(sp) > 2 {
+				entry.Field = sp[2]
+			}
+			fd.Entries = append(fd.Entries, entry)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	fd.rebuild()
+	return fd, nil
+}
+
+func (fd *FeatureDict) Save(path string) error {
+	fout, err := os.OpenFile(path, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.New("[FeatureDict-Save] Open file failed." + err.Error())
+	}
+
+	defer fout.Close()
+
+	fd.lock.RLock()
+	var entries evector = make([]FeatureEntry, len(fd.Entries))
+	copy(entries, fd.Entries)
+	fd.lock.RUnlock()
+
+	sort.Sort(entries)
+
+	w := bufio.NewWriter(fout)
+	for i := 0; i < len(entries); i++ {
+		w.WriteString(fmt.Sprintf("%d\t%s\t%s\n", entries[i].Index, entries[i].Name, entries[i].Field))
+	}
+
+	return w.Flush()
+}
+
+func (fd *FeatureDict) Len() int {
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+	return len(fd.Entries)
+}
+
+//base中的每个特征在fd中的下标都相同时返回true，即fd由base增加新特征得到
+func (fd *FeatureDict) Extends(base *FeatureDict) bool {
+	if fd == base {
+		return true
+	}
+
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+	base.lock.RLock()
+	defer base.lock.RUnlock()
+
+	for i := 0; i < len(base.Entries); i++ {
+		pos, ok := fd.keys[base.Entries[i].Key()]
+		if !ok || fd.Entries[pos].Index != base.Entries[i].Index {
+			return false
+		}
+	}
+
+	return true
+}
+
+//下标空间大小，即最大下标加1
+func (fd *FeatureDict) Size() int {
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+	return fd.next
+}
+
+//根据特征名查找下标，不分配新下标
+func (fd *FeatureDict) Find(key string) (int, bool) {
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+
+	pos, ok := fd.keys[key]
+	if !ok {
+		return 0, false
+	}
+
+	return fd.Entries[pos].Index, true
+}
+
+//根据特征名查找下标，Grow为true时为新特征分配下标
+func (fd *FeatureDict) Lookup(key string) (int, bool) {
+	fd.lock.RLock()
+	pos, ok := fd.keys[key]
+	if ok {
+		idx := fd.Entries[pos].Index
+		fd.lock.RUnlock()
+		return idx, true
+	}
+	grow := fd.Grow
+	fd.lock.RUnlock()
+
+	if !grow || len(key) == 0 {
+		return 0, false
+	}
+
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+	if pos, ok := fd.keys[key]; ok {
+		return fd.Entries[pos].Index, true
+	}
+
+	entry := FeatureEntry{Index: fd.next, Name: key}
+	if sp := s.SplitN(key, FieldSpliter, 2); len(sp) == 2 {
+		entry.Field = sp[0]
+		entry.Name = sp[1]
+	}
+
+	fd.Entries = append(fd.Entries, entry)
+	fd.keys[key] = len(fd.Entries) - 1
+	fd.names[entry.Index] = len(fd.Entries) - 1
+	fd.next++
+
+	return entry.Index, true
+}
+
+//字典签名，与特征顺序无关，用于校验样本缓存是否由同一字典生成
+func (fd *FeatureDict) Sign() uint64 {
+	if fd == nil {
+		return 0
+	}
+
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+
+	//空字典与不使用字典的签名不同
+	var sign uint64 = uint64(len(fd.Entries)) + 1
+	for i := 0; i < len(fd.Entries); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(fd.Entries[i].Key() + "\t" + strconv.Itoa(fd.Entries[i].Index)))
+		sign += h.Sum64()
+	}
+
+	return sign
+}
+
+func (fd *FeatureDict) Entry(idx int) (FeatureEntry, bool) {
+	if fd == nil {
+		return FeatureEntry{}, false
+	}
+
+	fd.lock.RLock()
+	defer fd.lock.RUnlock()
+	pos, ok := fd.names[idx]
+	if !ok {
+		return FeatureEntry{}, false
+	}
+
+	return fd.Entries[pos], true
+}
+
+//返回特征名，字典中不存在时返回下标
+func (fd *FeatureDict) Name(idx int) string {
+	entry, ok := fd.Entry(idx)
+	if !ok {
+		return strconv.Itoa(idx)
+	}
+
+	return entry.Key()
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeatureDictLookupAndSave(t *testing.T) {
+	fd := NewFeatureDict()
+	if _, ok := fd.Lookup("age"); ok {
+		t.Fatal("unknown feature found without Grow")
+	}
+
+	fd.Grow = true
+	a, _ := fd.Lookup("user^age")
+	b, _ := fd.Lookup("city")
+	if a2, _ := fd.Lookup("user^age"); a != 1 || b != 2 || a2 != a {
+		t.Fatalf("indices %d %d %d", a, b, a2)
+	}
+
+	if idx, ok := fd.Find("city"); !ok || idx != b {
+		t.Fatalf("find city=%d %v", idx, ok)
+	}
+
+	if _, ok := fd.Find("age"); ok || fd.Size() != 3 {
+		t.Fatalf("find grew the dict to %d", fd.Size())
+	}
+
+	if entry, ok := fd.Entry(a); !ok || entry.Field != "user" || entry.Name != "age" {
+		t.Fatalf("entry=%+v", entry)
+	}
+
+	if fd.Name(b) != "city" || fd.Name(99) != "99" {
+		t.Fatalf("names %s %s", fd.Name(b), fd.Name(99))
+	}
+
+	path := filepath.Join(t.TempDir(), "dict.txt")
+	if err := fd.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFeatureDict(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Sign() != fd.Sign() || loaded.Size() != 3 || !loaded.Extends(fd) {
+		t.Fatalf("loaded dict differs: %+v", loaded.Entries)
+	}
+
+	data, err := json.Marshal(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored FeatureDict
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if idx, ok := restored.Lookup("user^age"); !ok || idx != a {
+		t.Fatalf("json round trip lookup=%d %v", idx, ok)
+	}
+}
+
+func TestFeatureDictSignAndExtends(t *testing.T) {
+	a := &FeatureDict{Entries: []FeatureEntry{{Index: 1, Name: "x"}, {Index: 2, Name: "y"}}}
+	a.rebuild()
+	b := &FeatureDict{Entries: []FeatureEntry{{Index: 2, Name: "y"}, {Index: 1, Name: "x"}}}
+	b.rebuild()
+	if a.Sign() != b.Sign() {
+		t.Fatal("sign depends on entry order")
+	}
+
+	if NewFeatureDict().Sign() == (*FeatureDict)(nil).Sign() {
+		t.Fatal("empty dict and no dict share a sign")
+	}
+
+	b.Grow = true
+	b.Lookup("z")
+	if !b.Extends(a) || a.Extends(b) {
+		t.Fatal("Extends is wrong")
+	}
+
+	c := &FeatureDict{Entries: []FeatureEntry{{Index: 2, Name: "x"}}}
+	c.rebuild()
+	if c.Extends(a) {
+		t.Fatal("dict with moved index extends base")
+	}
+}
+
+func TestParseSampleWithDict(t *testing.T) {
+	fd := NewFeatureDict()
+	fd.Grow = true
+
+	err, y, x := ParseSampleWithDict("1 user^age:3 7:0.5 city:1", fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//数字形式的特征名也由字典分配下标，不会与字典分配的下标冲突
+	age, _ := fd.Lookup("user^age")
+	seven, _ := fd.Lookup("7")
+	city, _ := fd.Lookup("city")
+	if y != 1 || len(x) != 4 || x[1].Index != age || x[2].Index != seven || x[3].Index != city || seven != 2 {
+		t.Fatalf("y=%g x=%v", y, x)
+	}
+
+	//不使用字典时字符串特征被跳过
+	_, _, x = ParseSample("1 user^age:3 7:0.5")
+	if len(x) != 2 || x[1].Index != 7 {
+		t.Fatalf("x=%v", x)
+	}
+}
@@ -0,0 +1,312 @@
+package util
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	ScaleMinMax   = "minmax"
+	ScaleStandard = "standard"
+
+	MaxBinSamples = 10000
+)
+
+//数值特征预处理规则，依次执行截断、log1p、归一化或等频分箱
+type PreprocessRule struct {
+	Field   FieldRange `json:"Field"`
+	Clip    bool       `json:"Clip"`
+	ClipMin float64    `json:"ClipMin"`
+	ClipMax float64    `json:"ClipMax"`
+	Log1p   bool       `json:"Log1p"`
+	Scale   string     `json:"Scale"` //minmax或standard
+	Bins    int        `json:"Bins"`  //大于0时按等频分箱后one-hot编码，不再做归一化
+}
+
+type PreprocessConfig struct {
+	Rules []PreprocessRule `json:"Rules"`
+}
+
+//单个特征在训练数据上的拟合结果
+type FeatureStat struct {
+	Count  int64     `json:"Count"`
+	Min    float64   `json:"Min"`
+	Max    float64   `json:"Max"`
+	Mean   float64   `json:"Mean"`
+	M2     float64   `json:"M2"`
+	Bounds []float64 `json:"Bounds"`
+}
+
+func (st *FeatureStat) Std() float64 {
+	if st.Count < 2 {
+		return 0.
+	}
+
+	return math.Sqrt(st.M2 / float64(st.Count-1))
+}
+
+func (st *FeatureStat) add(v float64) {
+	if st.Count == 0 || v < st.Min {
+		st.Min = v
+	}
+	if st.Count == 0 || v > st.Max {
+		st.Max = v
+	}
+
+	st.Count++
+	delta := v - st.Mean
+	st.Mean += delta / float64(st.Count)
+	st.M2 += delta * (v - st.Mean)
+}
+
+func (st *FeatureStat) merge(o *FeatureStat) {
+	if o.Count == 0 {
+		return
+	}
+	if st.Count == 0 {
+		*st = *o
+		return
+	}
+
+	n := st.Count + o.Count
+	delta := o.Mean - st.Mean
+	st.M2 += o.M2 + delta*delta*float64(st.Count)*float64(o.Count)/float64(n)
+	st.Mean += delta * float64(o.Count) / float64(n)
+	st.Min = math.Min(st.Min, o.Min)
+	st.Max = math.Max(st.Max, o.Max)
+	st.Count = n
+}
+
+//数值特征预处理，拟合参数随模型一起保存，预测时自动生效
+type FeaturePreprocess struct {
+	Rules     []PreprocessRule     `json:"Rules"`
+	BinOffset []int                `json:"BinOffset"`
+	Stats     map[int]*FeatureStat `json:"Stats"`
+	Init      bool                 `json:"Init"`
+}
+
+//分箱特征追加在原特征空间之后，返回预处理后的特征总数
+func (fp *FeaturePreprocess) Initialize(conf PreprocessConfig, feat_num int) (int, error) {
+	if len(conf.Rules) == 0 {
+		return feat_num, errors.New("[FeaturePreprocess-Initialize] No preprocess rule configured.")
+	}
+
+	fp.Rules = conf.Rules
+	fp.BinOffset = make([]int, len(fp.Rules))
+	fp.Stats = make(map[int]*FeatureStat)
+
+	offset := feat_num
+	for i := 0; i < len(fp.Rules); i++ {
+		rule := &fp.Rules[i]
+		if rule.Field.Start >= rule.Field.End {
+			return feat_num, errors.New("[FeaturePreprocess-Initialize] Field range error." + rule.Field.Name)
+		}
+
+		if rule.Clip && rule.ClipMin > rule.ClipMax {
+			return feat_num, errors.New("[FeaturePreprocess-Initialize] Clip range error." + rule.Field.Name)
+		}
+
+		if rule.Scale != "" && rule.Scale != ScaleMinMax && rule.Scale != ScaleStandard {
+			return feat_num, errors.New("[FeaturePreprocess-Initialize] Unknown scale " + rule.Scale)
+		}
+
+		if rule.Bins > 0 {
+			fp.BinOffset[i] = offset
+			offset += (rule.Field.End - rule.Field.Start) * rule.Bins
+		}
+	}
+
+	return offset, nil
+}
+
+func (fp *FeaturePreprocess) find_rule(idx int) int {
+	for i := 0; i < len(fp.Rules); i++ {
+		if fp.Rules[i].Field.Contains(idx) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//截断和log1p，不依赖拟合参数
+func (fp *FeaturePreprocess) prepare(rule *PreprocessRule, v float64) float64 {
+	if rule.Clip {
+		v = math.Max(math.Min(v, rule.ClipMax), rule.ClipMin)
+	}
+
+	if rule.Log1p {
+		if v < 0 {
+			v = -math.Log1p(-v)
+		} else {
+			v = math.Log1p(v)
+		}
+	}
+
+	return v
+}
+
+func (fp *FeaturePreprocess) Transform(x Pvector) Pvector {
+	if fp == nil || !fp.Init {
+		return x
+	}
+
+	var res Pvector = make(Pvector, 0, len(x))
+	for i := 0; i < len(x); i++ {
+		idx := x[i].Index
+		r := -1
+		if idx != 0 {
+			r = fp.find_rule(idx)
+		}
+
+		if r < 0 {
+			res = append(res, x[i])
+			continue
+		}
+
+		rule := &fp.Rules[r]
+		v := fp.prepare(rule, x[i].Value)
+		st, ok := fp.Stats[idx]
+
+		if rule.Bins > 0 {
+			if !ok {
+				continue
+			}
+
+			b := sort.SearchFloat64s(st.Bounds, v)
+			res = append(res, Pair{fp.BinOffset[r] + (idx-rule.Field.Start)*rule.Bins + b, 1.})
+			continue
+		}
+
+		if ok {
+			switch rule.Scale {
+			case ScaleMinMax:
+				if st.Max > st.Min {
+					v = math.Max(math.Min((v-st.Min)/(st.Max-st.Min), 1.), 0.)
+				} else {
+					v = 0.
+				}
+			case ScaleStandard:
+				if std := st.Std(); std > 0 {
+					v = (v - st.Mean) / std
+				} else {
+					v = 0.
+				}
+			}
+		}
+
+		res = append(res, Pair{idx, v})
+	}
+
+	return res
+}
+
+//在训练数据上收集拟合参数，每个线程各自持有一个
+type PreprocessFitter struct {
+	fp      *FeaturePreprocess
+	stats   map[int]*FeatureStat
+	samples map[int][]float64
+	rd      *rand.Rand
+}
+
+func (fp *FeaturePreprocess) NewFitter(seed int64) *PreprocessFitter {
+	return &PreprocessFitter{
+		fp:      fp,
+		stats:   make(map[int]*FeatureStat),
+		samples: make(map[int][]float64),
+		rd:      rand.New(rand.NewSource(seed))}
+}
+
+func (pf *PreprocessFitter) Add(x Pvector) {
+	for i := 0; i < len(x); i++ {
+		idx := x[i].Index
+		if idx == 0 {
+			continue
+		}
+
+		r := pf.fp.find_rule(idx)
+		if r < 0 {
+			continue
+		}
+
+		rule := &pf.fp.Rules[r]
+		v := pf.fp.prepare(rule, x[i].Value)
+		st, ok := pf.stats[idx]
+		if !ok {
+			st = new(FeatureStat)
+			pf.stats[idx] = st
+		}
+		st.add(v)
+
+		if rule.Bins > 0 {
+			//蓄水池抽样，限制每个特征用于分箱的样本数
+			if len(pf.samples[idx]) < MaxBinSamples {
+				pf.samples[idx] = append(pf.samples[idx], v)
+			} else if k := pf.rd.Int63n(st.Count); k < MaxBinSamples {
+				pf.samples[idx][k] = v
+			}
+		}
+	}
+}
+
+//合并各线程拟合结果，计算等频分箱边界。
+//各线程的蓄水池样本数相同但代表的原始取值数不同，每个样本按所在线程的取值数/样本数加权
+func (fp *FeaturePreprocess) Fit(fitters []*PreprocessFitter) {
+	samples := make(map[int]Dvector)
+	for _, pf := range fitters {
+		for idx, st := range pf.stats {
+			if _, ok := fp.Stats[idx]; !ok {
+				fp.Stats[idx] = new(FeatureStat)
+			}
+			fp.Stats[idx].merge(st)
+		}
+
+		for idx, vals := range pf.samples {
+			if len(vals) == 0 {
+				continue
+			}
+
+			weight := float64(pf.stats[idx].Count) / float64(len(vals))
+			for _, v := range vals {
+				samples[idx] = append(samples[idx], DPair{First: v, Second: weight})
+			}
+		}
+	}
+
+	for idx, vals := range samples {
+		rule := &fp.Rules[fp.find_rule(idx)]
+		fp.Stats[idx].Bounds = weighted_bounds(vals, rule.Bins)
+	}
+
+	fp.Init = true
+}
+
+//加权样本(First取值，Second权重)的等频分箱边界，第k个边界为累计权重首次超过总权重k/bins的取值
+func weighted_bounds(vals Dvector, bins int) []float64 {
+	sort.Sort(vals)
+
+	total := 0.
+	for i := 0; i < len(vals); i++ {
+		total += vals[i].Second
+	}
+
+	var bounds []float64
+	cum := 0.
+	i := 0
+	for k := 1; k < bins; k++ {
+		target := total * float64(k) / float64(bins)
+		for i < len(vals)-1 && cum+vals[i].Second <= target {
+			cum += vals[i].Second
+			i++
+		}
+
+		b := vals[i].First
+		if len(bounds) == 0 || b > bounds[len(bounds)-1] {
+			bounds = append(bounds, b)
+		}
+	}
+
+	return bounds
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestPreprocessFitWeightsReservoirs(t *testing.T) {
+	var fp FeaturePreprocess
+	conf := PreprocessConfig{Rules: []PreprocessRule{{Field: FieldRange{Name: "num", Start: 1, End: 2}, Bins: 4}}}
+	if _, err := fp.Initialize(conf, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	//线程0的取值数是蓄水池的10倍，线程1恰好填满蓄水池，全部数据中91%落在[0,1)
+	rd := rand.New(rand.NewSource(1))
+	fitters := []*PreprocessFitter{fp.NewFitter(1), fp.NewFitter(2)}
+	for i := 0; i < 10*MaxBinSamples; i++ {
+		fitters[0].Add(Pvector{{Index: 1, Value: rd.Float64()}})
+	}
+	for i := 0; i < MaxBinSamples; i++ {
+		fitters[1].Add(Pvector{{Index: 1, Value: 10 + rd.Float64()}})
+	}
+
+	fp.Fit(fitters)
+
+	st := fp.Stats[1]
+	if st.Count != 11*MaxBinSamples {
+		t.Fatalf("count=%d", st.Count)
+	}
+
+	//等频边界约为全部数据的1/4、1/2、3/4分位数，都在[0,1)内
+	want := []float64{0.275, 0.55, 0.825}
+	if len(st.Bounds) != len(want) {
+		t.Fatalf("bounds=%v", st.Bounds)
+	}
+
+	for i := 0; i < len(want); i++ {
+		if math.Abs(st.Bounds[i]-want[i]) > 0.03 {
+			t.Fatalf("bounds=%v, want about %v", st.Bounds, want)
+		}
+	}
+}
+
+func TestWeightedBoundsEqualWeights(t *testing.T) {
+	var vals Dvector
+	for i := 99; i >= 0; i-- {
+		vals = append(vals, DPair{First: float64(i), Second: 1})
+	}
+
+	bounds := weighted_bounds(vals, 4)
+	want := []float64{25, 50, 75}
+	if len(bounds) != len(want) {
+		t.Fatalf("bounds=%v", bounds)
+	}
+
+	for i := 0; i < len(want); i++ {
+		if bounds[i] != want[i] {
+			t.Fatalf("bounds=%v, want %v", bounds, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"math"
+	"sort"
+)
+
+//按秩和计算AUC，scores中First为预估值，Second为label(大于0为正样本)，预估值相同的样本取平均秩。
+//只有一类样本时返回0
+func AUC(scores Dvector) float64 {
+	sort.Sort(scores)
+
+	var num_positive, sum_positive float64
+	for i := 0; i < len(scores); {
+		j := i
+		for j < len(scores) && scores[j].First == scores[i].First {
+			j++
+		}
+
+		//[i, j)内预估值相同，秩为i+1到j的平均值
+		rank := float64(i+1+j) / 2.
+		for k := i; k < j; k++ {
+			if scores[k].Second > 0 {
+				num_positive++
+				sum_positive += rank
+			}
+		}
+		i = j
+	}
+
+	num_negative := float64(len(scores)) - num_positive
+	if num_positive == 0 || num_negative == 0 {
+		return 0.
+	}
+
+	return (sum_positive - num_positive*(num_positive+1)/2.) / (num_positive * num_negative)
+}
+
+const (
+	AUCBuckets    = 1000
+	AUCLogitRange = 10. //分桶覆盖的logit区间[-10, 10]，之外的预估值计入两端的桶
+)
+
+//分桶近似的流式AUC，按预估值的logit把样本计入等宽分桶，同一桶内的正负样本按平局计算。
+//各线程分别累计后合并，用于先预测后更新(progressive validation)时不保留预估值计算AUC
+type StreamingAUC struct {
+	Pos []int64 `json:"Pos"`
+	Neg []int64 `json:"Neg"`
+}
+
+func NewStreamingAUC() *StreamingAUC {
+	return &StreamingAUC{Pos: make([]int64, AUCBuckets), Neg: make([]int64, AUCBuckets)}
+}
+
+func (sa *StreamingAUC) Add(pred float64, y float64) {
+	pred = math.Max(math.Min(pred, MaxSigmoid), MinSigmoid)
+	logit := math.Log(pred / (1. - pred))
+	k := int((logit + AUCLogitRange) / (2. * AUCLogitRange) * AUCBuckets)
+	if k < 0 {
+		k = 0
+	} else if k >= AUCBuckets {
+		k = AUCBuckets - 1
+	}
+
+	if y > 0 {
+		sa.Pos[k]++
+	} else {
+		sa.Neg[k]++
+	}
+}
+
+//累加other的计数并清空other
+func (sa *StreamingAUC) Merge(other *StreamingAUC) {
+	for k := 0; k < len(sa.Pos) && k < len(other.Pos); k++ {
+		sa.Pos[k] += other.Pos[k]
+		sa.Neg[k] += other.Neg[k]
+		other.Pos[k], other.Neg[k] = 0, 0
+	}
+}
+
+//只有一类样本时返回0，与AUC一致
+func (sa *StreamingAUC) AUC() float64 {
+	var num_positive, num_negative, sum float64
+	for k := 0; k < len(sa.Pos); k++ {
+		pos, neg := float64(sa.Pos[k]), float64(sa.Neg[k])
+		sum += pos * (num_negative + neg/2.)
+		num_positive += pos
+		num_negative += neg
+	}
+
+	if num_positive == 0 || num_negative == 0 {
+		return 0.
+	}
+
+	return sum / (num_positive * num_negative)
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestStreamingAUC(t *testing.T) {
+	rd := rand.New(rand.NewSource(3))
+	var scores Dvector
+	parts := []*StreamingAUC{NewStreamingAUC(), NewStreamingAUC()}
+	for i := 0; i < 20000; i++ {
+		y := float64(i % 2)
+		pred := Sigmoid(rd.NormFloat64() + y)
+		scores = append(scores, DPair{First: pred, Second: y})
+		parts[i%2].Add(pred, y)
+	}
+
+	//各线程分别累计后合并，与精确AUC的误差很小
+	sa := NewStreamingAUC()
+	sa.Merge(parts[0])
+	sa.Merge(parts[1])
+	if exact := AUC(scores); math.Abs(sa.AUC()-exact) > 1e-3 {
+		t.Fatalf("streaming auc %g, exact %g", sa.AUC(), exact)
+	}
+
+	if parts[0].AUC() != 0 {
+		t.Fatal("merged counts not cleared")
+	}
+
+	one := NewStreamingAUC()
+	one.Add(0.9, 1)
+	if one.AUC() != 0 || AUC(Dvector{{First: 0.9, Second: 1}}) != 0 {
+		t.Fatal("auc of a single class is not 0")
+	}
+}
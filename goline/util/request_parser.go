@@ -12,15 +12,15 @@ const (
 )
 
 type ModelParam struct {
-	Module, Biz, Src, Dst, Train, Test, Predict, Debug, Threshold string
-	Alpha, Beta, L1, L2, Dropout, Sample                          float64
-	Push, Fetch, Epoch, Threads                                   int
+	Module, Biz, Src, Dst, Train, Test, Predict, Debug, Threshold, Dict, Validate, Split, SplitKey, Stratify, StratifyKey, Reweight, EarlyStop, Checkpoint, Resume, Search, Space, Metric, Deterministic, Lock, Ssp, WarmStart, Progressive string
+	Alpha, Beta, L1, L2, Dropout, Sample, Budget, SplitRatio, PosRate, MinDelta                                                                                                                                                             float64
+	Push, Fetch, Epoch, Threads, TopN, SampleLines, Patience, CheckpointEvery, Timeout, Trials, Parallel, Seed, SyncStep, GroupSize, LockCount, Staleness, ClockStep                                                                        int
 }
 
 func (mp *ModelParam) String() string {
-	return fmt.Sprintf("Module=%s, Biz=%s, Src=%s, Dst=%s, Train=%s, Test=%s, Predict=%s, Debug=%s, Threshold=%s,Alpha=%f, Beta=%f, L1=%f, L2=%f, Dropout=%f, Sample=%f, Push=%d, Fetch=%d, Epoch=%d, Threads=%d",
-		mp.Module, mp.Biz, mp.Src, mp.Dst, mp.Train, mp.Test, mp.Predict, mp.Debug, mp.Threshold,
-		mp.Alpha, mp.Beta, mp.L1, mp.L2, mp.Dropout, mp.Sample, mp.Push, mp.Fetch, mp.Epoch, mp.Threads)
+	return fmt.Sprintf("Module=%s, Biz=%s, Src=%s, Dst=%s, Train=%s, Test=%s, Predict=%s, Debug=%s, Threshold=%s, Dict=%s, Validate=%s, Split=%s, SplitKey=%s, Stratify=%s, StratifyKey=%s, Reweight=%s, EarlyStop=%s, Checkpoint=%s, Resume=%s, Search=%s, Space=%s, Metric=%s, Deterministic=%s, Lock=%s, Ssp=%s, WarmStart=%s, Progressive=%s, Alpha=%f, Beta=%f, L1=%f, L2=%f, Dropout=%f, Sample=%f, Budget=%f, SplitRatio=%f, PosRate=%f, MinDelta=%f, Push=%d, Fetch=%d, Epoch=%d, Threads=%d, TopN=%d, SampleLines=%d, Patience=%d, CheckpointEvery=%d, Timeout=%d, Trials=%d, Parallel=%d, Seed=%d, SyncStep=%d, GroupSize=%d, LockCount=%d, Staleness=%d, ClockStep=%d",
+		mp.Module, mp.Biz, mp.Src, mp.Dst, mp.Train, mp.Test, mp.Predict, mp.Debug, mp.Threshold, mp.Dict, mp.Validate, mp.Split, mp.SplitKey, mp.Stratify, mp.StratifyKey, mp.Reweight, mp.EarlyStop, mp.Checkpoint, mp.Resume, mp.Search, mp.Space, mp.Metric, mp.Deterministic, mp.Lock, mp.Ssp, mp.WarmStart, mp.Progressive,
+		mp.Alpha, mp.Beta, mp.L1, mp.L2, mp.Dropout, mp.Sample, mp.Budget, mp.SplitRatio, mp.PosRate, mp.MinDelta, mp.Push, mp.Fetch, mp.Epoch, mp.Threads, mp.TopN, mp.SampleLines, mp.Patience, mp.CheckpointEvery, mp.Timeout, mp.Trials, mp.Parallel, mp.Seed, mp.SyncStep, mp.GroupSize, mp.LockCount, mp.Staleness, mp.ClockStep)
 }
 
 func String2Float64(elem string) float64 {
@@ -43,7 +43,32 @@ func String2Int(elem string) int {
 
 func ParamParse(r *http.Request) *ModelParam {
 	r.ParseForm()
-	mp := ModelParam{"offline", "", "hdfs", "json", "", "", "", "off", "0.06", 0.1, 1, 10, 10, 0.1, 1, 10, 10, 2, 8}
+	mp := ModelParam{
+		Module:        "offline",
+		Src:           "hdfs",
+		Dst:           "json",
+		Debug:         "off",
+		Threshold:     "0.06",
+		Reweight:      "off",
+		Checkpoint:    "off",
+		Deterministic: "off",
+		Ssp:           "off",
+		Progressive:   "off",
+		Alpha:         0.1,
+		Beta:          1,
+		L1:            10,
+		L2:            10,
+		Dropout:       0.1,
+		Sample:        1,
+		SplitRatio:    0.2,
+		Push:          10,
+		Fetch:         10,
+		Epoch:         2,
+		Threads:       8,
+		TopN:          100,
+		Patience:      1,
+		Staleness:     2,
+	}
 
 	if len(strings.Split(r.URL.String(), "?")) != 0 {
 		mp.Module = strings.Split(r.URL.String(), "?")[0]
@@ -81,6 +106,142 @@ func ParamParse(r *http.Request) *ModelParam {
 		mp.Threshold = r.Form["thd"][0]
 	}
 
+	if len(r.Form["dict"]) != 0 {
+		mp.Dict = r.Form["dict"][0]
+	}
+
+	if len(r.Form["validate"]) != 0 {
+		mp.Validate = r.Form["validate"][0]
+	}
+
+	if len(r.Form["budget"]) != 0 && String2Float64(r.Form["budget"][0]) >= 0 {
+		mp.Budget = String2Float64(r.Form["budget"][0])
+	}
+
+	if len(r.Form["split"]) != 0 {
+		mp.Split = r.Form["split"][0]
+	}
+
+	if len(r.Form["split_key"]) != 0 {
+		mp.SplitKey = r.Form["split_key"][0]
+	}
+
+	if len(r.Form["split_ratio"]) != 0 && String2Float64(r.Form["split_ratio"][0]) >= eps {
+		mp.SplitRatio = String2Float64(r.Form["split_ratio"][0])
+	}
+
+	if len(r.Form["sample_lines"]) != 0 && String2Int(r.Form["sample_lines"][0]) >= 0 {
+		mp.SampleLines = String2Int(r.Form["sample_lines"][0])
+	}
+
+	if len(r.Form["stratify"]) != 0 {
+		mp.Stratify = r.Form["stratify"][0]
+	}
+
+	if len(r.Form["stratify_key"]) != 0 {
+		mp.StratifyKey = r.Form["stratify_key"][0]
+	}
+
+	if len(r.Form["pos_rate"]) != 0 && String2Float64(r.Form["pos_rate"][0]) >= 0 {
+		mp.PosRate = String2Float64(r.Form["pos_rate"][0])
+	}
+
+	if len(r.Form["reweight"]) != 0 {
+		mp.Reweight = r.Form["reweight"][0]
+	}
+
+	if len(r.Form["early_stop"]) != 0 {
+		mp.EarlyStop = r.Form["early_stop"][0]
+	}
+
+	if len(r.Form["patience"]) != 0 && String2Int(r.Form["patience"][0]) > 0 {
+		mp.Patience = String2Int(r.Form["patience"][0])
+	}
+
+	if len(r.Form["min_delta"]) != 0 && String2Float64(r.Form["min_delta"][0]) >= 0 {
+		mp.MinDelta = String2Float64(r.Form["min_delta"][0])
+	}
+
+	if len(r.Form["checkpoint"]) != 0 {
+		mp.Checkpoint = r.Form["checkpoint"][0]
+	}
+
+	if len(r.Form["checkpoint_every"]) != 0 && String2Int(r.Form["checkpoint_every"][0]) >= 0 {
+		mp.CheckpointEvery = String2Int(r.Form["checkpoint_every"][0])
+	}
+
+	if len(r.Form["timeout"]) != 0 && String2Int(r.Form["timeout"][0]) >= 0 {
+		mp.Timeout = String2Int(r.Form["timeout"][0])
+	}
+
+	if len(r.Form["search"]) != 0 {
+		mp.Search = r.Form["search"][0]
+	}
+
+	if len(r.Form["space"]) != 0 {
+		mp.Space = r.Form["space"][0]
+	}
+
+	if len(r.Form["metric"]) != 0 {
+		mp.Metric = r.Form["metric"][0]
+	}
+
+	if len(r.Form["trials"]) != 0 && String2Int(r.Form["trials"][0]) > 0 {
+		mp.Trials = String2Int(r.Form["trials"][0])
+	}
+
+	if len(r.Form["parallel"]) != 0 && String2Int(r.Form["parallel"][0]) > 0 {
+		mp.Parallel = String2Int(r.Form["parallel"][0])
+	}
+
+	if len(r.Form["resume"]) != 0 {
+		mp.Resume = r.Form["resume"][0]
+	}
+
+	if len(r.Form["deterministic"]) != 0 {
+		mp.Deterministic = r.Form["deterministic"][0]
+	}
+
+	if len(r.Form["progressive"]) != 0 {
+		mp.Progressive = r.Form["progressive"][0]
+	}
+
+	if len(r.Form["seed"]) != 0 {
+		mp.Seed = String2Int(r.Form["seed"][0])
+	}
+
+	if len(r.Form["sync_step"]) != 0 && String2Int(r.Form["sync_step"][0]) > 0 {
+		mp.SyncStep = String2Int(r.Form["sync_step"][0])
+	}
+
+	if len(r.Form["ssp"]) != 0 {
+		mp.Ssp = r.Form["ssp"][0]
+	}
+
+	if len(r.Form["staleness"]) != 0 && String2Int(r.Form["staleness"][0]) >= 0 {
+		mp.Staleness = String2Int(r.Form["staleness"][0])
+	}
+
+	if len(r.Form["clock_step"]) != 0 && String2Int(r.Form["clock_step"][0]) > 0 {
+		mp.ClockStep = String2Int(r.Form["clock_step"][0])
+	}
+
+	if len(r.Form["warm_start"]) != 0 {
+		mp.WarmStart = r.Form["warm_start"][0]
+	}
+
+	if len(r.Form["lock"]) != 0 {
+		mp.Lock = r.Form["lock"][0]
+	}
+
+	if len(r.Form["group_size"]) != 0 && String2Int(r.Form["group_size"][0]) > 0 {
+		mp.GroupSize = String2Int(r.Form["group_size"][0])
+	}
+
+	if len(r.Form["lock_count"]) != 0 && String2Int(r.Form["lock_count"][0]) > 0 {
+		mp.LockCount = String2Int(r.Form["lock_count"][0])
+	}
+
 	if len(r.Form["alpha"]) != 0 && String2Float64(r.Form["alpha"][0]) >= eps {
 		mp.Alpha = String2Float64(r.Form["alpha"][0])
 	}
@@ -121,5 +282,9 @@ func ParamParse(r *http.Request) *ModelParam {
 		mp.Threads = String2Int(r.Form["threads"][0])
 	}
 
+	if len(r.Form["topn"]) != 0 && String2Int(r.Form["topn"][0]) >= 0 {
+		mp.TopN = String2Int(r.Form["topn"][0])
+	}
+
 	return &mp
 }
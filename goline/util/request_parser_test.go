@@ -0,0 +1,30 @@
+package util
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParamParseDefaults(t *testing.T) {
+	mp := ParamParse(httptest.NewRequest("GET", "/offline?biz=ad&seed=7&ssp=on&alpha=0.05", nil))
+	if mp.Biz != "ad" || mp.Seed != 7 || mp.Ssp != "on" || mp.Alpha != 0.05 {
+		t.Fatalf("parsed %s", mp)
+	}
+
+	if online := ParamParse(httptest.NewRequest("GET", "/online?progressive=on", nil)); online.Progressive != "on" {
+		t.Fatalf("parsed %s", online)
+	}
+
+	//未指定的参数取默认值
+	if mp.Src != "hdfs" || mp.Dst != "json" || mp.Threshold != "0.06" || mp.Checkpoint != "off" || mp.Deterministic != "off" || mp.Progressive != "off" {
+		t.Fatalf("string defaults %s", mp)
+	}
+
+	if mp.Beta != 1 || mp.L1 != 10 || mp.Dropout != 0.1 || mp.SplitRatio != 0.2 || mp.Budget != 0 {
+		t.Fatalf("float defaults %s", mp)
+	}
+
+	if mp.Epoch != 2 || mp.Threads != 8 || mp.TopN != 100 || mp.Patience != 1 || mp.Staleness != 2 || mp.ClockStep != 0 {
+		t.Fatalf("int defaults %s", mp)
+	}
+}
@@ -0,0 +1,296 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	s "strings"
+	"time"
+)
+
+const (
+	SampleByLabel  = "label"
+	SampleByField  = "field"
+	SampleByTarget = "target"
+
+	SampleRatesSuffix = ".rates"
+	SampleRateDefault = "*" //未列出的分层使用的抽样比例
+)
+
+//抽样后的实际抽样比例，写入[样本文件].rates，训练时按类别比例的倒数对样本加权
+type SampleRates struct {
+	Method string `json:"Method"`
+	Key    string `json:"Key,omitempty"`
+	//各分层的实际抽样比例(保留行数/原始行数)，按label抽样时与ClassRates相同
+	Rates map[string]float64 `json:"Rates"`
+	Total map[string]int64   `json:"Total"`
+	Kept  map[string]int64   `json:"Kept"`
+	//各类别("1"正样本，"0"负样本)的实际抽样比例
+	ClassRates map[string]float64 `json:"ClassRates"`
+	ClassTotal map[string]int64   `json:"ClassTotal"`
+	ClassKept  map[string]int64   `json:"ClassKept"`
+	Invalid    int64              `json:"Invalid"` //label无法解析而丢弃的行数
+}
+
+func NewSampleRates(method string, key string) *SampleRates {
+	return &SampleRates{
+		Method:     method,
+		Key:        key,
+		Rates:      make(map[string]float64),
+		Total:      make(map[string]int64),
+		Kept:       make(map[string]int64),
+		ClassRates: make(map[string]float64),
+		ClassTotal: make(map[string]int64),
+		ClassKept:  make(map[string]int64)}
+}
+
+func SampleRatesPath(path string) string {
+	return path + SampleRatesSuffix
+}
+
+//样本类别，label大于0为正样本
+func SampleClass(y float64) string {
+	if y > 0 {
+		return "1"
+	}
+	return "0"
+}
+
+func (sr *SampleRates) add(stratum string, class string, kept bool) {
+	sr.Total[stratum]++
+	sr.ClassTotal[class]++
+	if kept {
+		sr.Kept[stratum]++
+		sr.ClassKept[class]++
+	}
+}
+
+func (sr *SampleRates) finish() {
+	for k, n := range sr.Total {
+		sr.Rates[k] = float64(sr.Kept[k]) / float64(n)
+	}
+
+	for k, n := range sr.ClassTotal {
+		sr.ClassRates[k] = float64(sr.ClassKept[k]) / float64(n)
+	}
+}
+
+//样本y的训练权重，为其类别实际抽样比例的倒数，sr为nil或比例未知时为1
+func (sr *SampleRates) Weight(y float64) float64 {
+	if sr == nil {
+		return 1.
+	}
+
+	rate, ok := sr.ClassRates[SampleClass(y)]
+	if !ok || rate <= 0 {
+		return 1.
+	}
+
+	return 1. / rate
+}
+
+func (sr *SampleRates) String() string {
+	buf, _ := json.Marshal(sr.ClassRates)
+	return string(buf)
+}
+
+func (sr *SampleRates) Save(path string) error {
+	buf, err := json.Marshal(sr)
+	if err != nil {
+		return errors.New("[SampleRates-Save] Marshal sample rates error." + err.Error())
+	}
+
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+func LoadSampleRates(path string) (*SampleRates, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("[LoadSampleRates] Read sample rates error." + err.Error())
+	}
+
+	sr := NewSampleRates("", "")
+	err = json.Unmarshal(buf, sr)
+	if err != nil {
+		return nil, errors.New("[LoadSampleRates] Parse sample rates error." + err.Error())
+	}
+
+	return sr, nil
+}
+
+//解析分层抽样比例，格式为"stratum:rate,stratum:rate"，如"1:1,0:0.1"或"city^bj:0.5,*:0.1"
+func ParseSampleRates(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, item := range s.Split(spec, ",") {
+		item = s.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		k := s.LastIndex(item, ":")
+		if k <= 0 {
+			return nil, errors.New("[ParseSampleRates] Sample rate format error: " + item)
+		}
+
+		rate, err := strconv.ParseFloat(item[k+1:], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, errors.New("[ParseSampleRates] Sample rate must be in [0,1]: " + item)
+		}
+
+		rates[item[:k]] = rate
+	}
+
+	if len(rates) == 0 {
+		return nil, errors.New("[ParseSampleRates] No sample rate given.")
+	}
+
+	return rates, nil
+}
+
+func line_label(line string) (float64, error) {
+	sp := s.SplitN(line, " ", 2)
+	y, err := strconv.ParseFloat(sp[0], 64)
+	if err != nil {
+		return 0, errors.New("[line_label] Sample label error." + err.Error())
+	}
+
+	return y, nil
+}
+
+//按keep逐行抽样src写入dst，先写临时文件，src与dst可以相同
+func sample_file(src string, dst string, sr *SampleRates, keep func(line string, y float64) (string, bool)) error {
+	tmp := dst + ".tmp"
+	f, w, err := create_file(tmp)
+	if err != nil {
+		return errors.New("[sample_file] Open output file failed." + err.Error())
+	}
+
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	err = scan_lines(src, func(line string) error {
+		y, err := line_label(line)
+		if err != nil {
+			sr.Invalid++
+			return nil
+		}
+
+		stratum, ok := keep(line, y)
+		sr.add(stratum, SampleClass(y), ok)
+		if !ok {
+			return nil
+		}
+
+		_, err = w.WriteString(line + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = w.Flush(); err != nil {
+		return errors.New("[sample_file] Write output file failed." + err.Error())
+	}
+
+	f.Close()
+	if err = os.Rename(tmp, dst); err != nil {
+		return err
+	}
+
+	sr.finish()
+	return sr.Save(SampleRatesPath(dst))
+}
+
+func new_rand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return rand.New(rand.NewSource(seed))
+}
+
+//分层抽样，key为空时按类别("1"/"0")分层，否则按split_key取出的字段值分层，
+//rates中未列出的分层使用rates["*"]，都没有时全部保留。实际比例写入dst.rates
+func StratifiedSample(src string, dst string, key string, rates map[string]float64, seed int64) (*SampleRates, error) {
+	method := SampleByLabel
+	if len(key) != 0 {
+		method = SampleByField
+	}
+
+	sr := NewSampleRates(method, key)
+	randoms := new_rand(seed)
+	rate_of := func(stratum string) float64 {
+		if rate, ok := rates[stratum]; ok {
+			return rate
+		}
+
+		if rate, ok := rates[SampleRateDefault]; ok {
+			return rate
+		}
+
+		return 1.
+	}
+
+	err := sample_file(src, dst, sr, func(line string, y float64) (string, bool) {
+		stratum := SampleClass(y)
+		if len(key) != 0 {
+			stratum, _ = split_key(line, key)
+		}
+
+		rate := rate_of(stratum)
+		return stratum, rate >= 1 || randoms.Float64() < rate
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+//按目标正样本比例抽样，只对占比过多的一类降采样，另一类全部保留。实际比例写入dst.rates
+func TargetRateSample(src string, dst string, pos_rate float64, seed int64) (*SampleRates, error) {
+	if pos_rate <= 0 || pos_rate >= 1 {
+		return nil, errors.New(fmt.Sprintf("[TargetRateSample] Target positive rate %f error.", pos_rate))
+	}
+
+	var pos, neg float64
+	err := scan_lines(src, func(line string) error {
+		y, err := line_label(line)
+		if err != nil {
+			return nil
+		}
+
+		if y > 0 {
+			pos++
+		} else {
+			neg++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pos == 0 || neg == 0 {
+		return nil, errors.New("[TargetRateSample] Both positive and negative samples are needed.")
+	}
+
+	//保留正样本时负样本比例为pos*(1-p)/(p*neg)，大于1说明正样本过多，改为降采样正样本
+	rates := map[string]float64{"1": 1., "0": pos * (1 - pos_rate) / (pos_rate * neg)}
+	if rates["0"] > 1 {
+		rates["1"] = pos_rate * neg / ((1 - pos_rate) * pos)
+		rates["0"] = 1.
+	}
+
+	sr, err := StratifiedSample(src, dst, "", rates, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.Method = SampleByTarget
+	return sr, sr.Save(SampleRatesPath(dst))
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestStratifiedSampleRates(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "all.dat")
+	dst := filepath.Join(dir, "sample.dat")
+	write_split_source(t, src, 2000)
+
+	rates, err := ParseSampleRates("1:1, 0:0.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := StratifiedSample(src, dst, "", rates, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sr.ClassTotal["1"] != 1000 || sr.ClassKept["1"] != 1000 || sr.ClassRates["1"] != 1 {
+		t.Fatalf("positives %d/%d", sr.ClassKept["1"], sr.ClassTotal["1"])
+	}
+
+	//记录的是实际比例，与配置的0.25接近
+	neg_rate := float64(sr.ClassKept["0"]) / 1000
+	if sr.ClassRates["0"] != neg_rate || math.Abs(neg_rate-0.25) > 0.05 {
+		t.Fatalf("negative rate %g", sr.ClassRates["0"])
+	}
+
+	if int64(len(read_lines(t, dst))) != sr.ClassKept["0"]+sr.ClassKept["1"] {
+		t.Fatal("sample file does not match kept counts")
+	}
+
+	if w := sr.Weight(0); math.Abs(w-1/neg_rate) > 1e-9 || sr.Weight(1) != 1 {
+		t.Fatalf("weights %g %g", sr.Weight(0), sr.Weight(1))
+	}
+
+	loaded, err := LoadSampleRates(SampleRatesPath(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.ClassRates["0"] != sr.ClassRates["0"] || loaded.Method != SampleByLabel {
+		t.Fatalf("loaded rates %+v", loaded)
+	}
+}
+
+func TestTargetRateSample(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "all.dat")
+	write_split_source(t, src, 2000)
+
+	//正样本过多时降采样正样本，负样本全部保留
+	sr, err := TargetRateSample(src, src, 0.2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sr.Method != SampleByTarget || sr.ClassRates["0"] != 1 {
+		t.Fatalf("rates=%v", sr.ClassRates)
+	}
+
+	pos := float64(sr.ClassKept["1"]) / float64(sr.ClassKept["1"]+sr.ClassKept["0"])
+	if math.Abs(pos-0.2) > 0.03 {
+		t.Fatalf("positive rate after sampling %g", pos)
+	}
+
+	if _, err := TargetRateSample(src, src, 1, 4); err == nil {
+		t.Fatal("target rate 1 accepted")
+	}
+
+	if _, err := ParseSampleRates("0:2"); err == nil {
+		t.Fatal("rate above 1 accepted")
+	}
+}
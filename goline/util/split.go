@@ -0,0 +1,434 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	s "strings"
+	"time"
+)
+
+const (
+	SplitRandom = "random"
+	SplitHash   = "hash"
+	SplitTime   = "time"
+)
+
+//数据集划分配置
+type SplitConfig struct {
+	Method     string  //random、hash或time
+	TestRatio  float64 //测试集比例
+	ValidRatio float64 //验证集比例，为0时不输出验证集
+	//划分依据的字段: 整数N为样本第N列(从0开始)，"start-end"为该下标区间内的第一个特征，
+	//其它为字符串特征的字段名(field^name)。hash按字段取值划分，time按字段数值(时间戳)划分，
+	//time不指定字段时按行号划分
+	Key  string
+	Seed int64 //random划分的随机种子，为0时使用当前时间
+}
+
+type SplitSummary struct {
+	Lines      int64 `json:"Lines"`
+	TrainLines int64 `json:"TrainLines"`
+	ValidLines int64 `json:"ValidLines"`
+	TestLines  int64 `json:"TestLines"`
+	NoKeyLines int64 `json:"NoKeyLines"` //找不到划分字段的行，划入训练集
+}
+
+//从样本行中取出划分字段的值
+func split_key(line string, key string) (string, bool) {
+	sp := s.Split(line, " ")
+	if pos, err := strconv.Atoi(key); err == nil {
+		if pos < 0 || pos >= len(sp) {
+			return "", false
+		}
+		return sp[pos], true
+	}
+
+	fr, err := parse_field_range(key, nil)
+	for i := 1; i < len(sp); i++ {
+		tup := s.SplitN(sp[i], ":", 2)
+		if len(tup) != 2 {
+			continue
+		}
+
+		if err == nil {
+			idx, err2 := strconv.Atoi(tup[0])
+			if err2 == nil && fr.Contains(idx) {
+				return tup[0], true
+			}
+		} else if s.HasPrefix(tup[0], key+FieldSpliter) {
+			return tup[0], true
+		}
+	}
+
+	return "", false
+}
+
+func hash_unit(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()%1000000) / 1000000.
+}
+
+//逐行读取文件，跳过空行
+func scan_lines(src string, on_line func(line string) error) error {
+	fs, err := os.Open(src)
+	if err != nil {
+		return errors.New("[scan_lines] Open file failed." + err.Error())
+	}
+
+	defer fs.Close()
+
+	buf := bufio.NewReader(fs)
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return errors.New("[scan_lines] Read file failed." + err.Error())
+		}
+
+		if line = s.TrimSpace(line); len(line) != 0 {
+			if err2 := on_line(line); err2 != nil {
+				return err2
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+//time划分时计算验证集和测试集的起点，返回的两个阈值之上分别为验证集和测试集
+func time_bounds(src string, conf *SplitConfig) (float64, float64, error) {
+	var keys []float64
+	var line_no float64 = 0
+	err := scan_lines(src, func(line string) error {
+		if len(conf.Key) == 0 {
+			keys = append(keys, line_no)
+			line_no++
+			return nil
+		}
+
+		key, ok := split_key(line, conf.Key)
+		if !ok {
+			return nil
+		}
+
+		ts, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return errors.New("[SplitDataset] Time key must be numeric, got " + key)
+		}
+
+		keys = append(keys, ts)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, 0, errors.New("[SplitDataset] No time key found in " + src)
+	}
+
+	sort.Float64s(keys)
+	quantile := func(r float64) float64 {
+		k := int(r * float64(len(keys)))
+		if k >= len(keys) {
+			return keys[len(keys)-1] + 1
+		}
+		return keys[k]
+	}
+
+	return quantile(1 - conf.TestRatio - conf.ValidRatio), quantile(1 - conf.TestRatio), nil
+}
+
+func create_file(path string) (*os.File, *bufio.Writer, error) {
+	if len(path) == 0 {
+		return nil, nil, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, bufio.NewWriter(f), nil
+}
+
+//将src划分为训练集、验证集和测试集，valid为空时不输出验证集，src不会被修改
+func SplitDataset(src string, train string, valid string, test string, conf SplitConfig) (*SplitSummary, error) {
+	if conf.TestRatio < 0 || conf.ValidRatio < 0 || conf.TestRatio+conf.ValidRatio >= 1 {
+		return nil, errors.New("[SplitDataset] Split ratio error.")
+	}
+
+	if len(valid) == 0 {
+		conf.ValidRatio = 0
+	}
+
+	if conf.Method == SplitHash && len(conf.Key) == 0 {
+		return nil, errors.New("[SplitDataset] Hash split needs a key field.")
+	}
+
+	var valid_bound, test_bound float64
+	switch conf.Method {
+	case SplitRandom, SplitHash:
+	case SplitTime:
+		var err error
+		valid_bound, test_bound, err = time_bounds(src, &conf)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("[SplitDataset] Unknown split method " + conf.Method)
+	}
+
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	randoms := rand.New(rand.NewSource(seed))
+
+	paths := []string{train, valid, test}
+	outs := make([]*bufio.Writer, len(paths))
+	for i := 0; i < len(paths); i++ {
+		f, w, err := create_file(paths[i])
+		if err != nil {
+			return nil, errors.New("[SplitDataset] Open output file failed." + err.Error())
+		}
+
+		if f != nil {
+			defer f.Close()
+		}
+		outs[i] = w
+	}
+
+	summary := &SplitSummary{}
+	var line_no float64 = 0
+	err := scan_lines(src, func(line string) error {
+		summary.Lines++
+		//0训练集 1验证集 2测试集
+		target := 0
+		switch conf.Method {
+		case SplitRandom, SplitHash:
+			var u float64
+			if conf.Method == SplitRandom {
+				u = randoms.Float64()
+			} else if key, ok := split_key(line, conf.Key); ok {
+				u = hash_unit(key)
+			} else {
+				summary.NoKeyLines++
+				u = 1
+			}
+
+			if u < conf.TestRatio {
+				target = 2
+			} else if u < conf.TestRatio+conf.ValidRatio {
+				target = 1
+			}
+		case SplitTime:
+			ts := line_no
+			line_no++
+			if len(conf.Key) != 0 {
+				key, ok := split_key(line, conf.Key)
+				if !ok {
+					summary.NoKeyLines++
+					break
+				}
+				ts, _ = strconv.ParseFloat(key, 64)
+			}
+
+			if ts >= test_bound {
+				target = 2
+			} else if ts >= valid_bound {
+				target = 1
+			}
+		}
+
+		if target == 1 && outs[1] == nil {
+			target = 0
+		}
+
+		switch target {
+		case 0:
+			summary.TrainLines++
+		case 1:
+			summary.ValidLines++
+		case 2:
+			summary.TestLines++
+		}
+
+		if outs[target] == nil {
+			return nil
+		}
+
+		_, err := outs[target].WriteString(line + "\n")
+		return err
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	for i := 0; i < len(outs); i++ {
+		if outs[i] == nil {
+			continue
+		}
+
+		if err := outs[i].Flush(); err != nil {
+			return summary, errors.New("[SplitDataset] Write output file failed." + err.Error())
+		}
+	}
+
+	return summary, nil
+}
+
+//k折交叉验证的第fold折划分，按行(key不为空时按划分字段)的hash分为k折，第fold折写入test，其余写入train。
+//同一行在各折中的归属固定，k次划分的test互不重叠且合起来为src
+func FoldSplit(src string, train string, test string, k int, fold int, key string) (*SplitSummary, error) {
+	if k < 2 || fold < 0 || fold >= k {
+		return nil, errors.New(fmt.Sprintf("[FoldSplit] Fold %d of %d error.", fold, k))
+	}
+
+	outs := make([]*bufio.Writer, 2)
+	for i, path := range []string{train, test} {
+		f, w, err := create_file(path)
+		if err != nil {
+			return nil, errors.New("[FoldSplit] Open output file failed." + err.Error())
+		}
+
+		defer f.Close()
+		outs[i] = w
+	}
+
+	summary := &SplitSummary{}
+	err := scan_lines(src, func(line string) error {
+		summary.Lines++
+		h := line
+		if len(key) != 0 {
+			var ok bool
+			if h, ok = split_key(line, key); !ok {
+				summary.NoKeyLines++
+				h = line
+			}
+		}
+
+		target := 0
+		if int(hash_unit(h)*float64(k)) == fold {
+			target = 1
+			summary.TestLines++
+		} else {
+			summary.TrainLines++
+		}
+
+		_, err := outs[target].WriteString(line + "\n")
+		return err
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	for i := 0; i < len(outs); i++ {
+		if err := outs[i].Flush(); err != nil {
+			return summary, errors.New("[FoldSplit] Write output file failed." + err.Error())
+		}
+	}
+
+	return summary, nil
+}
+
+//多进程训练时取第part个数据分区写入dst，按行(key不为空时按划分字段)的hash分为parts份，与FoldSplit的划分方式相同，
+//各分区互不重叠且合起来为src，返回分区的行数
+func PartitionFile(src string, dst string, parts int, part int, key string) (int64, error) {
+	if parts < 1 || part < 0 || part >= parts {
+		return 0, errors.New(fmt.Sprintf("[PartitionFile] Part %d of %d error.", part, parts))
+	}
+
+	f, w, err := create_file(dst)
+	if err != nil {
+		return 0, errors.New("[PartitionFile] Open output file failed." + err.Error())
+	}
+	defer f.Close()
+
+	var lines int64 = 0
+	err = scan_lines(src, func(line string) error {
+		h := line
+		if len(key) != 0 {
+			if v, ok := split_key(line, key); ok {
+				h = v
+			}
+		}
+
+		if int(hash_unit(h)*float64(parts)) != part {
+			return nil
+		}
+
+		lines++
+		_, err := w.WriteString(line + "\n")
+		return err
+	})
+	if err != nil {
+		return lines, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return lines, errors.New("[PartitionFile] Write output file failed." + err.Error())
+	}
+
+	return lines, nil
+}
+
+//蓄水池抽样，从src中等概率抽取n行写入dst，src行数不足n时全部保留，保持原文件顺序
+func ReservoirSample(src string, dst string, n int, seed int64) (int64, error) {
+	if n <= 0 {
+		return 0, errors.New(fmt.Sprintf("[ReservoirSample] Sample size %d error.", n))
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	randoms := rand.New(rand.NewSource(seed))
+
+	type item struct {
+		no   int64
+		line string
+	}
+
+	reservoir := make([]item, 0, n)
+	var count int64 = 0
+	err := scan_lines(src, func(line string) error {
+		if len(reservoir) < n {
+			reservoir = append(reservoir, item{count, line})
+		} else if k := randoms.Int63n(count + 1); k < int64(n) {
+			reservoir[k] = item{count, line}
+		}
+
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].no < reservoir[j].no })
+
+	f, w, err := create_file(dst)
+	if err != nil {
+		return 0, errors.New("[ReservoirSample] Open output file failed." + err.Error())
+	}
+
+	defer f.Close()
+	for i := 0; i < len(reservoir); i++ {
+		w.WriteString(reservoir[i].line + "\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, errors.New("[ReservoirSample] Write output file failed." + err.Error())
+	}
+
+	return int64(len(reservoir)), nil
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	s "strings"
+	"testing"
+)
+
+//第i行为 label ts user^u(i%50):1 i:1
+func write_split_source(t *testing.T, path string, lines int) {
+	var text s.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&text, "%d %d user^u%d:1 %d:1\n", i%2, 1000+i, i%50, i+1)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(text.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read_lines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := s.TrimSpace(string(data))
+	if len(text) == 0 {
+		return nil
+	}
+
+	return s.Split(text, "\n")
+}
+
+func TestSplitDataset(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "all.dat")
+	train := filepath.Join(dir, "train.dat")
+	valid := filepath.Join(dir, "valid.dat")
+	test := filepath.Join(dir, "test.dat")
+	write_split_source(t, src, 1000)
+
+	//按时间划分，最后10%为测试集，之前10%为验证集
+	summary, err := SplitDataset(src, train, valid, test, SplitConfig{Method: SplitTime, TestRatio: 0.1, ValidRatio: 0.1, Key: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TrainLines != 800 || summary.ValidLines != 100 || summary.TestLines != 100 {
+		t.Fatalf("summary=%+v", summary)
+	}
+
+	if lines := read_lines(t, test); lines[0] != "0 1900 user^u0:1 901:1" {
+		t.Fatalf("first test line %s", lines[0])
+	}
+
+	//按用户hash划分，同一用户只出现在一个集合中
+	summary, err = SplitDataset(src, train, "", test, SplitConfig{Method: SplitHash, TestRatio: 0.3, Key: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Lines != 1000 || summary.TrainLines+summary.TestLines != 1000 || summary.ValidLines != 0 {
+		t.Fatalf("summary=%+v", summary)
+	}
+
+	users := make(map[string]bool)
+	for _, line := range read_lines(t, train) {
+		key, _ := split_key(line, "user")
+		users[key] = true
+	}
+
+	for _, line := range read_lines(t, test) {
+		if key, _ := split_key(line, "user"); users[key] {
+			t.Fatalf("user %s in both train and test", key)
+		}
+	}
+
+	//随机划分固定种子时可复现
+	SplitDataset(src, train, "", test, SplitConfig{Method: SplitRandom, TestRatio: 0.2, Seed: 5})
+	first := read_lines(t, test)
+	SplitDataset(src, train, "", test, SplitConfig{Method: SplitRandom, TestRatio: 0.2, Seed: 5})
+	if s.Join(first, "\n") != s.Join(read_lines(t, test), "\n") {
+		t.Fatal("random split with the same seed differs")
+	}
+
+	if _, err := SplitDataset(src, train, valid, test, SplitConfig{Method: SplitRandom, TestRatio: 0.6, ValidRatio: 0.4}); err == nil {
+		t.Fatal("ratios summing to 1 accepted")
+	}
+}
+
+func TestFoldSplitCoversSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "all.dat")
+	write_split_source(t, src, 500)
+
+	var tests []string
+	for fold := 0; fold < 5; fold++ {
+		train := filepath.Join(dir, "train.dat")
+		test := filepath.Join(dir, "test.dat")
+		summary, err := FoldSplit(src, train, test, 5, fold, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if summary.TrainLines+summary.TestLines != 500 || summary.TestLines == 0 {
+			t.Fatalf("fold %d summary=%+v", fold, summary)
+		}
+		tests = append(tests, read_lines(t, test)...)
+	}
+
+	sort.Strings(tests)
+	all := read_lines(t, src)
+	sort.Strings(all)
+	if s.Join(tests, "\n") != s.Join(all, "\n") {
+		t.Fatal("test folds do not partition the source")
+	}
+}
+
+func TestReservoirSample(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "all.dat")
+	dst := filepath.Join(dir, "sample.dat")
+	write_split_source(t, src, 300)
+
+	n, err := ReservoirSample(src, dst, 50, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := read_lines(t, dst)
+	if n != 50 || len(lines) != 50 {
+		t.Fatalf("sampled %d lines, file has %d", n, len(lines))
+	}
+
+	//保持原文件顺序
+	last := -1
+	for _, line := range lines {
+		var y, ts int
+		fmt.Sscanf(line, "%d %d", &y, &ts)
+		if ts <= last {
+			t.Fatalf("sample is out of order at %s", line)
+		}
+		last = ts
+	}
+
+	if n, _ := ReservoirSample(src, dst, 1000, 9); n != 300 {
+		t.Fatalf("sample larger than source kept %d lines", n)
+	}
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	s "strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -73,6 +75,35 @@ func UtilParallelRun(f func(int, *sync.WaitGroup), num_threads int) {
 	wg.Wait()
 }
 
+//可取消的多核并行，ctx取消时各线程通过WatchContext置位的标志自行退出，返回ctx.Err()
+func UtilParallelRunContext(ctx context.Context, f func(int, *sync.WaitGroup), num_threads int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	UtilParallelRun(f, num_threads)
+	return ctx.Err()
+}
+
+//ctx取消时将stop置为1，供热点循环用原子读检查，返回的函数结束监听
+func WatchContext(ctx context.Context, stop *int32) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(stop, 1)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
 func UtilFloat64Equal(v1 float64, v2 float64) bool {
 	return math.Abs(v1-v2) < FloatEpsilon
 }
@@ -129,8 +160,13 @@ func Sigmoid(x float64) float64 {
 	return one / (one + SafeExp(-x))
 }
 
+//全局随机数只在启动时播种一次，每次取值都重新播种时同一秒内得到相同的值，且播种的开销远大于取值
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+//并发安全的全局均匀分布随机数，需要可复现时使用各自播种的rand.Rand
 func UniformDistribution() float64 {
-	rand.Seed(time.Now().Unix())
 	return rand.Float64()
 }
 
@@ -169,24 +205,25 @@ func FileExists(filename string) bool {
 
 //样本解析
 func ParseSample(buf string) (error, float64, Pvector) {
+	return ParseSampleWithDict(buf, nil)
+}
+
+//样本解析，dict不为nil时特征名都通过特征字典查找下标
+func ParseSampleWithDict(buf string, dict *FeatureDict) (error, float64, Pvector) {
 	if len(buf) == 0 {
 		return errors.New("[ParseSample] input value error."), 0., nil
 	}
 
 	//要求样本格式为libsvm格式，即：label dim1:val1 dim2:val2 dim3:val3
 	var res []string = s.Split(s.TrimSpace(buf), " ")
-	var start int
-	var length int
+	var start int = 1
 	if len(res) < 2 {
 		return errors.New("[ParseSample] sample format error." + buf), 0, nil
 	}
 
+	//带|f的样本前三列不是特征，与ValidateSample一致
 	if s.Contains(buf, "|f") {
 		start = 3
-		length = len(res) - 3
-	} else {
-		start = 1
-		length = len(res)
 	}
 
 	y, err := strconv.ParseFloat(res[0], 64)
@@ -202,15 +239,21 @@ func ParseSample(buf string) (error, float64, Pvector) {
 	//偏置
 	x = append(x, Pair{0, 1.})
 
-	for i := start; i < length; i++ {
+	for i := start; i < len(res); i++ {
 		var sp []string = s.Split(res[i], ":")
 		if len(sp) != 2 {
 			log.Warn("sample format error [idx:val]." + res[i])
 			continue
 		}
 
-		ix, err := strconv.Atoi(sp[0])
-		if err != nil {
+		//使用字典时所有特征(包括数字形式的特征名)都通过字典分配下标，避免与字典分配的下标冲突
+		var ix int
+		if dict != nil {
+			var ok bool
+			if ix, ok = dict.Lookup(sp[0]); !ok {
+				continue
+			}
+		} else if ix, err = strconv.Atoi(sp[0]); err != nil {
 			log.Warn("parse sample index error:", err)
 			continue
 		}
@@ -421,63 +464,17 @@ func FileSampleWithRatio(src string, ratio float64) error {
 		return err
 	}
 
-	fs, err := os.Open(src + ".bak")
-	if err != nil {
-		return errors.New("[FileSample] Open file failed." + err.Error())
+	//样本整体采样，或负样本采样、正样本保留，实际抽样比例写入src.rates
+	rates := map[string]float64{SampleRateDefault: ratio}
+	if ratio <= 0 {
+		rates = map[string]float64{"1": 1., "0": -ratio}
 	}
 
-	defer fs.Close()
-
-	buf := bufio.NewReader(fs)
-
-	fout, err := os.OpenFile(src, os.O_TRUNC|os.O_RDWR|os.O_CREATE, 0644)
-
+	_, err = StratifiedSample(src+".bak", src, "", rates, 0)
 	if err != nil {
-		return errors.New("[FileSample] sample data writing error." + err.Error())
-	}
-
-	randoms := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for {
-		line, err := buf.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				continue
-			}
-		}
-		line = s.TrimSpace(line)
-		//样本整体采样
-		if ratio > 0 {
-			val := randoms.Float64()
-			if val >= 0 && val < ratio {
-				fout.WriteString(line + "\n")
-			}
-		} else { //负样本采样，正样本保留
-			sp := s.Split(line, " ")
-			if len(sp) > 0 {
-				y, err := strconv.ParseFloat(sp[0], 64)
-				if err != nil {
-					return errors.New("[FileSample] sub sample data label error." + err.Error())
-				}
-
-				if y > 0. {
-					fout.WriteString(line + "\n")
-				} else {
-					val := randoms.Float64()
-					if val >= 0 && val < -ratio {
-						fout.WriteString(line + "\n")
-					}
-				}
-
-			} else {
-				return errors.New("[FileSample] sub sample data writing error.")
-			}
-
-		}
+		return errors.New("[FileSample] sub sample data error." + err.Error())
 	}
 
-	defer fout.Close()
 	return nil
 }
 
@@ -497,6 +494,7 @@ func Write2File(filename string, content string) error {
 	return nil
 }
 
+//按字节数将文件均分为num个文件，切分点对齐到行尾，不会把一行拆到两个文件中
 func SplitFile(filename string, num int) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -509,37 +507,36 @@ func SplitFile(filename string, num int) error {
 		return errors.New("[Tools-SplitFile] Get file info failed:")
 	}
 
-	size := (int(finfo.Size()) - 1) / num
-
-	bufsize := 1024 * 1024
-	if size < bufsize {
-		bufsize = size
-	}
-
-	buf := make([]byte, bufsize)
-
+	size := finfo.Size()
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	var copylen int64 = 0
 	for i := 0; i < num; i++ {
-		copylen := 0
 		newfilename := s.TrimSuffix(filename, ".dat") + strconv.Itoa(i) + ".dat"
 		newfile, err1 := os.OpenFile(newfilename, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-		defer newfile.Close()
-		if err != nil {
+		if err1 != nil {
 			return errors.New("[Tools-SplitFile] Create file error." + err1.Error())
 		}
 
-		for copylen < size {
-			n, err2 := file.Read(buf)
+		writer := bufio.NewWriter(newfile)
+		bound := size * int64(i+1) / int64(num)
+		for copylen < bound {
+			line, err2 := reader.ReadString('\n')
 			if err2 != nil && err2 != io.EOF {
+				newfile.Close()
 				return errors.New("[Tools-SplitFile] Read file error." + err2.Error())
 			}
 
-			if n <= 0 {
+			writer.WriteString(line)
+			copylen += int64(len(line))
+			if err2 == io.EOF {
 				break
 			}
+		}
 
-			w_buf := buf[:n]
-			newfile.Write(w_buf)
-			copylen += n
+		err = writer.Flush()
+		newfile.Close()
+		if err != nil {
+			return errors.New("[Tools-SplitFile] Write file error." + err.Error())
 		}
 	}
 
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"path/filepath"
+	"strconv"
+	s "strings"
+	"testing"
+)
+
+func TestSplitFileOnLineBoundaries(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "train.dat")
+	write_split_source(t, src, 997)
+
+	if err := SplitFile(src, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []string
+	for i := 0; i < 3; i++ {
+		lines := read_lines(t, filepath.Join(dir, "train"+strconv.Itoa(i)+".dat"))
+		if len(lines) < 300 {
+			t.Fatalf("part %d has %d lines", i, len(lines))
+		}
+		all = append(all, lines...)
+	}
+
+	if s.Join(all, "\n") != s.Join(read_lines(t, src), "\n") {
+		t.Fatal("parts do not add up to the source file")
+	}
+}
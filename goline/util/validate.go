@@ -0,0 +1,237 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	s "strings"
+)
+
+const (
+	ErrSpliter = "spliter"
+	ErrLabel   = "label"
+	ErrFeature = "feature"
+	ErrIndex   = "index"
+	ErrValue   = "value"
+	ErrNaNInf  = "nan_inf"
+
+	MaxErrorSamples = 10
+)
+
+type SampleError struct {
+	Type   string `json:"Type"`
+	Line   int64  `json:"Line"`
+	Detail string `json:"Detail"`
+}
+
+func (se *SampleError) Error() string {
+	return fmt.Sprintf("(%s) line %d, %s", se.Type, se.Line, se.Detail)
+}
+
+type ValidateConfig struct {
+	Spliter    string  //特征分隔符
+	Budget     float64 //允许的错误行数，小于1时为错误行比例
+	Quarantine string  //错误行及原因写入的文件，为空时不写
+	Clean      bool    //为true且错误行数未超出预算时从原文件中剔除错误行
+	Named      bool    //为true时允许字符串特征名
+}
+
+type ValidateSummary struct {
+	File             string           `json:"File"`
+	Lines            int64            `json:"Lines"`
+	GoodLines        int64            `json:"GoodLines"`
+	BadLines         int64            `json:"BadLines"`
+	Errors           map[string]int64 `json:"Errors"`
+	Samples          []SampleError    `json:"Samples"`
+	Labels           map[string]int64 `json:"Labels"`
+	MaxIndex         int              `json:"MaxIndex"`
+	DuplicateIndices int64            `json:"DuplicateIndices"`
+	NaNInf           int64            `json:"NaNInf"`
+	Quarantine       string           `json:"Quarantine"`
+}
+
+func (vs *ValidateSummary) add_error(se *SampleError) {
+	vs.BadLines++
+	vs.Errors[se.Type]++
+	if len(vs.Samples) < MaxErrorSamples {
+		vs.Samples = append(vs.Samples, *se)
+	}
+}
+
+//严格校验单行样本，duplicate返回该行重复特征下标数(不作为错误)
+func ValidateSample(line string, spliter string, named bool) (se *SampleError, label float64, max_index int, duplicate int) {
+	sp := s.Split(line, spliter)
+	if len(sp) <= 1 {
+		return &SampleError{Type: ErrSpliter, Detail: "spliter must be " + strconv.Quote(spliter)}, 0, 0, 0
+	}
+
+	label, err := strconv.ParseFloat(sp[0], 64)
+	if err != nil || (label != -1 && label != 0 && label != 1) {
+		return &SampleError{Type: ErrLabel, Detail: "label must be -1,0/1, got " + sp[0]}, 0, 0, 0
+	}
+
+	start := 1
+	if s.Contains(line, "|f") {
+		start = 3
+	}
+
+	seen := make(map[string]bool, len(sp))
+	for i := start; i < len(sp); i++ {
+		if len(sp[i]) == 0 {
+			continue
+		}
+
+		tup := s.Split(sp[i], ":")
+		if len(tup) != 2 {
+			return &SampleError{Type: ErrFeature, Detail: "feature must be key:value, got " + sp[i]}, label, 0, 0
+		}
+
+		idx, err := strconv.Atoi(tup[0])
+		if (err != nil && !named) || (err == nil && idx < 0) || len(tup[0]) == 0 {
+			return &SampleError{Type: ErrIndex, Detail: "feature index error " + sp[i]}, label, 0, 0
+		}
+
+		val, err := strconv.ParseFloat(tup[1], 64)
+		if err != nil {
+			return &SampleError{Type: ErrValue, Detail: "feature value error " + sp[i]}, label, 0, 0
+		}
+
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return &SampleError{Type: ErrNaNInf, Detail: "feature value is NaN or Inf " + sp[i]}, label, 0, 0
+		}
+
+		if seen[tup[0]] {
+			duplicate++
+		}
+		seen[tup[0]] = true
+
+		if idx > max_index {
+			max_index = idx
+		}
+	}
+
+	return nil, label, max_index, duplicate
+}
+
+//逐行校验样本文件，错误行写入隔离文件，错误行数超出预算时返回错误
+func ValidateFile(filename string, conf ValidateConfig) (*ValidateSummary, error) {
+	summary := &ValidateSummary{
+		File:       filename,
+		Errors:     make(map[string]int64),
+		Labels:     make(map[string]int64),
+		Quarantine: conf.Quarantine}
+
+	if len(conf.Spliter) == 0 {
+		conf.Spliter = " "
+	}
+
+	fs, err := os.Open(filename)
+	if err != nil {
+		return summary, errors.New("[ValidateFile] Open file failed." + err.Error())
+	}
+
+	defer fs.Close()
+
+	var qout *bufio.Writer
+	if len(conf.Quarantine) != 0 {
+		qf, err := os.OpenFile(conf.Quarantine, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return summary, errors.New("[ValidateFile] Open quarantine file failed." + err.Error())
+		}
+
+		defer qf.Close()
+		qout = bufio.NewWriter(qf)
+		defer qout.Flush()
+	}
+
+	var cout *bufio.Writer
+	clean_file := filename + ".clean"
+	if conf.Clean {
+		cf, err := os.OpenFile(clean_file, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return summary, errors.New("[ValidateFile] Open clean file failed." + err.Error())
+		}
+
+		defer cf.Close()
+		cout = bufio.NewWriter(cf)
+	}
+
+	buf := bufio.NewReader(fs)
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil && err != io.EOF {
+			if cout != nil {
+				os.Remove(clean_file)
+			}
+			return summary, errors.New("[ValidateFile] Read file failed." + err.Error())
+		}
+
+		trimed := s.TrimSpace(line)
+		if len(trimed) != 0 {
+			summary.Lines++
+			se, label, max_index, duplicate := ValidateSample(trimed, conf.Spliter, conf.Named)
+			if se != nil {
+				se.Line = summary.Lines
+				summary.add_error(se)
+				if se.Type == ErrNaNInf {
+					summary.NaNInf++
+				}
+
+				if qout != nil {
+					qout.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\n", se.Line, se.Type, se.Detail, trimed))
+				}
+			} else {
+				summary.GoodLines++
+				summary.Labels[strconv.FormatFloat(label, 'f', -1, 64)]++
+				summary.MaxIndex = MaxInt(summary.MaxIndex, max_index)
+				if duplicate > 0 {
+					summary.DuplicateIndices++
+				}
+
+				if cout != nil {
+					cout.WriteString(trimed + "\n")
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	budget := conf.Budget
+	if budget < 1 {
+		budget = math.Floor(budget * float64(summary.Lines))
+	}
+
+	//超出预算时保留原文件，删除剔除错误行后的临时文件
+	if float64(summary.BadLines) > budget {
+		if cout != nil {
+			os.Remove(clean_file)
+		}
+
+		str := fmt.Sprintf("[ValidateFile] file %s has %d bad lines, exceeds error budget %.0f", filename, summary.BadLines, budget)
+		if len(summary.Samples) > 0 {
+			str += ", first error " + summary.Samples[0].Error()
+		}
+		return summary, errors.New(str)
+	}
+
+	if cout != nil {
+		err = cout.Flush()
+		if err == nil {
+			err = os.Rename(clean_file, filename)
+		}
+
+		if err != nil {
+			os.Remove(clean_file)
+			return summary, errors.New("[ValidateFile] Write clean file failed." + err.Error())
+		}
+	}
+
+	return summary, nil
+}
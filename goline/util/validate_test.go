@@ -0,0 +1,90 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const validate_data = "1 1:1 2:0.5\n" +
+	"0 1:x\n" +
+	"1 3:2 4:1\n" +
+	"2 1:1\n"
+
+func TestValidateFileCleanOverBudget(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "train.dat")
+	if err := ioutil.WriteFile(filename, []byte(validate_data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ValidateFile(filename, ValidateConfig{Budget: 1, Clean: true})
+	if err == nil {
+		t.Fatal("error budget exceeded but no error returned")
+	}
+
+	if summary.BadLines != 2 || summary.GoodLines != 2 {
+		t.Fatalf("bad=%d good=%d", summary.BadLines, summary.GoodLines)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != validate_data {
+		t.Fatalf("input overwritten after budget failure:\n%s", data)
+	}
+
+	if _, err := os.Stat(filename + ".clean"); !os.IsNotExist(err) {
+		t.Fatal("clean file left behind after budget failure")
+	}
+}
+
+func TestValidateFileCleanWithinBudget(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "train.dat")
+	quarantine := filepath.Join(dir, "train.dat.quarantine")
+	if err := ioutil.WriteFile(filename, []byte(validate_data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ValidateFile(filename, ValidateConfig{Budget: 0.5, Clean: true, Quarantine: quarantine})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Errors[ErrValue] != 1 || summary.Errors[ErrLabel] != 1 {
+		t.Fatalf("errors=%v", summary.Errors)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "1 1:1 2:0.5\n1 3:2 4:1\n" {
+		t.Fatalf("clean file content:\n%s", data)
+	}
+
+	if _, err := os.Stat(filename + ".clean"); !os.IsNotExist(err) {
+		t.Fatal("clean file not renamed")
+	}
+}
+
+func TestParseSampleKeepsValidatedFeatures(t *testing.T) {
+	line := "1 tag |f 1:1 2:2 3:3 4:4"
+	if se, _, max_index, _ := ValidateSample(line, " ", false); se != nil || max_index != 4 {
+		t.Fatalf("validate: %v max_index=%d", se, max_index)
+	}
+
+	err, y, x := ParseSample(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//偏置加4个特征
+	if y != 1 || len(x) != 5 || x[4].Index != 4 || x[4].Value != 4 {
+		t.Fatalf("y=%g x=%v", y, x)
+	}
+}